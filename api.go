@@ -2,18 +2,32 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"log/slog"
+	"slices"
 	"strings"
+	"time"
 
 	asset "cloud.google.com/go/asset/apiv1"
 	"cloud.google.com/go/asset/apiv1/assetpb"
+	"golang.org/x/time/rate"
 	"google.golang.org/api/iam/v1"
+	"google.golang.org/api/logging/v2"
+	"google.golang.org/api/policyanalyzer/v1"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
 )
 
 type ServiceAccountKeys map[string]*iam.ServiceAccountKey
 
-func getServiceAccountKeys(ctx context.Context, iamService *iam.Service, sa string) (ServiceAccountKeys, error) {
-	keys, err := iamService.Projects.ServiceAccounts.Keys.List("projects/-/serviceAccounts/" + sa).Context(ctx).Do()
+// If userProject is set the request is billed to that project instead of the default quota project
+func getServiceAccountKeys(ctx context.Context, iamService *iam.Service, sa string, userProject string) (ServiceAccountKeys, error) {
+	call := iamService.Projects.ServiceAccounts.Keys.List(serviceAccountResourceName(sa))
+	if userProject != "" {
+		call.Header().Set("X-Goog-User-Project", userProject)
+	}
+	keys, err := call.Context(ctx).Do()
 	if err != nil {
 		return nil, err
 	}
@@ -29,12 +43,21 @@ func getServiceAccountKeys(ctx context.Context, iamService *iam.Service, sa stri
 	}
 
 	if num_internal > 3 {
-		fmt.Printf("Warning: More than 3 (%v) internal keys found for %v. Please file a bug report.", num_internal, sa)
+		slog.Warn("More than 3 internal keys found, please file a bug report", "serviceAccount", sa, "keys", num_internal)
 	}
 
 	return res, nil
 }
 
+// If userProject is set the request is billed to that project instead of the default quota project
+func getServiceAccount(ctx context.Context, iamService *iam.Service, sa string, userProject string) (*iam.ServiceAccount, error) {
+	call := iamService.Projects.ServiceAccounts.Get(serviceAccountResourceName(sa))
+	if userProject != "" {
+		call.Header().Set("X-Goog-User-Project", userProject)
+	}
+	return call.Context(ctx).Do()
+}
+
 // Note: we skip any service accounts that are disabled
 func getServiceAccountIDsInProject(ctx context.Context, iamService *iam.Service, project string) ([]string, error) {
 	var serviceAccountIDs []string
@@ -73,3 +96,191 @@ func getServiceAccountIDsViaAssetInventory(ctx context.Context, c *asset.Client,
 
 	return serviceAccountIDs, nil
 }
+
+// Gets the ground truth for every service account under a scope with one asset search for ServiceAccount and
+// ServiceAccountKey assets, instead of a keys.list call for each service account
+// Returns maps of service account email to its keys, and to the service account itself
+func getGroundTruthViaAssetInventory(ctx context.Context, c *asset.Client, scope string) (map[string]ServiceAccountKeys, map[string]*iam.ServiceAccount, error) {
+	keys := map[string]ServiceAccountKeys{}
+	serviceAccounts := map[string]*iam.ServiceAccount{}
+	// keys can refer to their service account by unique ID, so they are matched up once all the service accounts are known
+	emails := map[string]string{}
+	var assetKeys []*iam.ServiceAccountKey
+
+	for res, err := range c.SearchAllResources(ctx, &assetpb.SearchAllResourcesRequest{
+		Scope:      scope,
+		AssetTypes: []string{SERVICE_ACCOUNT_ASSET_TYPE, SERVICE_ACCOUNT_KEY_ASSET_TYPE},
+		// the versioned resources are the same as the IAM API resources, with keyOrigin and keyType
+		ReadMask: &fieldmaskpb.FieldMask{Paths: []string{"name", "asset_type", "versioned_resources"}},
+		PageSize: 500, // max,
+	}).All() {
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(res.VersionedResources) == 0 {
+			continue
+		}
+		data, err := json.Marshal(res.VersionedResources[0].Resource.AsMap())
+		if err != nil {
+			return nil, nil, err
+		}
+
+		switch res.AssetType {
+		case SERVICE_ACCOUNT_ASSET_TYPE:
+			var sa iam.ServiceAccount
+			if err := json.Unmarshal(data, &sa); err != nil {
+				return nil, nil, fmt.Errorf("error unmarshaling asset %v: %v", res.Name, err)
+			}
+			serviceAccounts[sa.Email] = &sa
+			emails[sa.UniqueId] = sa.Email
+		case SERVICE_ACCOUNT_KEY_ASSET_TYPE:
+			var key iam.ServiceAccountKey
+			if err := json.Unmarshal(data, &key); err != nil {
+				return nil, nil, fmt.Errorf("error unmarshaling asset %v: %v", res.Name, err)
+			}
+			assetKeys = append(assetKeys, &key)
+		}
+	}
+
+	for _, key := range assetKeys {
+		// projects/PROJECT/serviceAccounts/EMAIL_OR_UNIQUE_ID/keys/KEY_ID
+		parts := strings.Split(key.Name, "/")
+		if len(parts) != 6 {
+			slog.Warn("Unexpected service account key name in the asset inventory", "name", key.Name)
+			continue
+		}
+		sa, keyID := parts[3], parts[5]
+		if email, ok := emails[sa]; ok {
+			sa = email
+		}
+		if keys[sa] == nil {
+			keys[sa] = ServiceAccountKeys{}
+		}
+		keys[sa][keyID] = key
+	}
+
+	return keys, serviceAccounts, nil
+}
+
+type keyLastAuthenticationActivity struct {
+	LastAuthenticatedTime time.Time `json:"lastAuthenticatedTime"`
+}
+
+// Returns a map of key ID to the last time that key was used to authenticate, for all SA keys in the project
+// Keys which did not authenticate during the activity analyzer's observation period are not included
+func getKeyLastAuthentications(ctx context.Context, policyAnalyzerService *policyanalyzer.Service, project string) (map[string]time.Time, error) {
+	res := map[string]time.Time{}
+
+	parent := "projects/" + project + "/locations/global/activityTypes/serviceAccountKeyLastAuthentication"
+	err := policyAnalyzerService.Projects.Locations.ActivityTypes.Activities.Query(parent).Pages(ctx, func(page *policyanalyzer.GoogleCloudPolicyanalyzerV1QueryActivityResponse) error {
+		for _, activity := range page.Activities {
+			_, keyID, found := strings.Cut(activity.FullResourceName, "/keys/")
+			if !found {
+				continue
+			}
+			var a keyLastAuthenticationActivity
+			if err := json.Unmarshal(activity.Activity, &a); err != nil {
+				return fmt.Errorf("error unmarshaling activity for %v: %v", activity.FullResourceName, err)
+			}
+			res[keyID] = a.LastAuthenticatedTime
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// Summary of the audit log entries for requests authenticated with a specific key
+type KeyUsage struct {
+	count     int
+	lastSeen  time.Time
+	callerIPs []string
+}
+
+type auditLogAuthentication struct {
+	AuthenticationInfo struct {
+		ServiceAccountKeyName string `json:"serviceAccountKeyName"`
+	} `json:"authenticationInfo"`
+	RequestMetadata struct {
+		CallerIP string `json:"callerIp"`
+	} `json:"requestMetadata"`
+}
+
+var errTooManyLogEntries = errors.New("too many log entries")
+
+// Returns a map of key ID to the usage of that key, based on the data access audit logs in the project since the given time
+// Only requests authenticated with a service account key (ie. user managed keys) carry a key name, so system managed keys are never included
+// Each page is a separate entries.list request, so the limiter is waited on before each one
+func getKeyUsageFromAuditLogs(ctx context.Context, loggingService *logging.Service, limiter *rate.Limiter, project string, since time.Time) (map[string]*KeyUsage, error) {
+	res := map[string]*KeyUsage{}
+
+	filter := fmt.Sprintf(`log_id("cloudaudit.googleapis.com/data_access") AND protoPayload.authenticationInfo.serviceAccountKeyName:* AND timestamp>="%v"`, since.UTC().Format(time.RFC3339))
+	req := &logging.ListLogEntriesRequest{
+		ResourceNames: []string{"projects/" + project},
+		Filter:        filter,
+		OrderBy:       "timestamp desc",
+		PageSize:      1000,
+	}
+	entries := 0
+	handlePage := func(page *logging.ListLogEntriesResponse) error {
+		for _, entry := range page.Entries {
+			var payload auditLogAuthentication
+			if err := json.Unmarshal(entry.ProtoPayload, &payload); err != nil {
+				return fmt.Errorf("error unmarshaling audit log entry %v: %v", entry.InsertId, err)
+			}
+			_, keyID, found := strings.Cut(payload.AuthenticationInfo.ServiceAccountKeyName, "/keys/")
+			if !found {
+				continue
+			}
+			timestamp, err := time.Parse(time.RFC3339Nano, entry.Timestamp)
+			if err != nil {
+				return fmt.Errorf("error parsing timestamp of audit log entry %v: %v", entry.InsertId, err)
+			}
+
+			usage, ok := res[keyID]
+			if !ok {
+				usage = &KeyUsage{}
+				res[keyID] = usage
+			}
+			usage.count++
+			if timestamp.After(usage.lastSeen) {
+				usage.lastSeen = timestamp
+			}
+			ip := payload.RequestMetadata.CallerIP
+			if ip != "" && !slices.Contains(usage.callerIPs, ip) {
+				usage.callerIPs = append(usage.callerIPs, ip)
+			}
+
+			entries++
+			if entries >= AuditLogMaxEntriesPerProject {
+				return errTooManyLogEntries
+			}
+		}
+		return nil
+	}
+	var err error
+	for {
+		if err = limiter.Wait(ctx); err != nil {
+			break
+		}
+		var page *logging.ListLogEntriesResponse
+		page, err = loggingService.Entries.List(req).Context(ctx).Do()
+		if err != nil {
+			break
+		}
+		if err = handlePage(page); err != nil || page.NextPageToken == "" {
+			break
+		}
+		req.PageToken = page.NextPageToken
+	}
+	if errors.Is(err, errTooManyLogEntries) {
+		slog.Warn("Too many key authentications found in the audit logs, only the most recent were counted", "project", project, "max", AuditLogMaxEntriesPerProject)
+	} else if err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
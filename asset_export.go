@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"google.golang.org/api/bigquery/v2"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/storage/v1"
+)
+
+const SERVICE_ACCOUNT_ASSET_TYPE = "iam.googleapis.com/ServiceAccount"
+const SERVICE_ACCOUNT_KEY_ASSET_TYPE = "iam.googleapis.com/ServiceAccountKey"
+
+// A single line of a newline-delimited JSON asset export
+// Exports to GCS use snake_case, but accept the camelCase of the API too
+type exportedAsset struct {
+	AssetType      string `json:"asset_type"`
+	AssetTypeCamel string `json:"assetType"`
+	Resource       struct {
+		Data struct {
+			Email    string `json:"email"`
+			Disabled bool   `json:"disabled"`
+		} `json:"data"`
+	} `json:"resource"`
+}
+
+// Reads the emails of the enabled service accounts from an asset inventory export, which can be
+// a local or gs:// newline-delimited JSON file, or a bq://PROJECT.DATASET.TABLE BigQuery table
+func getServiceAccountIDsFromAssetExport(ctx context.Context, uri string) ([]string, error) {
+	if table, ok := strings.CutPrefix(uri, "bq://"); ok {
+		return getServiceAccountIDsFromBigQuery(ctx, bigqueryService(), table)
+	}
+	if path, ok := strings.CutPrefix(uri, "gs://"); ok {
+		return getServiceAccountIDsFromGCS(ctx, storageService(), path)
+	}
+
+	f, err := os.Open(uri)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return parseAssetExport(f, uri)
+}
+
+func parseAssetExport(r io.Reader, name string) ([]string, error) {
+	scanner := bufio.NewScanner(r)
+	// assets can be much larger than the default 64KB line limit
+	scanner.Buffer(make([]byte, 0, 1024*1024), 64*1024*1024)
+
+	var res []string
+	line := 0
+	for scanner.Scan() {
+		line++
+		if len(strings.TrimSpace(scanner.Text())) == 0 {
+			continue
+		}
+		var asset exportedAsset
+		if err := json.Unmarshal(scanner.Bytes(), &asset); err != nil {
+			return nil, fmt.Errorf("%v:%d: unable to parse asset: %v", name, line, err)
+		}
+		if asset.AssetType != SERVICE_ACCOUNT_ASSET_TYPE && asset.AssetTypeCamel != SERVICE_ACCOUNT_ASSET_TYPE {
+			continue
+		}
+		if asset.Resource.Data.Disabled || asset.Resource.Data.Email == "" {
+			continue
+		}
+		res = append(res, asset.Resource.Data.Email)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("unable to read %v: %v", name, err)
+	}
+	return res, nil
+}
+
+// Reads BUCKET/OBJECT, or all the objects starting with BUCKET/PREFIX if it ends in *, since
+// large exports are often split into several files
+func getServiceAccountIDsFromGCS(ctx context.Context, svc *storage.Service, path string) ([]string, error) {
+	bucket, object, ok := strings.Cut(path, "/")
+	if !ok || object == "" {
+		return nil, fmt.Errorf("invalid GCS path gs://%v, expected gs://BUCKET/OBJECT", path)
+	}
+
+	objects := []string{object}
+	if prefix, ok := strings.CutSuffix(object, "*"); ok {
+		objects = nil
+		err := svc.Objects.List(bucket).Prefix(prefix).Pages(ctx, func(page *storage.Objects) error {
+			for _, o := range page.Items {
+				objects = append(objects, o.Name)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("unable to list gs://%v: %v", path, err)
+		}
+	}
+
+	var res []string
+	for _, o := range objects {
+		name := "gs://" + bucket + "/" + o
+		resp, err := svc.Objects.Get(bucket, o).Context(ctx).Download()
+		if err != nil {
+			return nil, fmt.Errorf("unable to download %v: %v", name, err)
+		}
+		serviceAccountIDs, err := parseAssetExport(resp.Body, name)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, serviceAccountIDs...)
+	}
+	return res, nil
+}
+
+// Queries a BigQuery asset export table, the query runs in the quota project if set, or else the project of the table
+// Tables with several snapshots will return duplicates, which are removed with the rest of the input
+func getServiceAccountIDsFromBigQuery(ctx context.Context, svc *bigquery.Service, table string) ([]string, error) {
+	tableProject, _, ok := strings.Cut(table, ".")
+	if !ok || strings.Contains(table, "`") {
+		return nil, fmt.Errorf("invalid BigQuery table %v, expected bq://PROJECT.DATASET.TABLE", table)
+	}
+	jobProject := tableProject
+	if *quotaProject != "" {
+		jobProject = *quotaProject
+	}
+
+	query := fmt.Sprintf("SELECT JSON_VALUE(resource.data, '$.email') FROM `%v` WHERE asset_type = '%v' AND IFNULL(JSON_VALUE(resource.data, '$.disabled'), 'false') != 'true'", table, SERVICE_ACCOUNT_ASSET_TYPE)
+	job, err := svc.Jobs.Query(jobProject, &bigquery.QueryRequest{
+		Query:        query,
+		UseLegacySql: googleapi.Bool(false),
+	}).Context(ctx).Do()
+	if err != nil {
+		return nil, fmt.Errorf("unable to query %v: %v", table, err)
+	}
+
+	var res []string
+	call := svc.Jobs.GetQueryResults(job.JobReference.ProjectId, job.JobReference.JobId).Location(job.JobReference.Location)
+	for {
+		page, err := call.Context(ctx).Do()
+		if err != nil {
+			return nil, fmt.Errorf("unable to get results of query on %v: %v", table, err)
+		}
+		// the call waits for a while for the job to complete, so just poll again if it hasn't
+		if !page.JobComplete {
+			continue
+		}
+		for _, row := range page.Rows {
+			if email, ok := row.F[0].V.(string); ok && email != "" {
+				res = append(res, email)
+			}
+		}
+		if page.PageToken == "" {
+			break
+		}
+		call.PageToken(page.PageToken)
+	}
+	return res, nil
+}
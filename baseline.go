@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"regexp"
+	"time"
+
+	"google.golang.org/api/iam/v1"
+	"gopkg.in/yaml.v3"
+)
+
+// A baseline lists accepted keys, which are still reported but as suppressed rather than bad
+type Baseline struct {
+	Accepted []BaselineEntry `yaml:"accepted"`
+}
+
+type BaselineEntry struct {
+	ServiceAccount string    `yaml:"serviceAccount"`
+	KeyID          string    `yaml:"keyId"`
+	Expires        time.Time `yaml:"expires"`
+	Justification  string    `yaml:"justification"`
+}
+
+// Why a bad key is not counted as a failure
+type Suppression struct {
+	reason string
+	// as written, so a date means the end of that day (see expiryTime), zero if the suppression doesn't expire
+	expires time.Time
+}
+
+func loadBaseline(path string) (*Baseline, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading baseline %v: %v", path, err)
+	}
+
+	var baseline Baseline
+	if err := yaml.Unmarshal(b, &baseline); err != nil {
+		return nil, fmt.Errorf("error parsing baseline %v: %v", path, err)
+	}
+
+	for i, entry := range baseline.Accepted {
+		if entry.ServiceAccount == "" || entry.KeyID == "" {
+			return nil, fmt.Errorf("baseline %v: entry %d must have a serviceAccount and keyId", path, i+1)
+		}
+		if entry.Expires.IsZero() {
+			return nil, fmt.Errorf("baseline %v: entry for key %v of %v must have an expires date", path, entry.KeyID, entry.ServiceAccount)
+		}
+		if entry.Justification == "" {
+			return nil, fmt.Errorf("baseline %v: entry for key %v of %v must have a justification", path, entry.KeyID, entry.ServiceAccount)
+		}
+	}
+	return &baseline, nil
+}
+
+// A date without a time (like expires: 2025-06-30) is parsed as midnight UTC, but means the entry is valid until the
+// end of that day, so it expires at midnight UTC the day after
+func expiryTime(expires time.Time) time.Time {
+	if expires.Equal(expires.UTC().Truncate(24 * time.Hour)) {
+		return expires.AddDate(0, 0, 1)
+	}
+	return expires
+}
+
+// Returns nil if the key is not in the baseline, or if its entry has expired
+func (b *Baseline) match(serviceAccount string, keyID string, now time.Time) *Suppression {
+	for _, entry := range b.Accepted {
+		if entry.ServiceAccount != serviceAccount || entry.KeyID != keyID {
+			continue
+		}
+		if !now.Before(expiryTime(entry.Expires)) {
+			slog.Warn("Baseline entry expired", "serviceAccount", serviceAccount, "keyId", keyID, "expires", entry.Expires.Format(time.DateOnly))
+			return nil
+		}
+		return &Suppression{
+			reason:  "baseline: " + entry.Justification,
+			expires: entry.Expires,
+		}
+	}
+	return nil
+}
+
+// Patterns from --ignore-sa and --ignore-key-id, for quick exclusions without a baseline
+type IgnoreList struct {
+	serviceAccounts []Pattern
+	keyIDs          []Pattern
+}
+
+func (i *IgnoreList) match(serviceAccount string, keyID string) *Suppression {
+	for _, p := range i.serviceAccounts {
+		if p.match(serviceAccount) {
+			return &Suppression{reason: "ignored by --ignore-sa " + p.raw}
+		}
+	}
+	for _, p := range i.keyIDs {
+		if p.match(keyID) {
+			return &Suppression{reason: "ignored by --ignore-key-id " + p.raw}
+		}
+	}
+	return nil
+}
+
+// Teams can document exceptions on the service account itself by adding this to its description
+var suppressionAnnotation = regexp.MustCompile(`sa-key-checker:ignore=([^:\s]+):(\d{4}-\d{2}-\d{2})`)
+
+// Returns nil if the service account has no non-expired annotation for the key
+func matchAnnotation(serviceAccount *iam.ServiceAccount, keyID string, now time.Time) *Suppression {
+	if serviceAccount == nil {
+		return nil
+	}
+	for _, m := range suppressionAnnotation.FindAllStringSubmatch(serviceAccount.Description, -1) {
+		if m[1] != keyID {
+			continue
+		}
+		expires, err := time.Parse(time.DateOnly, m[2])
+		if err != nil {
+			slog.Warn("Invalid expiry date in annotation", "serviceAccount", serviceAccount.Email, "annotation", m[0], "error", err)
+			continue
+		}
+		if !now.Before(expiryTime(expires)) {
+			slog.Warn("Annotation expired", "serviceAccount", serviceAccount.Email, "keyId", keyID, "expires", m[2])
+			continue
+		}
+		return &Suppression{
+			reason:  "annotation on service account: " + m[0],
+			expires: expires,
+		}
+	}
+	return nil
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+)
+
+// An on-disk cache of the x509 endpoint responses, which are used as is while they are younger than the ttl, and
+// revalidated with conditional requests after that
+type X509Cache struct {
+	dir string
+	ttl time.Duration
+	// ignore the cached responses, but still cache the new ones
+	refresh bool
+
+	// for the cache stats
+	hits        atomic.Int64
+	revalidated atomic.Int64
+	misses      atomic.Int64
+}
+
+type cachedResponse struct {
+	ETag         string    `json:"etag,omitempty"`
+	LastModified string    `json:"lastModified,omitempty"`
+	FetchedAt    time.Time `json:"fetchedAt"`
+	Body         string    `json:"body"`
+}
+
+// nil unless --cache-dir was given
+var x509Cache *X509Cache
+
+func NewX509Cache(dir string, ttl time.Duration, refresh bool) (*X509Cache, error) {
+	dir = filepath.Join(dir, "x509")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("error creating cache directory %v: %v", dir, err)
+	}
+	return &X509Cache{dir: dir, ttl: ttl, refresh: refresh}, nil
+}
+
+func (c *X509Cache) path(sa string) string {
+	return filepath.Join(c.dir, url.PathEscape(sa)+".json")
+}
+
+// Returns nil if the service account isn't cached, or the cache can't be read
+func (c *X509Cache) get(sa string) *cachedResponse {
+	if c == nil || c.refresh {
+		return nil
+	}
+	b, err := os.ReadFile(c.path(sa))
+	if err != nil {
+		return nil
+	}
+	var res cachedResponse
+	if err := json.Unmarshal(b, &res); err != nil {
+		return nil
+	}
+	return &res
+}
+
+// Whether the cached response can be used without asking the endpoint
+func (c *X509Cache) isFresh(r *cachedResponse) bool {
+	if time.Since(r.FetchedAt) >= c.ttl {
+		return false
+	}
+	c.hits.Add(1)
+	return true
+}
+
+func (c *X509Cache) put(sa string, header http.Header, body []byte) {
+	if c == nil {
+		return
+	}
+	c.misses.Add(1)
+	c.write(sa, &cachedResponse{
+		ETag:         header.Get("ETag"),
+		LastModified: header.Get("Last-Modified"),
+		FetchedAt:    time.Now(),
+		Body:         string(body),
+	})
+}
+
+// The endpoint confirmed the cached response is still current, so it is fresh again
+func (c *X509Cache) touch(sa string, r *cachedResponse) {
+	c.revalidated.Add(1)
+	r.FetchedAt = time.Now()
+	c.write(sa, r)
+}
+
+func (c *X509Cache) write(sa string, r *cachedResponse) {
+	data, err := json.Marshal(r)
+	if err != nil {
+		slog.Warn("Unable to cache the certificates", "serviceAccount", sa, "error", err)
+		return
+	}
+	if err := writeFileAtomic(c.path(sa), data); err != nil {
+		slog.Warn("Unable to cache the certificates", "serviceAccount", sa, "error", err)
+	}
+}
+
+func (c *X509Cache) printStats() {
+	fmt.Printf("Certificate cache: %d hits, %d revalidated, %d fetched\n", c.hits.Load(), c.revalidated.Load(), c.misses.Load())
+}
+
+// Makes the request conditional on the cached response still being current
+func (r *cachedResponse) setConditionalHeaders(req *http.Request) {
+	if r.ETag != "" {
+		req.Header.Set("If-None-Match", r.ETag)
+	}
+	if r.LastModified != "" {
+		req.Header.Set("If-Modified-Since", r.LastModified)
+	}
+}
+
+// An on-disk cache of the IAM API responses in ground truth mode, so iterating on the output or filters during an
+// incident doesn't spend the per project IAM quota again. Keys change more often than certificates are published,
+// so the ttl is short, and expired responses are fetched again as IAM has no conditional requests
+type GroundTruthCache struct {
+	dir string
+	ttl time.Duration
+	// ignore the cached responses, but still cache the new ones
+	refresh bool
+}
+
+type cachedAPIResponse struct {
+	FetchedAt time.Time       `json:"fetchedAt"`
+	Response  json.RawMessage `json:"response"`
+}
+
+// nil unless --cache-dir was given
+var groundTruthCache *GroundTruthCache
+
+func NewGroundTruthCache(dir string, ttl time.Duration, refresh bool) (*GroundTruthCache, error) {
+	for _, kind := range []string{"keys", "serviceaccounts"} {
+		if err := os.MkdirAll(filepath.Join(dir, kind), 0755); err != nil {
+			return nil, fmt.Errorf("error creating cache directory %v: %v", dir, err)
+		}
+	}
+	return &GroundTruthCache{dir: dir, ttl: ttl, refresh: refresh}, nil
+}
+
+func (c *GroundTruthCache) path(kind string, sa string) string {
+	return filepath.Join(c.dir, kind, url.PathEscape(sa)+".json")
+}
+
+// Unmarshals the cached response into v, returns false if there is none younger than the ttl
+func (c *GroundTruthCache) get(kind string, sa string, v any) bool {
+	if c == nil || c.refresh {
+		return false
+	}
+	b, err := os.ReadFile(c.path(kind, sa))
+	if err != nil {
+		return false
+	}
+	var res cachedAPIResponse
+	if err := json.Unmarshal(b, &res); err != nil || time.Since(res.FetchedAt) >= c.ttl {
+		return false
+	}
+	return json.Unmarshal(res.Response, v) == nil
+}
+
+func (c *GroundTruthCache) put(kind string, sa string, v any) {
+	if c == nil {
+		return
+	}
+	response, err := json.Marshal(v)
+	if err == nil {
+		var data []byte
+		data, err = json.Marshal(cachedAPIResponse{FetchedAt: time.Now(), Response: response})
+		if err == nil {
+			err = writeFileAtomic(c.path(kind, sa), data)
+		}
+	}
+	if err != nil {
+		slog.Warn("Unable to cache the ground truth", "kind", kind, "serviceAccount", sa, "error", err)
+	}
+}
+
+// The file is renamed into place, so a concurrent or interrupted run never reads a partial file, and the temporary
+// file is unique so concurrent runs writing the same file don't write into each other's
+func writeFileAtomic(path string, data []byte) error {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		// CreateTemp makes it 0600
+		err = os.Chmod(f.Name(), 0644)
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
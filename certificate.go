@@ -0,0 +1,152 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// The whole decoded certificate of a key, for --show-cert
+type CertificateReport struct {
+	Version            int               `json:"version"`
+	SerialNumber       string            `json:"serialNumber"`
+	Subject            string            `json:"subject"`
+	Issuer             string            `json:"issuer"`
+	NotBefore          time.Time         `json:"notBefore"`
+	NotAfter           time.Time         `json:"notAfter"`
+	SignatureAlgorithm string            `json:"signatureAlgorithm"`
+	PublicKeyAlgorithm string            `json:"publicKeyAlgorithm"`
+	PublicKeyBits      int               `json:"publicKeyBits,omitempty"`
+	SPKISHA256         string            `json:"spkiSha256"`
+	SHA256             string            `json:"sha256"` // hex SHA-256 of the DER encoded certificate
+	IsCA               bool              `json:"isCA"`
+	KeyUsage           []string          `json:"keyUsage"`
+	ExtKeyUsage        []string          `json:"extKeyUsage"`
+	Extensions         []ExtensionReport `json:"extensions"`
+}
+
+type ExtensionReport struct {
+	OID      string `json:"oid"`
+	Name     string `json:"name,omitempty"`
+	Critical bool   `json:"critical"`
+}
+
+var keyUsageNames = []struct {
+	usage x509.KeyUsage
+	name  string
+}{
+	{x509.KeyUsageDigitalSignature, "digitalSignature"},
+	{x509.KeyUsageContentCommitment, "contentCommitment"},
+	{x509.KeyUsageKeyEncipherment, "keyEncipherment"},
+	{x509.KeyUsageDataEncipherment, "dataEncipherment"},
+	{x509.KeyUsageKeyAgreement, "keyAgreement"},
+	{x509.KeyUsageCertSign, "keyCertSign"},
+	{x509.KeyUsageCRLSign, "cRLSign"},
+	{x509.KeyUsageEncipherOnly, "encipherOnly"},
+	{x509.KeyUsageDecipherOnly, "decipherOnly"},
+}
+
+var extKeyUsageNames = map[x509.ExtKeyUsage]string{
+	x509.ExtKeyUsageAny:             "any",
+	x509.ExtKeyUsageServerAuth:      "serverAuth",
+	x509.ExtKeyUsageClientAuth:      "clientAuth",
+	x509.ExtKeyUsageCodeSigning:     "codeSigning",
+	x509.ExtKeyUsageEmailProtection: "emailProtection",
+	x509.ExtKeyUsageTimeStamping:    "timeStamping",
+	x509.ExtKeyUsageOCSPSigning:     "OCSPSigning",
+}
+
+// https://www.rfc-editor.org/rfc/rfc5280#section-4.2.1
+var extensionNames = map[string]string{
+	"2.5.29.14": "subjectKeyIdentifier",
+	"2.5.29.15": "keyUsage",
+	"2.5.29.17": "subjectAltName",
+	"2.5.29.19": "basicConstraints",
+	"2.5.29.31": "cRLDistributionPoints",
+	"2.5.29.32": "certificatePolicies",
+	"2.5.29.35": "authorityKeyIdentifier",
+	"2.5.29.37": "extKeyUsage",
+}
+
+func certificateReport(cert *x509.Certificate) *CertificateReport {
+	sum := sha256.Sum256(cert.Raw)
+	res := &CertificateReport{
+		Version:            cert.Version,
+		SerialNumber:       cert.SerialNumber.String(),
+		Subject:            cert.Subject.String(),
+		Issuer:             cert.Issuer.String(),
+		NotBefore:          cert.NotBefore,
+		NotAfter:           cert.NotAfter,
+		SignatureAlgorithm: cert.SignatureAlgorithm.String(),
+		PublicKeyAlgorithm: cert.PublicKeyAlgorithm.String(),
+		SPKISHA256:         hex.EncodeToString(spkiSHA256(cert)),
+		SHA256:             hex.EncodeToString(sum[:]),
+		IsCA:               cert.IsCA,
+		KeyUsage:           []string{},
+		ExtKeyUsage:        []string{},
+		Extensions:         []ExtensionReport{},
+	}
+	switch publicKey := cert.PublicKey.(type) {
+	case *rsa.PublicKey:
+		res.PublicKeyBits = publicKey.N.BitLen()
+	case *ecdsa.PublicKey:
+		res.PublicKeyBits = publicKey.Curve.Params().BitSize
+	}
+	for _, u := range keyUsageNames {
+		if cert.KeyUsage&u.usage != 0 {
+			res.KeyUsage = append(res.KeyUsage, u.name)
+		}
+	}
+	for _, u := range cert.ExtKeyUsage {
+		name, ok := extKeyUsageNames[u]
+		if !ok {
+			name = fmt.Sprintf("unknown (%d)", u)
+		}
+		res.ExtKeyUsage = append(res.ExtKeyUsage, name)
+	}
+	for _, e := range cert.Extensions {
+		res.Extensions = append(res.Extensions, ExtensionReport{
+			OID:      e.Id.String(),
+			Name:     extensionNames[e.Id.String()],
+			Critical: e.Critical,
+		})
+	}
+	return res
+}
+
+func (c *CertificateReport) print(indent string) {
+	fmt.Printf("%vCertificate: version %d, serial number %v\n", indent, c.Version, c.SerialNumber)
+	fmt.Printf("%v  Subject: %v\n", indent, c.Subject)
+	fmt.Printf("%v  Issuer: %v\n", indent, c.Issuer)
+	fmt.Printf("%v  Validity: %v to %v\n", indent, c.NotBefore.Format(time.RFC3339), c.NotAfter.Format(time.RFC3339))
+	publicKey := c.PublicKeyAlgorithm
+	if c.PublicKeyBits > 0 {
+		publicKey = fmt.Sprintf("%v %d bits", publicKey, c.PublicKeyBits)
+	}
+	fmt.Printf("%v  Algorithms: %v public key, %v signature\n", indent, publicKey, c.SignatureAlgorithm)
+	fmt.Printf("%v  SPKI SHA-256: %v\n", indent, c.SPKISHA256)
+	fmt.Printf("%v  SHA-256: %v\n", indent, c.SHA256)
+	fmt.Printf("%v  CA: %v\n", indent, c.IsCA)
+	if len(c.KeyUsage) > 0 {
+		fmt.Printf("%v  Key usage: %v\n", indent, strings.Join(c.KeyUsage, ", "))
+	}
+	if len(c.ExtKeyUsage) > 0 {
+		fmt.Printf("%v  Extended key usage: %v\n", indent, strings.Join(c.ExtKeyUsage, ", "))
+	}
+	for _, e := range c.Extensions {
+		name := e.OID
+		if e.Name != "" {
+			name = e.Name + " (" + e.OID + ")"
+		}
+		critical := ""
+		if e.Critical {
+			critical = ", critical"
+		}
+		fmt.Printf("%v  Extension: %v%v\n", indent, name, critical)
+	}
+}
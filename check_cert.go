@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"crypto/x509"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// check-cert [-config FILE] [-disable-checkers LIST] CERT_FILE SERVICE_ACCOUNT
+// Classifies one local certificate, like one pasted into a ticket, and prints all of its signals
+func checkCertCommand(args []string) {
+	fs := flag.NewFlagSet("check-cert", flag.ExitOnError)
+	config := fs.String("config", "", "YAML config file, which can define extra classification rules")
+	disable := fs.String("disable-checkers", "", "Comma separated list of heuristic checkers to disable (names, crypto, validity, extensions, keyid)")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %v check-cert [flags] CERT_FILE SERVICE_ACCOUNT\n", os.Args[0])
+		fmt.Fprintln(fs.Output(), "The certificate can be PEM or DER encoded. The service account is needed for the name checks")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		fs.Usage()
+		os.Exit(EXIT_FATAL)
+	}
+	file, sa := fs.Arg(0), strings.ToLower(strings.TrimSpace(fs.Arg(1)))
+	if !SERVICE_ACCOUNT_EMAIL.MatchString(sa) {
+		fatal(fmt.Sprintf("%q is not a service account email", sa))
+	}
+
+	if err := setupCheckers(*config, *disable); err != nil {
+		fatal(err.Error())
+	}
+
+	cert, err := readCertificateFile(file)
+	if err != nil {
+		fatal(err.Error())
+	}
+
+	key := NewSAKey(sa, "", cert)
+	key.determineKeyKind()
+	key.checkFindings(&KeyPolicy{}, time.Now())
+
+	printServiceAccountHeader(sa, nil)
+	fmt.Printf("  Subject: %v\n", cert.Subject)
+	fmt.Printf("  Issuer: %v\n", cert.Issuer)
+	fmt.Printf("  Valid: %v to %v\n", cert.NotBefore, cert.NotAfter)
+	fmt.Printf("  Algorithms: %v public key, %v signature\n", cert.PublicKeyAlgorithm, cert.SignatureAlgorithm)
+	key.dump("  ", true)
+
+	if key.isBad() {
+		os.Exit(EXIT_FINDINGS)
+	}
+	os.Exit(EXIT_OK)
+}
+
+// A PEM or DER encoded certificate
+func readCertificateFile(file string) (*x509.Certificate, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("error reading %v: %v", file, err)
+	}
+	var cert *x509.Certificate
+	if trimmed := bytes.TrimSpace(data); bytes.HasPrefix(trimmed, []byte("-----BEGIN")) {
+		cert, err = parseCertificatePEM(trimmed)
+	} else {
+		cert, err = x509.ParseCertificate(data)
+		if err != nil {
+			err = fmt.Errorf("error parsing certificate: %v", err)
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", file, err)
+	}
+	return cert, nil
+}
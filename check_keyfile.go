@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+)
+
+// check-keyfile [-config FILE] [-disable-checkers LIST] KEY_FILE
+// Answers whether a service account key JSON file, like one found in a leak, is for a key which still works
+func checkKeyFileCommand(args []string) {
+	fs := flag.NewFlagSet("check-keyfile", flag.ExitOnError)
+	config := fs.String("config", "", "YAML config file, which can define extra classification rules")
+	disable := fs.String("disable-checkers", "", "Comma separated list of heuristic checkers to disable (names, crypto, validity, extensions, keyid)")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %v check-keyfile [flags] KEY_FILE\n", os.Args[0])
+		fmt.Fprintln(fs.Output(), "Exits with 1 if the key is still active")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(EXIT_FATAL)
+	}
+
+	if err := setupCheckers(*config, *disable); err != nil {
+		fatal(err.Error())
+	}
+
+	data, err := os.ReadFile(fs.Arg(0))
+	if err != nil {
+		fatal(fmt.Sprintf("error reading %v: %v", fs.Arg(0), err))
+	}
+	keyFile, err := parseServiceAccountKeyFile(data)
+	if err != nil {
+		fatal(fmt.Sprintf("%v: %v", fs.Arg(0), err))
+	}
+
+	fmt.Printf("Key file: %v\n", fs.Arg(0))
+	printServiceAccountHeader(keyFile.ClientEmail, nil)
+	fmt.Printf("  Key ID: %v\n", keyFile.PrivateKeyID)
+
+	key, err := lookupKey(context.Background(), keyFile.ClientEmail, keyFile.PrivateKeyID, nil)
+	if err != nil {
+		fatal(err.Error())
+	}
+	status := keyStatus(key, time.Now())
+	if status == KEY_STATUS_INACTIVE {
+		fmt.Println("Status: INACTIVE - the key is not published on the x509 endpoint, so it has been deleted or disabled (or the service account has)")
+		os.Exit(EXIT_OK)
+	}
+
+	if matches, err := keyFile.matches(key.cert); err != nil {
+		fmt.Printf("  Warning: unable to check the private key: %v\n", err)
+	} else if !matches {
+		fmt.Println("  Warning: the private key in the file does not match the published certificate, so the file has been tampered with")
+	}
+	key.dump("  ", true)
+
+	if status == KEY_STATUS_EXPIRED {
+		fmt.Printf("Status: EXPIRED - the key is still published, but expired on %v so it can't be used to authenticate\n", key.cert.NotAfter)
+		os.Exit(EXIT_OK)
+	}
+	fmt.Printf("Status: ACTIVE - the key is published and valid until %v, so it can still be used to authenticate\n", key.cert.NotAfter)
+	os.Exit(EXIT_FINDINGS)
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"crypto/x509"
+	"fmt"
+	"slices"
+)
+
+// A Checker is a heuristic which inspects a certificate and emits signals about what kind of key it is
+// New heuristics should implement this and be added with RegisterChecker, determineKeyKind doesn't need to know about them
+type Checker interface {
+	Name() string
+	// keyID is empty if it isn't known, eg. for a single certificate
+	Run(cert *x509.Certificate, serviceAccount string, keyID string) []Signal
+}
+
+// Adapter to allow plain functions to be used as checkers
+type checkerFunc struct {
+	name string
+	run  func(cert *x509.Certificate, serviceAccount string, keyID string) []Signal
+}
+
+func (c checkerFunc) Name() string {
+	return c.name
+}
+
+func (c checkerFunc) Run(cert *x509.Certificate, serviceAccount string, keyID string) []Signal {
+	return c.run(cert, serviceAccount, keyID)
+}
+
+// checkers are run in the order they are registered
+var checkers = []Checker{
+	checkerFunc{"names", checkNames},
+	checkerFunc{"crypto", checkCrypto},
+	checkerFunc{"validity", checkValidityPeriod},
+	checkerFunc{"extensions", checkExtensions},
+	checkerFunc{"keyid", checkKeyID},
+}
+
+var disabledCheckers []string
+
+func RegisterChecker(c Checker) {
+	checkers = append(checkers, c)
+}
+
+func checkerNames() []string {
+	var names []string
+	for _, c := range checkers {
+		names = append(names, c.Name())
+	}
+	return names
+}
+
+func disableCheckers(names []string) error {
+	for _, name := range names {
+		if !slices.Contains(checkerNames(), name) {
+			return fmt.Errorf("unknown checker %v, must be one of %v", name, checkerNames())
+		}
+	}
+	disabledCheckers = names
+	return nil
+}
+
+func enabledCheckers() []Checker {
+	var res []Checker
+	for _, c := range checkers {
+		if !slices.Contains(disabledCheckers, c.Name()) {
+			res = append(res, c)
+		}
+	}
+	return res
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"os"
+)
+
+const (
+	COLOR_RED    = "\033[31m"
+	COLOR_YELLOW = "\033[33m"
+	COLOR_GREEN  = "\033[32m"
+	COLOR_RESET  = "\033[0m"
+)
+
+// Only when printing to a terminal, and neither --no-color nor NO_COLOR (https://no-color.org/) are set
+var useColor = false
+
+// Must be called before os.Stdout is replaced, eg. by --redact
+func setupColor(noColor bool) {
+	if noColor || os.Getenv("NO_COLOR") != "" || os.Getenv("TERM") == "dumb" {
+		return
+	}
+	info, err := os.Stdout.Stat()
+	useColor = err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+func colorize(color string, s string) string {
+	if !useColor || color == "" {
+		return s
+	}
+	return color + s + COLOR_RESET
+}
+
+func keyKindColor(keyKind string) string {
+	switch keyKind {
+	case USER_PROVIDED_USER_MANAGED:
+		return COLOR_RED
+	case GOOGLE_PROVIDED_USER_MANAGED, KEY_KIND_UNKNOWN:
+		return COLOR_YELLOW
+	}
+	return ""
+}
+
+func severityColor(severity string) string {
+	switch severity {
+	case SEVERITY_HIGH:
+		return COLOR_RED
+	case SEVERITY_MEDIUM, SEVERITY_LOW:
+		return COLOR_YELLOW
+	}
+	return ""
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config is loaded from the file given with --config
+type Config struct {
+	// Extra classification rules, see rules.go
+	Rules []RuleConfig `yaml:"rules"`
+}
+
+func loadConfig(path string) (*Config, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading config file %v: %v", path, err)
+	}
+
+	var config Config
+	if err := yaml.Unmarshal(b, &config); err != nil {
+		return nil, fmt.Errorf("error parsing config file %v: %v", path, err)
+	}
+	return &config, nil
+}
@@ -18,6 +18,9 @@ var defaultMaxAfter = time.Date(9999, time.December, 31, 23, 59, 59, 0, time.UTC
 // For old SA Keys, they seem to have have this as a validity period
 var legacyGoogleProvidedUserManagedValidity = 87600 * time.Hour
 
+// Newly created keys can have a NotBefore slightly ahead of our clock, so allow for some skew before calling it the future
+const maxClockSkew = 5 * time.Minute
+
 // available validity periods for GOOGLE_PROVIDED+USER_MANAGED keys
 // https://cloud.google.com/resource-manager/docs/organization-policy/restricting-service-accounts#limit_key_expiry
 var serviceAccountKeyExpiryHours = []time.Duration{
@@ -33,5 +36,56 @@ var serviceAccountKeyExpiryHours = []time.Duration{
 
 var GAIA_ID = regexp.MustCompile("^1[0-9]{20}$")
 
+// Loose syntactic check for a (lowercased) service account email, real ones are stricter but vary by kind
+var SERVICE_ACCOUNT_EMAIL = regexp.MustCompile("^[a-z0-9][a-z0-9._+-]*@[a-z0-9-]+(?:\\.[a-z0-9-]+)+$")
+
+// Enough for every API this uses, for the clients which can't pick their own default scopes
+const CLOUD_PLATFORM_SCOPE = "https://www.googleapis.com/auth/cloud-platform"
+
+// The key IDs GCP generates
+var KEY_ID = regexp.MustCompile("^[0-9a-fA-F]{40}$")
+
+var PROJECT_NUMBER = regexp.MustCompile("^[0-9]+$")
+
+// A service account resource name, optionally as a full resource name like in asset exports
+var SERVICE_ACCOUNT_RESOURCE_NAME = regexp.MustCompile("(?i)^(?://iam.googleapis.com/)?projects/[^/]+/serviceAccounts/([^/]+)$")
+
 var IAMReadRequestsPerMinutePerProjectMax = 5500 // really 6000, but leave some buffer
+const IAMMaxRetries = 5                          // retries for IAM requests which are rate limited or fail transiently
 const MaxInflightX509 = 64                       // max requests to make at once for the x509 certs
+const ParallelMapWorkers = 128                   // max goroutines working through the items of a parllelMap
+
+const MaxKeyFileSize = 64 * 1024  // scan-fs skips files larger than this, key files are a few KB
+const P12_PASSWORD = "notasecret" // the password of every P12 key file generated by GCP
+
+const MinRSAKeyBits = 2048       // RSA keys shorter than this are flagged as weak
+const SmallFactorBound = 1 << 13 // RSA moduli are checked for prime factors below this
+
+const MaxSystemManagedKeys = 3               // GCP keeps 2 or 3 system managed keys for a service account, rotating them
+const MinSystemManagedKeyStagger = time.Hour // and creates them at least this far apart
+
+const X509RequestTimeout = 30 * time.Second  // overall timeout for one request to the x509 endpoint, including the body
+const X509DialTimeout = 10 * time.Second     // timeout for connecting to the x509 endpoint, and for the TLS handshake
+const X509IdleConnTimeout = 90 * time.Second // how long to keep idle connections to the x509 endpoint around
+
+var LoggingReadRequestsPerMinutePerProjectMax = 60 // entries.list is limited to 60 requests per minute
+const AuditLogMaxEntriesPerProject = 100000        // stop reading audit logs for a project after this many entries
+
+const RetryPassDelay = 10 * time.Second       // wait before retrying service accounts which failed with transient errors
+const RetryBaseDelay = 500 * time.Millisecond // first backoff when retrying a request, doubles for each attempt
+const RetryMaxDelay = 30 * time.Second        // but never waits longer than this
+const MaxRetries = 20                         // the most --x509-retries allowed, the later ones all wait RetryMaxDelay
+
+const AdaptiveLimiterMinFraction = 0.01      // never slow down to less than this fraction of the maximum rate
+const AdaptiveLimiterRecoveryFraction = 0.01 // each successful request increases the rate by this fraction of the maximum
+
+// Exit codes, so orchestration can tell a policy failure apart from a broken scan
+// If there are both findings and errors, the findings take precedence
+const (
+	EXIT_OK       = 0
+	EXIT_FINDINGS = 1
+	// also used by the flag package for invalid flags
+	EXIT_FATAL = 2
+	// the scan completed, but some service accounts couldn't be fetched
+	EXIT_SCAN_ERRORS = 3
+)
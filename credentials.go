@@ -0,0 +1,36 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+)
+
+// The kinds of credentials files the Google API clients accept, external_account being a workload identity
+// federation credential configuration (like from gcloud iam workload-identity-pools create-cred-config)
+var credentialsFileTypes = []string{
+	"service_account",
+	"authorized_user",
+	"external_account",
+	"external_account_authorized_user",
+	"impersonated_service_account",
+}
+
+// Checks the --credentials-file up front, as the clients would only fail once the first request is made
+func checkCredentialsFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("unable to read --credentials-file: %v", err)
+	}
+	var creds struct {
+		Type string `json:"type"`
+	}
+	if err := json.Unmarshal(data, &creds); err != nil {
+		return fmt.Errorf("invalid --credentials-file %v: %v", path, err)
+	}
+	if !slices.Contains(credentialsFileTypes, creds.Type) {
+		return fmt.Errorf("invalid --credentials-file %v: unsupported type %q", path, creds.Type)
+	}
+	return nil
+}
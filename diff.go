@@ -0,0 +1,151 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+)
+
+// Every key of the report, by service account and key ID
+func reportKeys(report *Report) map[KeyRef]KeyReport {
+	res := map[KeyRef]KeyReport{}
+	for _, project := range report.Projects {
+		for _, sa := range project.ServiceAccounts {
+			for _, key := range sa.Keys {
+				res[KeyRef{ServiceAccount: key.ServiceAccount, KeyID: key.KeyID}] = key
+			}
+		}
+	}
+	return res
+}
+
+// Sorted by service account, then key ID
+func sortedKeyRefs(keys map[KeyRef]KeyReport) []KeyRef {
+	res := make([]KeyRef, 0, len(keys))
+	for ref := range keys {
+		res = append(res, ref)
+	}
+	slices.SortFunc(res, func(a, b KeyRef) int {
+		if c := strings.Compare(a.ServiceAccount, b.ServiceAccount); c != 0 {
+			return c
+		}
+		return strings.Compare(a.KeyID, b.KeyID)
+	})
+	return res
+}
+
+// The findings of a which aren't in b, by category
+func findingsNotIn(a []FindingReport, b []FindingReport) []FindingReport {
+	var res []FindingReport
+	for _, finding := range a {
+		if !slices.ContainsFunc(b, func(f FindingReport) bool { return f.Category == finding.Category }) {
+			res = append(res, finding)
+		}
+	}
+	return res
+}
+
+func printKeyReport(prefix string, ref KeyRef, key KeyReport) {
+	status := "good"
+	if key.Bad {
+		status = "bad, " + key.Severity
+	}
+	if key.Suppressed != nil {
+		status += ", suppressed"
+	}
+	fmt.Printf("%v %v key %v: %v (%v)\n", prefix, ref.ServiceAccount, ref.KeyID, key.KeyKind, status)
+}
+
+func printFindingReports(prefix string, findings []FindingReport) {
+	for _, finding := range findings {
+		kind := "Finding"
+		if finding.Warning {
+			kind = "Warning"
+		}
+		fmt.Printf("    %v%v %v: %v\n", prefix, kind, finding.Category, finding.Explanation)
+	}
+}
+
+// diff old.json new.json
+// Compares the --report files of two runs, like before and after a change to the infrastructure
+func diffCommand(args []string) {
+	fs := flag.NewFlagSet("diff", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %v diff old.json new.json\n", os.Args[0])
+		fmt.Fprintln(fs.Output(), "Exits with 1 if the new report has bad keys which weren't bad (or were suppressed) in the old one")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		fs.Usage()
+		os.Exit(EXIT_FATAL)
+	}
+
+	var reports [2]*Report
+	for i, file := range fs.Args() {
+		report, err := readReport(file)
+		if err != nil {
+			fatal(err.Error())
+		}
+		reports[i] = report
+	}
+	oldKeys, newKeys := reportKeys(reports[0]), reportKeys(reports[1])
+
+	added, removed, changed := 0, 0, 0
+	newlyBad := false
+	for _, ref := range sortedKeyRefs(newKeys) {
+		key := newKeys[ref]
+		old, ok := oldKeys[ref]
+		if !ok {
+			added++
+			printKeyReport("Added:", ref, key)
+			printFindingReports("", key.Findings)
+			newlyBad = newlyBad || key.Bad && key.Suppressed == nil
+			continue
+		}
+
+		addedFindings := findingsNotIn(key.Findings, old.Findings)
+		removedFindings := findingsNotIn(old.Findings, key.Findings)
+		oldSuppressed, suppressed := old.Suppressed != nil, key.Suppressed != nil
+		if old.KeyKind == key.KeyKind && old.Bad == key.Bad && oldSuppressed == suppressed && len(addedFindings) == 0 && len(removedFindings) == 0 {
+			continue
+		}
+		changed++
+		printKeyReport("Changed:", ref, key)
+		if old.KeyKind != key.KeyKind {
+			fmt.Printf("    was %v\n", old.KeyKind)
+		}
+		if old.Bad != key.Bad {
+			if old.Bad {
+				fmt.Println("    was bad")
+			} else {
+				fmt.Println("    was good")
+			}
+		}
+		if oldSuppressed != suppressed {
+			if oldSuppressed {
+				fmt.Printf("    was suppressed: %v\n", old.Suppressed.Reason)
+			} else {
+				fmt.Printf("    is now suppressed: %v\n", key.Suppressed.Reason)
+			}
+		}
+		printFindingReports("+ ", addedFindings)
+		printFindingReports("- ", removedFindings)
+		// a bad key whose suppression expired or was removed is as new to whoever triages the diff
+		newlyBad = newlyBad || key.Bad && !suppressed && (!old.Bad || oldSuppressed)
+	}
+	for _, ref := range sortedKeyRefs(oldKeys) {
+		if _, ok := newKeys[ref]; !ok {
+			removed++
+			printKeyReport("Removed:", ref, oldKeys[ref])
+		}
+	}
+
+	fmt.Printf("Added keys: %d, Removed keys: %d, Changed keys: %d\n", added, removed, changed)
+	if newlyBad {
+		os.Exit(EXIT_FINDINGS)
+	}
+	os.Exit(EXIT_OK)
+}
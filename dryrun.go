@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+)
+
+// The requests a scan would make, for --dry-run. Retries and pagination aren't counted, so these are lower bounds
+type ScanPlan struct {
+	ServiceAccounts int
+	Projects        int
+	// service accounts whose project can't be told from the email, which the per project lookups skip
+	UnknownProjects int
+	X509Requests    int
+	JWKRequests     int
+	IAMRequests     int
+	// at the IAM read quota, with one quota project, or one per project with --per-project-quota
+	IAMMinutes            float64
+	AssetSearches         int
+	PolicyAnalyzerQueries int
+	AuditLogQueries       int
+	ProjectLookups        int
+}
+
+func planScan(serviceAccountIDs []string) ScanPlan {
+	plan := ScanPlan{ServiceAccounts: len(serviceAccountIDs)}
+	perProject := map[string]int{}
+	for _, sa := range serviceAccountIDs {
+		project := projectFromServiceAccount(sa)
+		if project == "" {
+			plan.UnknownProjects++
+			continue
+		}
+		perProject[project]++
+	}
+	plan.Projects = len(perProject)
+
+	if offlineCerts == nil {
+		plan.X509Requests = len(serviceAccountIDs)
+	}
+	if *crossCheckJWK {
+		plan.JWKRequests = len(serviceAccountIDs)
+	}
+	if *groundTruth && *groundTruthSource == GROUND_TRUTH_ASSET {
+		plan.AssetSearches = len(*scopes)
+		if plan.AssetSearches == 0 {
+			plan.AssetSearches = plan.Projects
+		}
+	} else if *groundTruth {
+		// keys.list and serviceAccounts.get for each service account
+		plan.IAMRequests = 2 * len(serviceAccountIDs)
+		busiest := plan.IAMRequests
+		if *perProjectQuota {
+			busiest = 0
+			for _, n := range perProject {
+				busiest = max(busiest, 2*n)
+			}
+			// the ones without a project are billed to the default quota project
+			busiest = max(busiest, 2*plan.UnknownProjects)
+		}
+		plan.IAMMinutes = float64(busiest) / float64(IAMReadRequestsPerMinutePerProjectMax)
+	}
+	// the IAM policy and workload searches are per scope or project too
+	for _, search := range []bool{*rankByPrivilege, *showWorkloads} {
+		if !search {
+			continue
+		}
+		if len(*scopes) > 0 {
+			plan.AssetSearches += len(*scopes)
+		} else {
+			plan.AssetSearches += plan.Projects
+		}
+	}
+	if *lastAuth {
+		plan.PolicyAnalyzerQueries = plan.Projects
+	}
+	if *auditLogWindow > 0 {
+		plan.AuditLogQueries = plan.Projects
+	}
+	if *projectMetadata {
+		plan.ProjectLookups = plan.Projects
+	}
+	return plan
+}
+
+func (p ScanPlan) print() {
+	fmt.Println("Dry run, no keys were fetched")
+	fmt.Printf("Service accounts: %d, in %d projects\n", p.ServiceAccounts, p.Projects)
+	if p.UnknownProjects > 0 {
+		fmt.Printf("  %d of them have a project which can't be determined from the email, so are left out of the per project lookups\n", p.UnknownProjects)
+	}
+	fmt.Println("Requests, not counting retries and pagination:")
+	fmt.Printf("  x509 endpoint: %d\n", p.X509Requests)
+	if p.JWKRequests > 0 {
+		fmt.Printf("  JWK endpoint: %d\n", p.JWKRequests)
+	}
+	if p.IAMRequests > 0 {
+		fmt.Printf("  IAM API: %d, taking at least %.1f minutes at %d requests per minute per quota project\n", p.IAMRequests, p.IAMMinutes, IAMReadRequestsPerMinutePerProjectMax)
+	}
+	if p.AssetSearches > 0 {
+		fmt.Printf("  Cloud Asset Inventory searches: %d\n", p.AssetSearches)
+	}
+	if p.PolicyAnalyzerQueries > 0 {
+		fmt.Printf("  Policy Analyzer queries: %d\n", p.PolicyAnalyzerQueries)
+	}
+	if p.AuditLogQueries > 0 {
+		fmt.Printf("  Cloud Logging queries: %d\n", p.AuditLogQueries)
+	}
+	if p.ProjectLookups > 0 {
+		fmt.Printf("  Resource Manager: %d, and one per folder above them\n", p.ProjectLookups)
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"time"
+
+	"google.golang.org/api/googleapi"
+)
+
+// An error which is likely to go away if the request is retried, like a timeout or a 5xx response
+type TransientError struct {
+	err error
+}
+
+func (e *TransientError) Error() string {
+	return e.err.Error()
+}
+
+func (e *TransientError) Unwrap() error {
+	return e.err
+}
+
+// The service account wasn't fetched before the run was interrupted, by --timeout or a signal
+var errInterrupted = errors.New("interrupted before it could be fetched")
+
+// the cause of the cancellation of the run once more service accounts than --max-errors failed
+var errTooManyErrors = errors.New("too many service accounts couldn't be fetched")
+
+func transient(err error) error {
+	return &TransientError{err: err}
+}
+
+func isTransientStatus(code int) bool {
+	return code == http.StatusTooManyRequests || code >= 500
+}
+
+// Errors from the Google API clients are checked too, as well as ones marked with transient
+// A cancelled run (by --timeout or a signal) isn't transient, though its errors are timeouts too
+func isTransient(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var t *TransientError
+	if errors.As(err, &t) {
+		return true
+	}
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) {
+		return isTransientStatus(apiErr.Code)
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// Calls f until it succeeds, fails with an error which isn't transient, has been retried retries times, or ctx is done
+// Attempts are spaced out with jittered exponential backoff, so that concurrent requests don't retry in lockstep
+func withRetries[T any](ctx context.Context, retries int, f func() (T, error)) (T, error) {
+	for attempt := 0; ; attempt++ {
+		res, err := f()
+		if err == nil || ctx.Err() != nil || !isTransient(err) || attempt >= retries {
+			return res, err
+		}
+		select {
+		case <-ctx.Done():
+			return res, err
+		case <-time.After(backoff(attempt)):
+		}
+	}
+}
+
+// Between half and all of RetryBaseDelay*2^attempt, capped at RetryMaxDelay
+// The delay stops doubling once it reaches the cap, so that large attempts can't overflow
+func backoff(attempt int) time.Duration {
+	d := RetryBaseDelay
+	for i := 0; i < attempt && d < RetryMaxDelay; i++ {
+		d *= 2
+	}
+	d = min(d, RetryMaxDelay)
+	return d/2 + rand.N(d/2)
+}
@@ -0,0 +1,15 @@
+package main
+
+import "testing"
+
+func TestBackoffLargeAttempts(t *testing.T) {
+	for _, attempt := range []int{0, 1, 6, 35, 40, 64, 100} {
+		d := backoff(attempt)
+		if d <= 0 || d > RetryMaxDelay {
+			t.Errorf("backoff(%d) = %v, expected between 0 and %v", attempt, d, RetryMaxDelay)
+		}
+	}
+	if d := backoff(100); d < RetryMaxDelay/2 {
+		t.Errorf("backoff(100) = %v, expected at least half of %v", d, RetryMaxDelay)
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// A glob pattern like "*-ci@*", or a regular expression if it starts with "re:"
+type Pattern struct {
+	raw string
+	re  *regexp.Regexp
+}
+
+func parsePattern(s string) (Pattern, error) {
+	if expr, found := strings.CutPrefix(s, "re:"); found {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return Pattern{}, fmt.Errorf("invalid regular expression %q: %v", expr, err)
+		}
+		return Pattern{raw: s, re: re}, nil
+	}
+	if _, err := path.Match(s, ""); err != nil {
+		return Pattern{}, fmt.Errorf("invalid pattern %q: %v", s, err)
+	}
+	return Pattern{raw: s}, nil
+}
+
+func parsePatterns(s []string) ([]Pattern, error) {
+	var res []Pattern
+	for _, p := range s {
+		pattern, err := parsePattern(p)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, pattern)
+	}
+	return res, nil
+}
+
+func (p Pattern) match(s string) bool {
+	if p.re != nil {
+		return p.re.MatchString(s)
+	}
+	matched, _ := path.Match(p.raw, s)
+	return matched
+}
+
+func matchAny(patterns []Pattern, s string) bool {
+	for _, p := range patterns {
+		if p.match(s) {
+			return true
+		}
+	}
+	return false
+}
+
+// Keeps service accounts which match any of the includes (or all of them if there are none), and none of the excludes
+func filterServiceAccounts(serviceAccountIDs []string, include []Pattern, exclude []Pattern) []string {
+	var res []string
+	for _, sa := range serviceAccountIDs {
+		if len(include) > 0 && !matchAny(include, sa) {
+			continue
+		}
+		if matchAny(exclude, sa) {
+			continue
+		}
+		res = append(res, sa)
+	}
+
+	if filtered := len(serviceAccountIDs) - len(res); filtered > 0 {
+		slog.Info("Filtered out service accounts with --include/--exclude", "count", filtered)
+	}
+	return res
+}
+
+// Which keys are reported, with --only-kind, --created-after and --created-before. The other keys are still
+// classified, so the findings about the keys of a service account as a set (like rotation) see all of them, and they
+// are still kept in the --state and --history
+type KeyFilter struct {
+	// nil for every kind
+	kinds map[string]bool
+	// the window the NotBefore of the key must be in, zero for no bound
+	createdAfter  time.Time
+	createdBefore time.Time
+}
+
+// A date like 2024-03-01, which is the start of the day in UTC, or a time like 2024-03-01T12:00:00Z
+func parseFilterTime(name string, s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.DateOnly, s); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid %v %v, must be YYYY-MM-DD or an RFC 3339 time", name, s)
+	}
+	return t, nil
+}
+
+func parseKeyFilter(onlyKinds string, createdAfter string, createdBefore string) (KeyFilter, error) {
+	var f KeyFilter
+	var err error
+	if f.createdAfter, err = parseFilterTime("--created-after", createdAfter); err != nil {
+		return f, err
+	}
+	if f.createdBefore, err = parseFilterTime("--created-before", createdBefore); err != nil {
+		return f, err
+	}
+	if !f.createdAfter.IsZero() && !f.createdBefore.IsZero() && !f.createdAfter.Before(f.createdBefore) {
+		return f, fmt.Errorf("--created-after must be before --created-before")
+	}
+	if onlyKinds == "" {
+		return f, nil
+	}
+	known := append(slices.Clone(keyKindPrecedence), KEY_KIND_UNKNOWN)
+	f.kinds = map[string]bool{}
+	for _, kind := range strings.Split(onlyKinds, ",") {
+		kind = strings.ToUpper(strings.TrimSpace(kind))
+		if !slices.Contains(known, kind) {
+			return f, fmt.Errorf("invalid --only-kind %v, must be one of %v", kind, strings.Join(known, ", "))
+		}
+		f.kinds[kind] = true
+	}
+	return f, nil
+}
+
+func (f KeyFilter) matchKind(keyKind string) bool {
+	return f.kinds == nil || f.kinds[keyKind]
+}
+
+func (f KeyFilter) match(key *SAKey) bool {
+	notBefore := key.cert.NotBefore
+	if !f.createdAfter.IsZero() && notBefore.Before(f.createdAfter) {
+		return false
+	}
+	if !f.createdBefore.IsZero() && !notBefore.Before(f.createdBefore) {
+		return false
+	}
+	return f.matchKind(key.keyKind)
+}
+
+// The keys removed since the last --state, which doesn't have when they were created, so they are left out with a
+// creation window
+func (f KeyFilter) matchRemoved(key KeySnapshot) bool {
+	return f.createdAfter.IsZero() && f.createdBefore.IsZero() && f.matchKind(key.KeyKind)
+}
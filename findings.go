@@ -0,0 +1,361 @@
+package main
+
+import (
+	"crypto/rsa"
+	"fmt"
+	"maps"
+	"slices"
+	"time"
+)
+
+// Findings are problems with a key which are independent of which kind it is, like violations of a rotation policy
+// Warnings are reported, but don't make the key bad
+type Finding struct {
+	category    string
+	explanation string
+	warning     bool
+}
+
+const (
+	FINDING_KEY_AGE       = "KEY_AGE"
+	FINDING_EXPIRING_SOON = "EXPIRING_SOON"
+	FINDING_EXPIRED       = "EXPIRED"
+	FINDING_NOT_YET_VALID = "NOT_YET_VALID"
+	FINDING_INVERTED      = "INVERTED_VALIDITY"
+	FINDING_JWK_MISMATCH  = "JWK_MISMATCH"
+	FINDING_ROCA          = "ROCA"
+	FINDING_SHORT_KEY     = "SHORT_KEY"
+	FINDING_WEAK_EXPONENT = "WEAK_EXPONENT"
+	FINDING_SMALL_FACTOR  = "SMALL_FACTOR"
+	FINDING_DEBIAN_WEAK   = "DEBIAN_WEAK_KEY"
+	FINDING_ROTATION_SET  = "ROTATION_SET"
+	FINDING_IAM_VALIDITY  = "IAM_VALIDITY_MISMATCH"
+	FINDING_TERRAFORM     = "NOT_IN_TERRAFORM"
+)
+
+// Thresholds for the findings, a zero value disables the corresponding check
+type KeyPolicy struct {
+	maxKeyAge      time.Duration
+	expiringWithin time.Duration
+}
+
+// Long lived downloadable keys are the main rotation policy violation, so only GOOGLE_PROVIDED/USER_MANAGED keys are checked
+func (k *SAKey) checkKeyAge(policy *KeyPolicy, now time.Time) {
+	if policy.maxKeyAge == 0 || k.keyKind != GOOGLE_PROVIDED_USER_MANAGED {
+		return
+	}
+
+	age := now.Sub(k.cert.NotBefore)
+	if age > policy.maxKeyAge {
+		k.findings = append(k.findings, Finding{
+			category:    FINDING_KEY_AGE,
+			explanation: fmt.Sprintf("Key was created %v ago (%v), which is older than the maximum key age of %v", age.Truncate(time.Hour), k.cert.NotBefore, policy.maxKeyAge),
+		})
+	}
+}
+
+// System managed keys are rotated automatically, so only keys that someone has to rotate by hand are checked
+func (k *SAKey) checkExpiringSoon(policy *KeyPolicy, now time.Time) {
+	if policy.expiringWithin == 0 || k.keyKind == GOOGLE_PROVIDED_SYSTEM_MANAGED {
+		return
+	}
+
+	remaining := k.cert.NotAfter.Sub(now)
+	if remaining > 0 && remaining <= policy.expiringWithin {
+		k.findings = append(k.findings, Finding{
+			category:    FINDING_EXPIRING_SOON,
+			explanation: fmt.Sprintf("Key expires in %v (%v), rotate it before it does", remaining.Truncate(time.Hour), k.cert.NotAfter),
+			warning:     true,
+		})
+	}
+}
+
+// Expired certificates can't be used, but are still published until the key is deleted
+// this usually means an uploaded key was forgotten about, so it should be cleaned up
+func (k *SAKey) checkExpired(now time.Time) {
+	if now.After(k.cert.NotAfter) {
+		k.findings = append(k.findings, Finding{
+			category:    FINDING_EXPIRED,
+			explanation: fmt.Sprintf("Key expired %v ago (%v) but is still published, it should be deleted", now.Sub(k.cert.NotAfter).Truncate(time.Hour), k.cert.NotAfter),
+		})
+	}
+}
+
+// GCP never generates certificates like this, so they are uploaded certificates with bogus parameters
+func (k *SAKey) checkValiditySanity(now time.Time) {
+	if k.cert.NotAfter.Before(k.cert.NotBefore) {
+		k.findings = append(k.findings, Finding{
+			category:    FINDING_INVERTED,
+			explanation: fmt.Sprintf("Certificate NotAfter %v is before NotBefore %v", k.cert.NotAfter, k.cert.NotBefore),
+		})
+	}
+
+	if k.cert.NotBefore.After(now.Add(maxClockSkew)) {
+		k.findings = append(k.findings, Finding{
+			category:    FINDING_NOT_YET_VALID,
+			explanation: fmt.Sprintf("Certificate is not valid until %v, which is in the future", k.cert.NotBefore),
+		})
+	}
+}
+
+// Keys generated on hardware with the Infineon ROCA flaw can be factored from the public key, so anyone can sign
+// as the service account. Only uploaded keys could have been generated like this, but every key is checked
+func (k *SAKey) checkROCA() {
+	if publicKey, ok := k.cert.PublicKey.(*rsa.PublicKey); ok && isROCAVulnerable(publicKey) {
+		k.findings = append(k.findings, Finding{
+			category:    FINDING_ROCA,
+			explanation: "The RSA modulus has the ROCA (CVE-2017-15361) fingerprint, so the private key can be recovered from the public key. Delete the key and generate a new one elsewhere",
+		})
+	}
+}
+
+// Only checked with --weak-keys, as the blocklists aren't bundled
+func (k *SAKey) checkDebianWeakKey() {
+	if weakKeyBlocklist == nil {
+		return
+	}
+	if publicKey, ok := k.cert.PublicKey.(*rsa.PublicKey); ok && isBlocklistedWeakKey(publicKey) {
+		k.findings = append(k.findings, Finding{
+			category:    FINDING_DEBIAN_WEAK,
+			explanation: "The RSA key is in the Debian weak key blocklist (CVE-2008-0166), so the private key is public. Delete the key",
+		})
+	}
+}
+
+// Cryptographic weaknesses of the key itself, whichever kind it is. The key length is also a signal for the kind,
+// but a short key is weak whoever generated it
+func (k *SAKey) checkWeakRSA() {
+	publicKey, ok := k.cert.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return
+	}
+
+	if bits := publicKey.N.BitLen(); bits < MinRSAKeyBits {
+		k.findings = append(k.findings, Finding{
+			category:    FINDING_SHORT_KEY,
+			explanation: fmt.Sprintf("RSA key is %v bits, shorter than the minimum of %v", bits, MinRSAKeyBits),
+		})
+	}
+
+	if publicKey.E < 65537 || publicKey.E%2 == 0 {
+		k.findings = append(k.findings, Finding{
+			category:    FINDING_WEAK_EXPONENT,
+			explanation: fmt.Sprintf("RSA public exponent %v is weak, it should be 65537", publicKey.E),
+		})
+	} else if publicKey.E != 65537 {
+		k.findings = append(k.findings, Finding{
+			category:    FINDING_WEAK_EXPONENT,
+			explanation: fmt.Sprintf("RSA public exponent %v is unusual, almost every key uses 65537", publicKey.E),
+			warning:     true,
+		})
+	}
+
+	if p := smallFactor(publicKey); p != 0 {
+		k.findings = append(k.findings, Finding{
+			category:    FINDING_SMALL_FACTOR,
+			explanation: fmt.Sprintf("RSA modulus is divisible by %v, so it can be factored and the private key recovered", p),
+		})
+	}
+}
+
+// The IAM API has the validity of the key too, which should be the same as the certificate's. A difference means
+// the certificate isn't the one IAM has for the key. Only a warning, as the ground truth is informational
+func (k *SAKey) checkIAMValidity() {
+	if k.iamKey == nil {
+		return
+	}
+	for _, v := range []struct {
+		name     string
+		iamTime  string
+		certTime time.Time
+	}{
+		{"validAfterTime", k.iamKey.ValidAfterTime, k.cert.NotBefore},
+		{"validBeforeTime", k.iamKey.ValidBeforeTime, k.cert.NotAfter},
+	} {
+		t, err := time.Parse(time.RFC3339, v.iamTime)
+		if err != nil {
+			continue
+		}
+		if diff := t.Sub(v.certTime).Abs(); diff > maxClockSkew {
+			k.findings = append(k.findings, Finding{
+				category:    FINDING_IAM_VALIDITY,
+				explanation: fmt.Sprintf("The IAM API %v %v is %v away from the certificate's %v", v.name, v.iamTime, diff, v.certTime),
+				warning:     true,
+			})
+		}
+	}
+}
+
+// GCP rotates the system managed keys of a service account one at a time, so there are only a few of them, created
+// at different times, and each one expires after the ones created before it. More than that, several created at
+// once, or a key whose validity is within the validity of an older one, means some of them only look system managed,
+// eg. uploaded certificates copying the validity period. Only warnings, as the rotation schedule isn't documented
+func checkRotationSet(keys []*SAKey) {
+	var systemManaged []*SAKey
+	for _, k := range keys {
+		if k.keyKind == GOOGLE_PROVIDED_SYSTEM_MANAGED {
+			systemManaged = append(systemManaged, k)
+		}
+	}
+
+	if len(systemManaged) > MaxSystemManagedKeys {
+		for _, k := range systemManaged {
+			k.findings = append(k.findings, Finding{
+				category:    FINDING_ROTATION_SET,
+				explanation: fmt.Sprintf("Service account has %d system managed keys, GCP only keeps up to %d at a time", len(systemManaged), MaxSystemManagedKeys),
+				warning:     true,
+			})
+		}
+	}
+
+	slices.SortFunc(systemManaged, func(a, b *SAKey) int { return a.cert.NotBefore.Compare(b.cert.NotBefore) })
+	for i := 1; i < len(systemManaged); i++ {
+		prev, k := systemManaged[i-1], systemManaged[i]
+		if k.cert.NotBefore.Sub(prev.cert.NotBefore) < MinSystemManagedKeyStagger {
+			k.findings = append(k.findings, Finding{
+				category:    FINDING_ROTATION_SET,
+				explanation: fmt.Sprintf("Key was created within %v of system managed key %v (%v), GCP rotates them one at a time", MinSystemManagedKeyStagger, prev.keyID, prev.cert.NotBefore),
+				warning:     true,
+			})
+		}
+	}
+	// compared with every older key, not just the previous one, as a nested key can be followed by a normal one
+	for i, k := range systemManaged {
+		for _, older := range systemManaged[:i] {
+			if k.cert.NotAfter.Before(older.cert.NotAfter) {
+				k.findings = append(k.findings, Finding{
+					category:    FINDING_ROTATION_SET,
+					explanation: fmt.Sprintf("Key is valid from %v until %v, within the validity of the older system managed key %v (%v until %v), GCP rotated keys expire in the order they were created", k.cert.NotBefore, k.cert.NotAfter, older.keyID, older.cert.NotBefore, older.cert.NotAfter),
+					warning:     true,
+				})
+				break
+			}
+		}
+	}
+}
+
+// Both endpoints should publish the same keys, so a difference means one of them changed behavior, or is serving
+// stale keys. Only a warning, as it says more about the endpoints than the key
+// With --terraform-state, user managed keys which aren't in any of the states were created out of band, like with
+// gcloud or the console. System managed keys are created by Google, so they never are
+func (k *SAKey) checkTerraform(state *TerraformState) {
+	if !isUserManaged(k.keyKind) || !state.covers(k.serviceAccount) {
+		return
+	}
+	if _, ok := state.keys[k.serviceAccount][k.keyID]; !ok {
+		k.findings = append(k.findings, Finding{
+			category:    FINDING_TERRAFORM,
+			explanation: fmt.Sprintf("User managed key isn't in the Terraform state of project %v, so it was created outside of Terraform", projectFromServiceAccount(k.serviceAccount)),
+			warning:     true,
+		})
+	}
+}
+
+func (k *SAKey) checkJWK(jwks ServiceAccountJWKs) {
+	jwk := jwks[k.keyID]
+	if jwk == nil {
+		k.findings = append(k.findings, Finding{
+			category:    FINDING_JWK_MISMATCH,
+			explanation: "Key is published on the x509 endpoint, but not on the JWK endpoint",
+			warning:     true,
+		})
+		return
+	}
+	if publicKey, ok := k.cert.PublicKey.(*rsa.PublicKey); !ok || !publicKey.Equal(jwk) {
+		k.findings = append(k.findings, Finding{
+			category:    FINDING_JWK_MISMATCH,
+			explanation: "The public key on the JWK endpoint doesn't match the certificate on the x509 endpoint",
+			warning:     true,
+		})
+	}
+}
+
+// Problems with the service account as a whole rather than any one key, jwks is nil unless --cross-check-jwk
+// GCP always publishes the system managed keys, so a service account without any is in a strange state
+func serviceAccountWarnings(keys []*SAKey, jwks ServiceAccountJWKs) []string {
+	var res []string
+	if len(keys) == 0 {
+		res = append(res, "No keys are published for the service account, not even system managed ones")
+	} else if !slices.ContainsFunc(keys, func(k *SAKey) bool { return k.keyKind == GOOGLE_PROVIDED_SYSTEM_MANAGED }) {
+		res = append(res, "The service account has user managed keys, but no system managed keys")
+	}
+
+	// published on the JWK endpoint, but not the x509 endpoint, so they can't be classified
+	var jwkOnly []string
+	for keyID := range jwks {
+		if !slices.ContainsFunc(keys, func(k *SAKey) bool { return k.keyID == keyID }) {
+			jwkOnly = append(jwkOnly, keyID)
+		}
+	}
+	slices.Sort(jwkOnly)
+	for _, keyID := range jwkOnly {
+		res = append(res, fmt.Sprintf("Key %v is published on the JWK endpoint, but not on the x509 endpoint", keyID))
+	}
+	return res
+}
+
+// Must be called after determineKeyKind, as some findings only apply to certain key kinds
+func (k *SAKey) checkFindings(policy *KeyPolicy, now time.Time) {
+	k.checkedAt = now
+	k.checkKeyAge(policy, now)
+	k.checkExpiringSoon(policy, now)
+	k.checkExpired(now)
+	k.checkValiditySanity(now)
+	k.checkROCA()
+	k.checkWeakRSA()
+	k.checkDebianWeakKey()
+}
+
+// A key is bad if it is not a system managed key, or if there are any findings for it that are not warnings
+// unless a policy has decided otherwise
+func (k *SAKey) isBad() bool {
+	if k.policyDecision != nil && k.policyDecision.decided {
+		return k.policyDecision.fail
+	}
+	if k.keyKind != GOOGLE_PROVIDED_SYSTEM_MANAGED {
+		return true
+	}
+	for _, finding := range k.findings {
+		if !finding.warning {
+			return true
+		}
+	}
+	return false
+}
+
+// A key is failing if it is bad and hasn't been suppressed, only failing keys count towards bad SAs
+func (k *SAKey) isFailing() bool {
+	return k.isBad() && k.suppression == nil
+}
+
+func (k *SAKey) hasWarnings() bool {
+	for _, finding := range k.findings {
+		if finding.warning {
+			return true
+		}
+	}
+	return false
+}
+
+// In ground truth mode, the keys which are only in the IAM API or only on the x509 endpoint. Disabled keys aren't
+// published, and deleted keys can still be published for a while, so these are the blind spots of the observed keys
+func groundTruthWarnings(keys []*SAKey, realKeys ServiceAccountKeys) []string {
+	var res []string
+	for _, keyID := range slices.Sorted(maps.Keys(realKeys)) {
+		if slices.ContainsFunc(keys, func(k *SAKey) bool { return k.keyID == keyID }) {
+			continue
+		}
+		realKey := realKeys[keyID]
+		warning := fmt.Sprintf("Key %v (%v/%v, valid from %v) is in the IAM API, but not published on the x509 endpoint", keyID, realKey.KeyOrigin, realKey.KeyType, realKey.ValidAfterTime)
+		if realKey.Disabled {
+			warning += ", as it is disabled"
+		}
+		res = append(res, warning)
+	}
+	for _, k := range keys {
+		if realKeys[k.keyID] == nil {
+			res = append(res, fmt.Sprintf("Key %v is published on the x509 endpoint, but not in the IAM API, it may have just been deleted", k.keyID))
+		}
+	}
+	return res
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+// The SHA-256 of the DER encoded SubjectPublicKeyInfo, which identifies the public key wherever it shows up, eg. in a
+// TLS handshake, independently of the certificate it was published in
+func spkiSHA256(cert *x509.Certificate) []byte {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return sum[:]
+}
+
+// Writes the fingerprint of every key in the report as a CSV, with the hex form for SIEMs and the base64 form used
+// by pinning and TLS interception tools
+func writeFingerprints(path string, report *Report) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("unable to write fingerprints: %v", err)
+	}
+	w := csv.NewWriter(f)
+	w.Write([]string{"service_account", "key_id", "key_kind", "bad", "not_before", "not_after", "spki_sha256", "spki_sha256_base64"})
+	for _, project := range report.Projects {
+		for _, sa := range project.ServiceAccounts {
+			for _, key := range sa.Keys {
+				sum, err := hex.DecodeString(key.SPKISHA256)
+				if err != nil {
+					f.Close()
+					return fmt.Errorf("invalid fingerprint of key %v of %v: %v", key.KeyID, key.ServiceAccount, err)
+				}
+				w.Write([]string{
+					redactor.redact(key.ServiceAccount),
+					key.KeyID,
+					key.KeyKind,
+					strconv.FormatBool(key.Bad),
+					key.NotBefore.Format(time.RFC3339),
+					key.NotAfter.Format(time.RFC3339),
+					key.SPKISHA256,
+					base64.StdEncoding.EncodeToString(sum),
+				})
+			}
+		}
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		f.Close()
+		return fmt.Errorf("unable to write fingerprints: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("unable to write fingerprints: %v", err)
+	}
+	return nil
+}
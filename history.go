@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+type HistoryKey struct {
+	KeyID string `json:"keyId"`
+	KeySnapshot
+	NotBefore time.Time `json:"notBefore"`
+}
+
+// One line of a --history file, the keys of a service account as of one run
+type HistoryRecord struct {
+	ScannedAt      time.Time    `json:"scannedAt"`
+	ServiceAccount string       `json:"serviceAccount"`
+	Keys           []HistoryKey `json:"keys"`
+}
+
+func historyRecord(serviceAccount string, keys []*SAKey, now time.Time) HistoryRecord {
+	res := HistoryRecord{
+		ScannedAt:      now,
+		ServiceAccount: serviceAccount,
+		Keys:           []HistoryKey{},
+	}
+	for _, key := range keys {
+		res.Keys = append(res.Keys, HistoryKey{
+			KeyID:       key.keyID,
+			KeySnapshot: keySnapshot(key),
+			NotBefore:   key.cert.NotBefore,
+		})
+	}
+	return res
+}
+
+// The file is only ever appended to, so the history of every run is kept
+// The records are written with a single write to a file opened with O_APPEND, so the lines of runs writing to the
+// same file at once aren't interleaved
+func appendHistory(path string, records []HistoryRecord) error {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return fmt.Errorf("error opening history %v: %v", path, err)
+	}
+	defer f.Close()
+
+	var buf bytes.Buffer
+	// a run which was killed while writing leaves a truncated line, which the first record mustn't be appended to
+	if info, err := f.Stat(); err == nil && info.Size() > 0 {
+		last := make([]byte, 1)
+		if _, err := f.ReadAt(last, info.Size()-1); err == nil && last[0] != '\n' {
+			buf.WriteByte('\n')
+		}
+	}
+	enc := json.NewEncoder(&buf)
+	for _, record := range records {
+		if err := enc.Encode(record); err != nil {
+			return fmt.Errorf("error encoding history record: %v", err)
+		}
+	}
+	if _, err := f.Write(buf.Bytes()); err != nil {
+		return fmt.Errorf("error writing history %v: %v", path, err)
+	}
+	return f.Close()
+}
+
+// Reads the records for which keep returns true, in the order they were written
+func readHistory(path string, keep func(*HistoryRecord) bool) ([]HistoryRecord, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading history %v: %v", path, err)
+	}
+	defer f.Close()
+
+	var res []HistoryRecord
+	scanner := bufio.NewScanner(f)
+	// a service account can have up to 10 user managed keys, and a handful of system managed ones
+	scanner.Buffer(nil, 1<<20)
+	for line := 1; scanner.Scan(); line++ {
+		var record HistoryRecord
+		// truncated by a run which was killed while writing, the rest of the history is still usable
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			slog.Warn("Skipping unparseable history line", "path", path, "line", line, "error", err)
+			continue
+		}
+		if keep(&record) {
+			res = append(res, record)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading history %v: %v", path, err)
+	}
+	return res, nil
+}
+
+// The key as of a run in which it changed, nil if it was removed
+type KeyEvent struct {
+	at  time.Time
+	key *HistoryKey
+}
+
+// What the history says about one key of a service account
+type KeyHistory struct {
+	keyID     string
+	firstSeen time.Time
+	lastSeen  time.Time
+	// starting with when it was first seen, a key can come back after being removed if it was disabled
+	events []KeyEvent
+}
+
+// Whether the key was in the last run of the service account
+func (h *KeyHistory) present() bool {
+	return h.events[len(h.events)-1].key != nil
+}
+
+// records must all be for the same service account, in order
+func keyHistories(records []HistoryRecord) []*KeyHistory {
+	var res []*KeyHistory
+	byID := map[string]*KeyHistory{}
+	for _, record := range records {
+		for _, key := range record.Keys {
+			h := byID[key.KeyID]
+			if h == nil {
+				h = &KeyHistory{keyID: key.KeyID, firstSeen: record.ScannedAt}
+				byID[key.KeyID] = h
+				res = append(res, h)
+			}
+			if len(h.events) == 0 || !h.present() || !keySnapshotEqual(h.events[len(h.events)-1].key.KeySnapshot, key.KeySnapshot) {
+				h.events = append(h.events, KeyEvent{at: record.ScannedAt, key: &key})
+			}
+			h.lastSeen = record.ScannedAt
+		}
+		for _, h := range res {
+			if h.lastSeen.Before(record.ScannedAt) && h.present() {
+				h.events = append(h.events, KeyEvent{at: record.ScannedAt})
+			}
+		}
+	}
+	return res
+}
+
+func keySnapshotEqual(a KeySnapshot, b KeySnapshot) bool {
+	return a.KeyKind == b.KeyKind && a.Bad == b.Bad && slices.Equal(a.Findings, b.Findings)
+}
+
+func describeKeySnapshot(k KeySnapshot) string {
+	status := "good"
+	if k.Bad {
+		status = "bad"
+	}
+	if len(k.Findings) > 0 {
+		status += ", findings " + strings.Join(k.Findings, ", ")
+	}
+	return fmt.Sprintf("%v (%v)", k.KeyKind, status)
+}
+
+// history -history FILE EMAIL
+// Answers questions like when a key first appeared, from the --history of earlier runs
+func historyCommand(args []string) {
+	fs := flag.NewFlagSet("history", flag.ExitOnError)
+	path := fs.String("history", "", "The --history file of earlier runs")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %v history -history FILE EMAIL\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	serviceAccount := strings.ToLower(strings.TrimSpace(fs.Arg(0)))
+	if *path == "" || fs.NArg() != 1 || !SERVICE_ACCOUNT_EMAIL.MatchString(serviceAccount) {
+		fs.Usage()
+		os.Exit(EXIT_FATAL)
+	}
+
+	records, err := readHistory(*path, func(r *HistoryRecord) bool { return r.ServiceAccount == serviceAccount })
+	if err != nil {
+		fatal(err.Error())
+	}
+	printServiceAccountHeader(serviceAccount, nil)
+	if len(records) == 0 {
+		fmt.Println("  Not in the history")
+		os.Exit(EXIT_OK)
+	}
+	fmt.Printf("  Scanned %d times, from %v to %v\n", len(records), records[0].ScannedAt.Format(time.RFC3339), records[len(records)-1].ScannedAt.Format(time.RFC3339))
+
+	for _, h := range keyHistories(records) {
+		fmt.Printf("  Key ID: %v - first seen %v, last seen %v\n", h.keyID, h.firstSeen.Format(time.RFC3339), h.lastSeen.Format(time.RFC3339))
+		for _, event := range h.events {
+			if event.key == nil {
+				fmt.Printf("    %v: removed\n", event.at.Format(time.RFC3339))
+			} else {
+				fmt.Printf("    %v: %v\n", event.at.Format(time.RFC3339), describeKeySnapshot(event.key.KeySnapshot))
+			}
+		}
+	}
+	os.Exit(EXIT_OK)
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+
+	"google.golang.org/api/iam/v1"
+)
+
+// Strips resource names like projects/{p}/serviceAccounts/{email|id} down to the email or unique ID
+func normalizeServiceAccountID(s string) string {
+	if m := SERVICE_ACCOUNT_RESOURCE_NAME.FindStringSubmatch(s); m != nil {
+		return m[1]
+	}
+	return s
+}
+
+// Trims, normalizes and lowercases a service account identifier given as input, and checks it is
+// either an email or a unique ID
+func parseServiceAccountID(s string) (string, error) {
+	s = strings.ToLower(normalizeServiceAccountID(strings.TrimSpace(s)))
+	if !SERVICE_ACCOUNT_EMAIL.MatchString(s) && !isUniqueID(s) {
+		return "", fmt.Errorf("%q is not a service account email, resource name or unique ID", s)
+	}
+	return s, nil
+}
+
+// Parses all the input identifiers, skipping blank ones. Every invalid one is reported, using
+// location to describe where the i-th input came from, before failing
+func parseServiceAccountIDs(inputs []string, location func(i int) string) ([]string, error) {
+	var res []string
+	invalid := 0
+	for i, input := range inputs {
+		if strings.TrimSpace(input) == "" {
+			continue
+		}
+		sa, err := parseServiceAccountID(input)
+		if err != nil {
+			slog.Error("Invalid service account", "location", location(i), "error", err)
+			invalid++
+			continue
+		}
+		res = append(res, sa)
+	}
+	if invalid > 0 {
+		return nil, fmt.Errorf("found %d invalid service account identifiers in the input", invalid)
+	}
+	return res, nil
+}
+
+// Extra columns from a CSV input (like team or owner) for each service account, which are carried
+// through to the report
+var serviceAccountAttributes = map[string]map[string]string{}
+
+// Reads a CSV file with a header row, where emailColumn holds the service account and all the other
+// columns are kept as its attributes
+func getServiceAccountsFromCSV(path string, emailColumn string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	header, err := r.Read()
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the header of %v: %v", path, err)
+	}
+	column := slices.Index(header, emailColumn)
+	if column < 0 {
+		return nil, fmt.Errorf("%v has no %q column", path, emailColumn)
+	}
+
+	var inputs []string
+	var lines []int
+	var rows [][]string
+	for {
+		row, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		line, _ := r.FieldPos(column)
+		inputs = append(inputs, row[column])
+		lines = append(lines, line)
+		rows = append(rows, row)
+	}
+
+	serviceAccountIDs, err := parseServiceAccountIDs(inputs, func(i int) string { return fmt.Sprintf("%v:%d", path, lines[i]) })
+	if err != nil {
+		return nil, err
+	}
+
+	for i, row := range rows {
+		sa, err := parseServiceAccountID(inputs[i])
+		if err != nil || serviceAccountAttributes[sa] != nil {
+			continue
+		}
+		attributes := map[string]string{}
+		for j, value := range row {
+			if j != column {
+				attributes[header[j]] = value
+			}
+		}
+		serviceAccountAttributes[sa] = attributes
+	}
+
+	return serviceAccountIDs, nil
+}
+
+// Service account unique IDs are the same shape as GAIA IDs
+func isUniqueID(s string) bool {
+	return GAIA_ID.MatchString(s)
+}
+
+// Replaces any unique IDs with the email of the service account, using the IAM API
+// The heuristics need the email, so unique IDs which can't be resolved are dropped with a warning
+func resolveUniqueIDs(ctx context.Context, serviceAccountIDs []string) ([]string, error) {
+	if !slices.ContainsFunc(serviceAccountIDs, isUniqueID) {
+		return serviceAccountIDs, nil
+	}
+
+	limiter := NewAdaptiveLimiter(IAMReadRequestsPerMinutePerProjectMax)
+	iamClient := iamService()
+
+	resolved, err := parllelMap(ctx, serviceAccountIDs, func(ctx context.Context, sa string) (string, error) {
+		if !isUniqueID(sa) {
+			return sa, nil
+		}
+		res, err := limitedCall(ctx, limiter, IAMMaxRetries, func() (*iam.ServiceAccount, error) {
+			return getServiceAccount(ctx, iamClient, sa, "")
+		})
+		if err != nil {
+			slog.Warn("Unable to resolve unique ID to a service account email, skipping it", "uniqueId", sa, "error", err)
+			return "", nil
+		}
+		return res.Email, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var res []string
+	for i, sa := range resolved {
+		if sa == "" {
+			continue
+		}
+		if attributes, ok := serviceAccountAttributes[serviceAccountIDs[i]]; ok && serviceAccountAttributes[sa] == nil {
+			serviceAccountAttributes[sa] = attributes
+		}
+		res = append(res, sa)
+	}
+	return res, nil
+}
+
+// Removes duplicates, keeping the first occurrence, and returns how many were removed
+func dedupeServiceAccounts(serviceAccountIDs []string) ([]string, int) {
+	seen := map[string]bool{}
+	var res []string
+	for _, sa := range serviceAccountIDs {
+		if seen[sa] {
+			continue
+		}
+		seen[sa] = true
+		res = append(res, sa)
+	}
+	return res, len(serviceAccountIDs) - len(res)
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+type jwtClaims struct {
+	Iss string `json:"iss"`
+	Sub string `json:"sub"`
+	Aud any    `json:"aud"`
+	Iat int64  `json:"iat"`
+	Exp int64  `json:"exp"`
+}
+
+// Splits a JWT, returning the signed part ("header.payload") and the decoded signature
+func parseJWT(token string) (jwtHeader, jwtClaims, string, []byte, error) {
+	var header jwtHeader
+	var claims jwtClaims
+	parts := strings.Split(strings.TrimSpace(token), ".")
+	if len(parts) != 3 {
+		return header, claims, "", nil, fmt.Errorf("not a JWT, it must have 3 parts separated by dots")
+	}
+	for i, v := range []any{&header, &claims} {
+		b, err := base64.RawURLEncoding.DecodeString(parts[i])
+		if err != nil {
+			return header, claims, "", nil, fmt.Errorf("error decoding JWT: %v", err)
+		}
+		if err := json.Unmarshal(b, v); err != nil {
+			return header, claims, "", nil, fmt.Errorf("error parsing JWT: %v", err)
+		}
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return header, claims, "", nil, fmt.Errorf("error decoding JWT signature: %v", err)
+	}
+	return header, claims, parts[0] + "." + parts[1], signature, nil
+}
+
+// inspect-jwt [-sa EMAIL] TOKEN|FILE|-
+// Works out which key of a service account signed a JWT, to tell a token signed with an exported key apart from one
+// signed by Google, eg. with the signJwt API
+func inspectJWTCommand(args []string) {
+	fs := flag.NewFlagSet("inspect-jwt", flag.ExitOnError)
+	sa := fs.String("sa", "", "The service account which signed the token, by default the iss claim")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %v inspect-jwt [-sa EMAIL] TOKEN|FILE|-\n", os.Args[0])
+		fmt.Fprintln(fs.Output(), "Exits with 1 if the signature is invalid, or the token was signed with a user managed key")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(EXIT_FATAL)
+	}
+
+	token := fs.Arg(0)
+	if token == "-" {
+		b, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			fatal(fmt.Sprintf("error reading the token: %v", err))
+		}
+		token = string(b)
+	} else if b, err := os.ReadFile(token); err == nil {
+		token = string(b)
+	}
+
+	header, claims, signed, signature, err := parseJWT(token)
+	if err != nil {
+		fatal(err.Error())
+	}
+	serviceAccount := strings.ToLower(*sa)
+	if serviceAccount == "" {
+		serviceAccount = strings.ToLower(claims.Iss)
+	}
+	if !SERVICE_ACCOUNT_EMAIL.MatchString(serviceAccount) {
+		fatal(fmt.Sprintf("the issuer %q is not a service account, so the token wasn't signed by a service account key. Use -sa if it was", claims.Iss))
+	}
+	if header.Alg != "RS256" {
+		fatal(fmt.Sprintf("unsupported JWT algorithm %v, service account keys sign with RS256", header.Alg))
+	}
+	if header.Kid == "" {
+		fatal("the JWT header has no kid, so the key which signed it can't be looked up")
+	}
+
+	fmt.Printf("Token: issued by %v for %v, audience %v\n", claims.Iss, claims.Sub, claims.Aud)
+	if claims.Iat != 0 && claims.Exp != 0 {
+		fmt.Printf("  Issued at %v, expires %v\n", time.Unix(claims.Iat, 0).UTC(), time.Unix(claims.Exp, 0).UTC())
+	}
+	printServiceAccountHeader(serviceAccount, nil)
+
+	key, err := lookupKey(context.Background(), serviceAccount, header.Kid, nil)
+	if err != nil {
+		fatal(err.Error())
+	}
+	if key == nil {
+		fmt.Printf("  Key ID: %v - not published on the x509 endpoint, so the signature can't be verified. The key may have been deleted or disabled since\n", header.Kid)
+		os.Exit(EXIT_SCAN_ERRORS)
+	}
+	key.dump("  ", true)
+
+	publicKey, ok := key.cert.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		fmt.Printf("Signature: INVALID - the key is not an RSA key\n")
+		os.Exit(EXIT_FINDINGS)
+	}
+	digest := sha256.Sum256([]byte(signed))
+	if err := rsa.VerifyPKCS1v15(publicKey, crypto.SHA256, digest[:], signature); err != nil {
+		fmt.Printf("Signature: INVALID - the token was not signed by key %v\n", header.Kid)
+		os.Exit(EXIT_FINDINGS)
+	}
+
+	if key.keyKind == GOOGLE_PROVIDED_SYSTEM_MANAGED {
+		fmt.Println("Signature: valid, with a system managed key, so the token was minted by Google (eg. with the signJwt API or an attached service account)")
+		os.Exit(EXIT_OK)
+	}
+	fmt.Printf("Signature: valid, with a %v key, so the token was minted by whoever holds the private key\n", key.keyKind)
+	os.Exit(EXIT_FINDINGS)
+}
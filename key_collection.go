@@ -2,60 +2,206 @@ package main
 
 import (
 	"context"
-	"encoding/pem"
+	"errors"
 	"fmt"
-	"os"
-	"slices"
+	"log/slog"
+	"maps"
 	"sync"
+	"time"
 
+	asset "cloud.google.com/go/asset/apiv1"
 	"golang.org/x/sync/semaphore"
 	"golang.org/x/time/rate"
+	"google.golang.org/api/iam/v1"
 )
 
 type KeyCollection struct {
 	serviceAccountIDs []string
 	observedKeys      []ServiceAccountCerts
 	groundTruthKeys   []ServiceAccountKeys
-	badSAsLock        sync.Mutex
-	badSAs            []string
+	// nil unless FetchServiceAccountMetadata was called, entries are nil if the lookup failed
+	serviceAccounts []*iam.ServiceAccount
+	// nil unless FetchJWKs was called, entries are nil if the fetch failed
+	jwks []ServiceAccountJWKs
+	// key ID -> last authentication time, nil unless FetchLastAuthentications was called
+	lastAuthentications map[string]time.Time
+	// key ID -> usage from the audit logs, nil unless FetchKeyUsage was called
+	keyUsage map[string]*KeyUsage
+	// project -> metadata, nil unless FetchProjectMetadata was called
+	projectMetadata map[string]*ProjectReport
+	badSAsLock      sync.Mutex
+	// service account -> the error fetching it, these are skipped for the rest of the run
+	badSAs map[string]error
+	// with --max-errors, called as soon as more service accounts than errorBudget failed, nil otherwise
+	errorBudget   int
+	tooManyErrors func()
+	// the bad service accounts which count towards the budget, transient errors only count once they were retried
+	countedErrors map[string]bool
+	retrying      bool
+
+	// the rest is shared with the later batches of the run, see nextBatch
+	limiters *ProjectLimiters
+	// purpose/project (or scope) -> whether it has already been looked up, so each project is only looked up once
+	lookedUp map[string]bool
+	// purpose/scope (or project) -> whether the search or lookup of it failed, so what it would have found is unknown
+	// rather than none
+	failedScopes map[string]bool
+	// service account -> its keys and itself, from the asset inventory searches so far
+	assetKeys            map[string]ServiceAccountKeys
+	assetServiceAccounts map[string]*iam.ServiceAccount
+	// service account -> its roles, nil unless FetchRoles was called
+	roles map[string][]RoleBinding
+	// service account -> the workloads running as it, nil unless FetchWorkloads was called
+	workloads map[string][]WorkloadReport
 }
 
 func NewKeyCollection(serviceAccountIDs []string) *KeyCollection {
 	return &KeyCollection{
-		serviceAccountIDs: serviceAccountIDs,
+		serviceAccountIDs:    serviceAccountIDs,
+		badSAs:               map[string]error{},
+		countedErrors:        map[string]bool{},
+		limiters:             NewProjectLimiters(IAMReadRequestsPerMinutePerProjectMax),
+		lookedUp:             map[string]bool{},
+		failedScopes:         map[string]bool{},
+		assetKeys:            map[string]ServiceAccountKeys{},
+		assetServiceAccounts: map[string]*iam.ServiceAccount{},
+	}
+}
+
+// Returns a collection for the next batch of service accounts, which keeps the per project lookups and rate limits
+// of this one, but not its certificates. Returns a new collection if k is nil
+func (k *KeyCollection) nextBatch(serviceAccountIDs []string) *KeyCollection {
+	next := NewKeyCollection(serviceAccountIDs)
+	if k == nil {
+		return next
 	}
+	next.lastAuthentications = k.lastAuthentications
+	next.keyUsage = k.keyUsage
+	next.projectMetadata = k.projectMetadata
+	next.limiters = k.limiters
+	next.lookedUp = k.lookedUp
+	next.failedScopes = k.failedScopes
+	next.assetKeys = k.assetKeys
+	next.assetServiceAccounts = k.assetServiceAccounts
+	next.roles = k.roles
+	next.workloads = k.workloads
+	return next
 }
 
-func (k *KeyCollection) FetchKeys(groundTruth bool, quotaProject string) error {
-	err := k.FetchObservedKeys()
+func (k *KeyCollection) FetchKeys(ctx context.Context, groundTruth bool, groundTruthSource string, quotaProject string) error {
+	err := k.FetchObservedKeys(ctx)
+	if ctx.Err() != nil && groundTruth {
+		// without the ground truth, there is nothing to compare the keys with
+		k.skipUnfetched(ctx, func(int) bool { return false })
+	}
 	if err != nil {
 		return err
 	}
+	if groundTruth && groundTruthSource == GROUND_TRUTH_ASSET {
+		err := k.FetchGroundTruthViaAssetInventory(ctx)
+		if err != nil {
+			return err
+		}
+		// the retries only need the observed keys, as the asset search covered every service account
+		return k.RetryTransientFailures(ctx, false)
+	}
 	if groundTruth {
-		err := k.FetchGroundTruthKeys()
+		err := k.FetchGroundTruthKeys(ctx, k.limiters)
+		if err != nil {
+			return err
+		}
+		err = k.FetchServiceAccountMetadata(ctx, k.limiters)
 		if err != nil {
 			return err
 		}
 	}
+	return k.RetryTransientFailures(ctx, groundTruth)
+}
+
+// Retries the service accounts which failed with transient errors (like timeouts or 5xx responses) once after a
+// delay, so a single flaky request doesn't drop a service account from the whole report
+func (k *KeyCollection) RetryTransientFailures(ctx context.Context, groundTruth bool) error {
+	var retry []int
+	k.badSAsLock.Lock()
+	k.retrying = true
+	for i, sa := range k.serviceAccountIDs {
+		if err, ok := k.badSAs[sa]; ok && isTransient(err) {
+			retry = append(retry, i)
+		}
+	}
+	k.badSAsLock.Unlock()
+	if len(retry) == 0 {
+		return nil
+	}
+
+	slog.Info("Retrying service accounts which failed with transient errors", "count", len(retry), "delay", RetryPassDelay)
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(RetryPassDelay):
+	}
+
+	inflight := semaphore.NewWeighted(MaxInflightX509)
+	limiters := k.limiters
+
+	_, err := parllelMap(ctx, retry, func(ctx context.Context, i int) (any, error) {
+		sa := k.serviceAccountIDs[i]
+
+		if err := inflight.Acquire(ctx, 1); err != nil {
+			return nil, err
+		}
+		certs, err := getServiceAccountKeyCerts(ctx, sa, *x509Retries)
+		inflight.Release(1)
+		if err != nil {
+			k.addBadSA(sa, err)
+			return nil, nil
+		}
+
+		if groundTruth {
+			keys, err := getGroundTruthKeys(ctx, limiters, sa)
+			if err != nil {
+				k.addBadSA(sa, fmt.Errorf("error getting ground truth keys: %w", err))
+				return nil, nil
+			}
+			k.groundTruthKeys[i] = keys
+
+			metadata, err := getServiceAccountMetadata(ctx, limiters, sa)
+			if err != nil {
+				slog.Warn("Error getting metadata for service account", "serviceAccount", sa, "error", err)
+			}
+			k.serviceAccounts[i] = metadata
+		}
+
+		k.observedKeys[i] = certs
+		k.removeBadSA(sa)
+		return nil, nil
+	})
+	if err != nil {
+		return fmt.Errorf("error retrying service accounts: %v", err)
+	}
 	return nil
 }
 
-func (k *KeyCollection) FetchGroundTruthKeys() error {
-	limiter := rate.NewLimiter(rate.Limit(IAMReadRequestsPerMinutePerProjectMax/60.0), 1)
+func (k *KeyCollection) FetchGroundTruthKeys(ctx context.Context, limiters *ProjectLimiters) error {
 
 	k.groundTruthKeys = make([]ServiceAccountKeys, len(k.serviceAccountIDs))
 
-	iam := iamService()
-
-	res, err := parllelMap(k.serviceAccountIDs, func(sa string) (ServiceAccountKeys, error) {
+	res, err := parllelMap(ctx, k.serviceAccountIDs, func(ctx context.Context, sa string) (ServiceAccountKeys, error) {
 		if k.isBadSA(sa) {
 			return nil, nil
 		}
-		if err := limiter.Wait(context.Background()); err != nil {
-			return nil, err
+		res, err := getGroundTruthKeys(ctx, limiters, sa)
+		if err != nil {
+			k.addBadSA(sa, fmt.Errorf("error getting ground truth keys: %w", err))
+			return nil, nil
 		}
-		return getServiceAccountKeys(context.Background(), iam, sa)
+		return res, nil
 	})
+	if ctx.Err() != nil {
+		k.groundTruthKeys = res
+		k.skipUnfetched(ctx, func(i int) bool { return res[i] != nil })
+		return ctx.Err()
+	}
 	if err != nil {
 		return fmt.Errorf("error getting keys from GCP API: %v", err)
 	}
@@ -63,24 +209,133 @@ func (k *KeyCollection) FetchGroundTruthKeys() error {
 	return nil
 }
 
-func (k *KeyCollection) FetchObservedKeys() error {
+// Fetches the keys and the service accounts from the asset inventory, with one search per --scope, or per
+// project of the service accounts if there are no scopes
+func (k *KeyCollection) FetchGroundTruthViaAssetInventory(ctx context.Context) error {
+	c, err := asset.NewClient(ctx, grpcClientOptions()...)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	keys := k.assetKeys
+	serviceAccounts := k.assetServiceAccounts
+	for _, scope := range k.assetScopes("asset inventory ground truth lookup") {
+		scopeKeys, scopeServiceAccounts, err := getGroundTruthViaAssetInventory(ctx, c, scope)
+		if ctx.Err() != nil {
+			k.skipUnfetched(ctx, func(int) bool { return false })
+			return ctx.Err()
+		}
+		if err != nil {
+			return fmt.Errorf("error searching the asset inventory in %v: %v", scope, err)
+		}
+		maps.Copy(keys, scopeKeys)
+		maps.Copy(serviceAccounts, scopeServiceAccounts)
+	}
+
+	// set for the bad service accounts too, in case they are retried
+	k.groundTruthKeys = make([]ServiceAccountKeys, len(k.serviceAccountIDs))
+	k.serviceAccounts = make([]*iam.ServiceAccount, len(k.serviceAccountIDs))
+	for i, sa := range k.serviceAccountIDs {
+		if _, ok := serviceAccounts[sa]; !ok && !k.isBadSA(sa) {
+			slog.Warn("Service account not found in the asset inventory", "serviceAccount", sa)
+		}
+		k.groundTruthKeys[i] = keys[sa]
+		k.serviceAccounts[i] = serviceAccounts[sa]
+	}
+	return nil
+}
+
+// Fetches the service accounts themselves, for their description, display name etc.
+// Failures are only warnings because the metadata is informational
+func (k *KeyCollection) FetchServiceAccountMetadata(ctx context.Context, limiters *ProjectLimiters) error {
+	res, err := parllelMap(ctx, k.serviceAccountIDs, func(ctx context.Context, sa string) (*iam.ServiceAccount, error) {
+		if k.isBadSA(sa) {
+			return nil, nil
+		}
+		res, err := getServiceAccountMetadata(ctx, limiters, sa)
+		if err != nil {
+			slog.Warn("Error getting metadata for service account", "serviceAccount", sa, "error", err)
+			return nil, nil
+		}
+		return res, nil
+	})
+	// the metadata is informational, so the service accounts are reported without it
+	if ctx.Err() != nil {
+		k.serviceAccounts = res
+		return ctx.Err()
+	}
+	if err != nil {
+		return fmt.Errorf("error getting service accounts from GCP API: %v", err)
+	}
+	k.serviceAccounts = res
+	return nil
+}
+
+// keys.list for the service account, unless it was cached in the --cache-dir recently
+func getGroundTruthKeys(ctx context.Context, limiters *ProjectLimiters, sa string) (ServiceAccountKeys, error) {
+	var keys ServiceAccountKeys
+	if groundTruthCache.get("keys", sa, &keys) {
+		return keys, nil
+	}
+	consumer := iamConsumerProject(sa)
+	keys, err := limitedCall(ctx, limiters.get(consumer), IAMMaxRetries, func() (ServiceAccountKeys, error) {
+		return getServiceAccountKeys(ctx, iamService(), sa, consumer)
+	})
+	if err != nil {
+		return nil, err
+	}
+	groundTruthCache.put("keys", sa, keys)
+	return keys, nil
+}
+
+// serviceAccounts.get for the service account, unless it was cached in the --cache-dir recently
+func getServiceAccountMetadata(ctx context.Context, limiters *ProjectLimiters, sa string) (*iam.ServiceAccount, error) {
+	var metadata *iam.ServiceAccount
+	if groundTruthCache.get("serviceaccounts", sa, &metadata) && metadata != nil {
+		return metadata, nil
+	}
+	consumer := iamConsumerProject(sa)
+	metadata, err := limitedCall(ctx, limiters.get(consumer), IAMMaxRetries, func() (*iam.ServiceAccount, error) {
+		return getServiceAccount(ctx, iamService(), sa, consumer)
+	})
+	if err != nil {
+		return nil, err
+	}
+	groundTruthCache.put("serviceaccounts", sa, metadata)
+	return metadata, nil
+}
+
+func (k *KeyCollection) FetchObservedKeys(ctx context.Context) error {
+	if offlineCerts != nil {
+		k.observedKeys = make([]ServiceAccountCerts, len(k.serviceAccountIDs))
+		for i, sa := range k.serviceAccountIDs {
+			k.observedKeys[i] = offlineCerts[sa]
+		}
+		return nil
+	}
+
 	inflight := semaphore.NewWeighted(MaxInflightX509)
 
 	k.observedKeys = make([]ServiceAccountCerts, len(k.serviceAccountIDs))
 
-	observedKeys, err := parllelMap(k.serviceAccountIDs, func(sa string) (ServiceAccountCerts, error) {
-		if err := inflight.Acquire(context.Background(), 1); err != nil {
+	observedKeys, err := parllelMap(ctx, k.serviceAccountIDs, func(ctx context.Context, sa string) (ServiceAccountCerts, error) {
+		if err := inflight.Acquire(ctx, 1); err != nil {
 			return nil, err
 		}
 		defer inflight.Release(1)
-		res, err := getServiceAccountKeyCerts(sa)
+		res, err := getServiceAccountKeyCerts(ctx, sa, *x509Retries)
 		if err != nil {
-			fmt.Printf("Warning: error getting keys for service account %v: %v\n", sa, err)
-			k.addBadSA(sa)
+			k.addBadSA(sa, err)
 			return nil, nil
 		}
 		return res, nil
 	})
+	if ctx.Err() != nil {
+		k.observedKeys = observedKeys
+		k.skipUnfetched(ctx, func(i int) bool { return observedKeys[i] != nil })
+		return ctx.Err()
+	}
 	if err != nil {
 		return fmt.Errorf("error getting keys from GCP API: %v", err)
 	}
@@ -88,44 +343,254 @@ func (k *KeyCollection) FetchObservedKeys() error {
 	return nil
 }
 
+// Fetches the keys from the JWK endpoint too, to cross-check them with the x509 endpoint
+// Failures are only warnings, as the x509 endpoint is the one the keys are classified from
+func (k *KeyCollection) FetchJWKs(ctx context.Context) error {
+	inflight := semaphore.NewWeighted(MaxInflightX509)
+
+	res, err := parllelMap(ctx, k.serviceAccountIDs, func(ctx context.Context, sa string) (ServiceAccountJWKs, error) {
+		if k.isBadSA(sa) {
+			return nil, nil
+		}
+		if err := inflight.Acquire(ctx, 1); err != nil {
+			return nil, err
+		}
+		defer inflight.Release(1)
+		res, err := getServiceAccountJWKs(ctx, sa, *x509Retries)
+		if err != nil {
+			slog.Warn("Error getting the JWKs of service account, not cross-checking it", "serviceAccount", sa, "error", err)
+			return nil, nil
+		}
+		return res, nil
+	})
+	if err != nil {
+		return fmt.Errorf("error getting JWKs: %v", err)
+	}
+	k.jwks = res
+	return nil
+}
+
+// Returns the unique projects that the service accounts belong to, except those already looked up for this purpose
+// by an earlier batch
+func (k *KeyCollection) projects(purpose string) []string {
+	var projects []string
+	for _, sa := range k.serviceAccountIDs {
+		if k.isBadSA(sa) {
+			continue
+		}
+		project := projectFromServiceAccount(sa)
+		if project == "" {
+			slog.Warn("Unable to determine project", "serviceAccount", sa, "skipping", purpose)
+			continue
+		}
+		if k.lookedUp[purpose+"/"+project] {
+			continue
+		}
+		k.lookedUp[purpose+"/"+project] = true
+		projects = append(projects, project)
+	}
+	return projects
+}
+
+// The scopes to search for the purpose which haven't been searched yet: the --scope ones, or the projects of the
+// service accounts if there are none. The scopes cover every batch, so they are only searched by the first one
+func (k *KeyCollection) assetScopes(purpose string) []string {
+	var res []string
+	for _, scope := range *scopes {
+		if !k.lookedUp[purpose+"/"+scope] {
+			k.lookedUp[purpose+"/"+scope] = true
+			res = append(res, scope)
+		}
+	}
+	if len(*scopes) == 0 {
+		for _, project := range k.projects(purpose) {
+			res = append(res, "projects/"+project)
+		}
+	}
+	return res
+}
+
+// Whether an asset search for the purpose which could have covered the service account failed: any of the --scope
+// ones, as which service accounts each of them covers isn't known, or the one of its project
+func (k *KeyCollection) assetSearchFailed(purpose string, sa string) bool {
+	for _, scope := range *scopes {
+		if k.failedScopes[purpose+"/"+scope] {
+			return true
+		}
+	}
+	return k.failedScopes[purpose+"/projects/"+projectFromServiceAccount(sa)]
+}
+
+// Whether the per project lookup for the purpose failed for the project of the service account
+func (k *KeyCollection) projectLookupFailed(purpose string, sa string) bool {
+	return k.failedScopes[purpose+"/"+projectFromServiceAccount(sa)]
+}
+
+// Queries the activity analyzer once for each project that the service accounts belong to
+// The keys of the projects which failed have an unknown last authentication, rather than never
+func (k *KeyCollection) FetchLastAuthentications(ctx context.Context) error {
+	projects := k.projects("last authentication lookup")
+	policyAnalyzer := policyAnalyzerService()
+
+	res, err := parllelMap(ctx, projects, func(ctx context.Context, project string) (map[string]time.Time, error) {
+		res, err := getKeyLastAuthentications(ctx, policyAnalyzer, project)
+		if err != nil {
+			slog.Warn("Error getting key last authentication activity", "project", project, "error", err)
+			return nil, nil
+		}
+		return res, nil
+	})
+	if err != nil {
+		return fmt.Errorf("error getting key activity from GCP API: %v", err)
+	}
+
+	if k.lastAuthentications == nil {
+		k.lastAuthentications = map[string]time.Time{}
+	}
+	for i, r := range res {
+		// the results are only nil for the projects which failed, or weren't queried because the run was stopped
+		if r == nil {
+			k.failedScopes["last authentication lookup/"+projects[i]] = true
+		}
+		for keyID, t := range r {
+			k.lastAuthentications[keyID] = t
+		}
+	}
+	return nil
+}
+
+// Reads the data access audit logs of each project that the service accounts belong to
+// looking for requests authenticated with a service account key within the window before now
+// The keys of the projects which failed have an unknown usage, rather than none
+func (k *KeyCollection) FetchKeyUsage(ctx context.Context, window time.Duration, now time.Time) error {
+	projects := k.projects("audit log lookup")
+	logging := loggingService()
+	limiter := rate.NewLimiter(rate.Limit(LoggingReadRequestsPerMinutePerProjectMax/60.0), 1)
+	since := now.Add(-window)
+
+	res, err := parllelMap(ctx, projects, func(ctx context.Context, project string) (map[string]*KeyUsage, error) {
+		res, err := getKeyUsageFromAuditLogs(ctx, logging, limiter, project, since)
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if err != nil {
+			slog.Warn("Error reading audit logs", "project", project, "error", err)
+			return nil, nil
+		}
+		return res, nil
+	})
+	if err != nil {
+		return fmt.Errorf("error reading audit logs from GCP API: %v", err)
+	}
+
+	if k.keyUsage == nil {
+		k.keyUsage = map[string]*KeyUsage{}
+	}
+	for i, r := range res {
+		// the results are only nil for the projects which failed, or weren't read because the run was stopped
+		if r == nil {
+			k.failedScopes["audit log lookup/"+projects[i]] = true
+		}
+		for keyID, usage := range r {
+			k.keyUsage[keyID] = usage
+		}
+	}
+	return nil
+}
+
+// Looks up each project that the service accounts belong to with the Resource Manager API
+// Failures are only warnings because the metadata is informational
+func (k *KeyCollection) FetchProjectMetadata(ctx context.Context) error {
+	projects := k.projects("project metadata lookup")
+
+	res, err := parllelMap(ctx, projects, func(ctx context.Context, project string) (*ProjectReport, error) {
+		res, err := getProjectReport(ctx, project)
+		if err != nil {
+			slog.Warn("Error getting metadata for project", "project", project, "error", err)
+			return nil, nil
+		}
+		return res, nil
+	})
+	if err != nil {
+		return fmt.Errorf("error getting projects from GCP API: %v", err)
+	}
+
+	if k.projectMetadata == nil {
+		k.projectMetadata = map[string]*ProjectReport{}
+	}
+	for i, project := range projects {
+		if res[i] != nil {
+			k.projectMetadata[project] = res[i]
+		}
+	}
+	return nil
+}
+
 func (k *KeyCollection) isBadSA(sa string) bool {
 	k.badSAsLock.Lock()
 	defer k.badSAsLock.Unlock()
-	return slices.Contains(k.badSAs, sa)
+	_, ok := k.badSAs[sa]
+	return ok
 }
 
-func (k *KeyCollection) addBadSA(sa string) {
+// The errors of requests cut off by an interruption don't count towards --max-errors
+func (k *KeyCollection) addBadSA(sa string, err error) {
 	k.badSAsLock.Lock()
 	defer k.badSAsLock.Unlock()
-	k.badSAs = append(k.badSAs, sa)
+	k.badSAs[sa] = err
+	if k.tooManyErrors == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) || !k.retrying && isTransient(err) {
+		return
+	}
+	k.countedErrors[sa] = true
+	if len(k.countedErrors) > k.errorBudget {
+		k.tooManyErrors()
+	}
 }
 
-func (k *KeyCollection) WritePublicKeysToDir(s string) error {
-	err := os.MkdirAll(s, 0755)
-	if err != nil {
-		return fmt.Errorf("error creating directory %v: %v", s, err)
-	}
+func (k *KeyCollection) removeBadSA(sa string) {
+	k.badSAsLock.Lock()
+	defer k.badSAsLock.Unlock()
+	delete(k.badSAs, sa)
+	delete(k.countedErrors, sa)
+}
 
+// After the run was interrupted, the service accounts which weren't fetched (or were cut off by the interruption)
+// are left out, so the ones which were fetched can still be reported
+func (k *KeyCollection) skipUnfetched(ctx context.Context, fetched func(i int) bool) {
+	k.badSAsLock.Lock()
+	defer k.badSAsLock.Unlock()
 	for i, sa := range k.serviceAccountIDs {
-		if k.isBadSA(sa) {
+		if err, bad := k.badSAs[sa]; fetched(i) || bad && !errors.Is(err, ctx.Err()) {
 			continue
 		}
-		for keyID, cert := range k.observedKeys[i] {
-			fname := fmt.Sprintf("%v/%v_%v.pem", s, sa, keyID)
-			f, err := os.Create(fname)
-			if err != nil {
-				return fmt.Errorf("error creating file %v: %v", fname, err)
-			}
-			err = pem.Encode(f, &pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
-			if err != nil {
-				return fmt.Errorf("error encoding PEM block: %v", err)
-			}
-			err = f.Close()
-			if err != nil {
-				return fmt.Errorf("error closing file %v: %v", fname, err)
-			}
+		k.badSAs[sa] = errInterrupted
+	}
+}
+
+// The service accounts left out because the run was interrupted
+func (k *KeyCollection) interruptedSAs() int {
+	k.badSAsLock.Lock()
+	defer k.badSAsLock.Unlock()
+	n := 0
+	for _, err := range k.badSAs {
+		if err == errInterrupted {
+			n++
 		}
 	}
+	return n
+}
 
-	return nil
+// The skipped service accounts and their errors, in the order of the input. The ones left out because the run was
+// interrupted aren't skipped, they are still to be scanned
+func (k *KeyCollection) skippedSAs() []SkippedReport {
+	k.badSAsLock.Lock()
+	defer k.badSAsLock.Unlock()
+
+	res := []SkippedReport{}
+	for _, sa := range k.serviceAccountIDs {
+		if err, ok := k.badSAs[sa]; ok && err != errInterrupted {
+			res = append(res, SkippedReport{ServiceAccount: sa, Error: err.Error()})
+		}
+	}
+	return res
 }
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// The fields of a downloaded service account key JSON file which identify the key
+type ServiceAccountKeyFile struct {
+	Type         string `json:"type"`
+	ProjectID    string `json:"project_id"`
+	PrivateKeyID string `json:"private_key_id"`
+	PrivateKey   string `json:"private_key"`
+	ClientEmail  string `json:"client_email"`
+}
+
+func parseServiceAccountKeyFile(data []byte) (*ServiceAccountKeyFile, error) {
+	var f ServiceAccountKeyFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("error parsing key file: %v", err)
+	}
+	if f.Type != "service_account" || f.ClientEmail == "" || f.PrivateKeyID == "" {
+		return nil, fmt.Errorf("not a service account key file, it must have a type of service_account, a client_email and a private_key_id")
+	}
+	return &f, nil
+}
+
+// Whether the private key in the file is the one for the certificate, so the file isn't just made up
+func (f *ServiceAccountKeyFile) matches(cert *x509.Certificate) (bool, error) {
+	publicKey, err := f.publicKey()
+	if err != nil {
+		return false, err
+	}
+	return samePublicKey(publicKey, cert.PublicKey), nil
+}
+
+func (f *ServiceAccountKeyFile) publicKey() (crypto.PublicKey, error) {
+	block, _ := pem.Decode([]byte(f.PrivateKey))
+	if block == nil {
+		return nil, fmt.Errorf("error decoding the private key PEM block")
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing the private key: %v", err)
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported private key type %T", key)
+	}
+	return signer.Public(), nil
+}
+
+// All the public key types in the standard library have an Equal method
+func samePublicKey(a crypto.PublicKey, b crypto.PublicKey) bool {
+	public, ok := a.(interface{ Equal(crypto.PublicKey) bool })
+	return ok && public.Equal(b)
+}
+
+// Whether a key found in a file can still be used
+const (
+	KEY_STATUS_ACTIVE = "ACTIVE"
+	// still published, but past its NotAfter
+	KEY_STATUS_EXPIRED = "EXPIRED"
+	// not published, so the key or its service account has been deleted or disabled
+	KEY_STATUS_INACTIVE = "INACTIVE"
+	// the service account couldn't be determined, so the key couldn't be looked up
+	KEY_STATUS_UNKNOWN = "UNKNOWN"
+)
+
+// key is nil if the key isn't published
+func keyStatus(key *SAKey, now time.Time) string {
+	if key == nil {
+		return KEY_STATUS_INACTIVE
+	}
+	if now.After(key.cert.NotAfter) {
+		return KEY_STATUS_EXPIRED
+	}
+	return KEY_STATUS_ACTIVE
+}
+
+// Looks up a key on the x509 endpoint by its ID, or if there is no ID (like for P12 files) by its public key, and
+// classifies it if it's there
+// Returns nil if the key (or its service account) isn't published, so it can't be used to authenticate
+func lookupKey(ctx context.Context, sa string, keyID string, publicKey crypto.PublicKey) (*SAKey, error) {
+	certs, err := getServiceAccountKeyCerts(ctx, sa, *x509Retries)
+	if errors.Is(err, errServiceAccountNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for id, cert := range certs {
+		if keyID != "" && id != keyID || keyID == "" && !samePublicKey(cert.PublicKey, publicKey) {
+			continue
+		}
+		key := NewSAKey(sa, id, cert)
+		key.determineKeyKind()
+		key.checkFindings(&KeyPolicy{}, time.Now())
+		return key, nil
+	}
+	return nil, nil
+}
@@ -1,6 +1,10 @@
 package main
 
-import "slices"
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
 
 // These are "muxed key kinds", which are combinations of key origin and key type
 // The google API separates these, but for the purposes of this program it's easier to combine them
@@ -9,6 +13,8 @@ const (
 	GOOGLE_PROVIDED_SYSTEM_MANAGED = "GOOGLE_PROVIDED/SYSTEM_MANAGED"
 	GOOGLE_PROVIDED_USER_MANAGED   = "GOOGLE_PROVIDED/USER_MANAGED"
 	USER_PROVIDED_USER_MANAGED     = "USER_PROVIDED/USER_MANAGED"
+	// Used when the kind can't be determined, for example if Google adds a new key origin or type
+	KEY_KIND_UNKNOWN = "UNKNOWN"
 )
 
 // precendence order for key types based on the signals we see
@@ -19,10 +25,21 @@ var keyKindPrecedence = []string{
 	GOOGLE_PROVIDED_SYSTEM_MANAGED,
 }
 
+// Returns KEY_KIND_UNKNOWN for combinations we don't know about
 func keyTypeAndOriginToMuxedKeyKind(keyType string, keyOrigin string) string {
 	res := keyOrigin + "/" + keyType
 	if slices.Index(keyKindPrecedence, res) == -1 {
-		panic("Invalid key type and origin combination: " + res)
+		return KEY_KIND_UNKNOWN
 	}
 	return res
 }
+
+// Parses a key kind as written by a user, either like GOOGLE_PROVIDED/USER_MANAGED or GOOGLE_PROVIDED_USER_MANAGED
+func parseKeyKind(s string) (string, error) {
+	for _, kind := range keyKindPrecedence {
+		if s == kind || s == strings.Replace(kind, "/", "_", 1) {
+			return kind, nil
+		}
+	}
+	return "", fmt.Errorf("unknown key kind %q, must be one of %v", s, keyKindPrecedence)
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+const (
+	LOG_FORMAT_TEXT = "text"
+	LOG_FORMAT_JSON = "json"
+)
+
+// With --redact, the diagnostics are redacted like the results. A handler writes each record with one Write
+type redactingWriter struct {
+	w io.Writer
+}
+
+func (w redactingWriter) Write(p []byte) (int, error) {
+	if _, err := w.w.Write(redactor.redactBytes(p)); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// The diagnostics (progress, warnings and errors) go to stderr, so that stdout only has the results. level defaults to
+// info, or warn with --quiet
+func setupLogging(level string, format string, quiet bool) error {
+	if level == "" {
+		level = "info"
+		if quiet {
+			level = "warn"
+		}
+	}
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(strings.ToUpper(level))); err != nil {
+		return fmt.Errorf("invalid --log-level %v, must be debug, info, warn or error", level)
+	}
+
+	var w io.Writer = os.Stderr
+	if redactor != nil {
+		w = redactingWriter{w: os.Stderr}
+	}
+	options := &slog.HandlerOptions{Level: l}
+	switch format {
+	case LOG_FORMAT_TEXT:
+		slog.SetDefault(slog.New(slog.NewTextHandler(w, options)))
+	case LOG_FORMAT_JSON:
+		slog.SetDefault(slog.New(slog.NewJSONHandler(w, options)))
+	default:
+		return fmt.Errorf("invalid --log-format %v, must be %v or %v", format, LOG_FORMAT_TEXT, LOG_FORMAT_JSON)
+	}
+	return nil
+}
+
+// Logs the error, and exits once the output has been written out
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	exit(EXIT_FATAL)
+}
@@ -2,32 +2,130 @@ package main
 
 import (
 	"bufio"
+	"bytes"
 	"context"
+	"errors"
 	"flag"
 	"fmt"
+	"io/fs"
+	"log/slog"
+	"net/http"
 	"os"
+	"os/signal"
+	"slices"
+	"strings"
 	"sync"
+	"syscall"
+	"time"
 
 	asset "cloud.google.com/go/asset/apiv1"
+	"google.golang.org/api/bigquery/v2"
+	"google.golang.org/api/cloudresourcemanager/v3"
 	"google.golang.org/api/iam/v1"
+	"google.golang.org/api/logging/v2"
 	"google.golang.org/api/option"
+	"google.golang.org/api/policyanalyzer/v1"
+	"google.golang.org/api/storage/v1"
+	htransport "google.golang.org/api/transport/http"
 )
 
 var groundTruth = flag.Bool("ground-truth", false, "If specified, will check against the GCP API for the ground truth")
+var groundTruthSource = flag.String("ground-truth-source", GROUND_TRUTH_IAM, "Where to get the ground truth from, either iam (a keys.list call per service account) or asset (one Cloud Asset Inventory search per --scope, or per project)")
 var verbose = flag.Bool("verbose", false, "If specified, will print verbose output")
+var logLevel = flag.String("log-level", "", "The level of the diagnostics printed to stderr: debug, info, warn or error. Defaults to info, or warn with --quiet")
+var logFormat = flag.String("log-format", LOG_FORMAT_TEXT, "The format of the diagnostics printed to stderr: text or json")
+var versionFlag = flag.Bool("version", false, "Print the version and exit")
+var noColor = flag.Bool("no-color", false, "Don't color the output, which is only colored when printing to a terminal anyway")
+var tui = flag.Bool("tui", false, "Once the scan completes, browse the results interactively, from the projects to the signals of each key")
+var quiet = flag.Bool("quiet", false, "If specified, will only print the number of failing keys, for the exit code to be acted on")
+var summaryOnly = flag.Bool("summary-only", false, "If specified, will only print the counts per project, without the details of each key")
 
-var project = flag.String("project", "", "The project to use for the GCP API, to list all service accounts (useful with -ground-truth)")
-var scope = flag.String("scope", "", "Use the cloud asset API to get SAs. Can be any cloud asset supported scope like organizations/{ORGANIZATION_NUMBER} or folders/{FOLDER_NUMBER} (useful with -ground-truth)")
-var inFile = flag.String("in", "", "Input file to read service accounts from, one per line")
+var projects = stringListFlag("project", "The project to use for the GCP API, to list all service accounts (useful with -ground-truth). Can be repeated or a comma separated list")
+var scopes = stringListFlag("scope", "Use the cloud asset API to get SAs. Can be any cloud asset supported scope like organizations/{ORGANIZATION_NUMBER} or folders/{FOLDER_NUMBER} (useful with -ground-truth). Can be repeated or a comma separated list")
+var inFile = flag.String("in", "", "Input file to read service accounts from, one per line, or a CSV file with a header row if it ends in .csv")
+var folders = stringListFlag("folder", "List all service accounts in the projects under this folder (like 123 or folders/123) and its subfolders, using the Resource Manager and IAM APIs instead of the cloud asset API. Can be repeated or a comma separated list")
+var organizations = stringListFlag("organization", "List all service accounts in the projects under this organization (like 123 or organizations/123), using the Resource Manager and IAM APIs instead of the cloud asset API. Can be repeated or a comma separated list")
+var useGcloudProject = flag.Bool("use-gcloud-project", false, "If no service accounts or other flags to find them are given, scan the project from GOOGLE_CLOUD_PROJECT or the gcloud configuration")
+var assetExport = flag.String("asset-export", "", "Cloud Asset Inventory export to read service accounts from, either a newline-delimited JSON file (local, or gs://BUCKET/OBJECT, with a trailing * for all objects with that prefix) or a BigQuery table (bq://PROJECT.DATASET.TABLE)")
+var fromDir = flag.String("from-dir", "", "Classify the certificates in this directory, as written by --out-dir (named <sa>/<keyid>.pem or <sa>_<keyid>.pem), instead of fetching them from the x509 endpoint")
+var inColumn = flag.String("in-column", "email", "With a CSV --in file, the column holding the service account. The other columns are included as attributes in the --report")
+var includeSAs = stringSliceFlag("include", "Only scan service accounts whose email matches this glob pattern (or regular expression if prefixed with re:), can be repeated")
+var excludeSAs = stringSliceFlag("exclude", "Don't scan service accounts whose email matches this glob pattern (or regular expression if prefixed with re:), can be repeated")
+var skipServiceAgents = flag.Bool("skip-service-agents", false, "If specified, will not scan Google managed service agents (like service-123@gcp-sa-example.iam.gserviceaccount.com)")
+var skipDefaultSAs = flag.Bool("skip-default-sas", false, "If specified, will not scan the default Compute Engine and App Engine service accounts")
+var excludeProjectPatterns = stringSliceFlag("exclude-project", "Don't scan service accounts in projects whose ID or number matches this glob pattern (or regular expression if prefixed with re:), can be repeated")
+var shardFlag = flag.String("shard", "", "Only scan one of N slices of the service accounts, given as K/N with K from 0 to N-1, so N jobs can each scan a slice. Combine their reports with the merge subcommand")
+var excludeProjectLabels = stringSliceFlag("exclude-project-label", "With --project, --scope, --folder, --organization or --asset-export, exclude service accounts whose project has a matching label (key=value, key!=value, key or !key), can be repeated")
 
+var lastAuth = flag.Bool("last-auth", false, "If specified, will look up when each key last authenticated using the Policy Intelligence activity analyzer")
+var auditLogWindow = durationFlag("audit-log-window", 0, "If specified, will search the data access audit logs of each service account's project over this window (like 7d or 12h) for authentications using the flagged keys")
+
+var crossCheckJWK = flag.Bool("cross-check-jwk", false, "If specified, will also fetch the keys from the JWK endpoint, and warn about keys which are missing from either endpoint or whose public keys don't match")
+var projectMetadata = flag.Bool("project-metadata", false, "If specified, will look up the project of each service account (ID, number, display name, labels and folder path) for the --report and policy input")
+var maxKeyAge = durationFlag("max-key-age", 0, "If specified, will flag GOOGLE_PROVIDED/USER_MANAGED keys created longer ago than this (like 90d)")
+var expiringWithin = durationFlag("expiring-within", 0, "If specified, will warn about user managed keys that expire within this long (like 30d)")
+
+var failOn = flag.String("fail-on", SEVERITY_ANY, "Only exit with an error if there are bad keys of at least this severity (high, medium, low or any)")
+var policyFile = flag.String("policy", "", "Rego policy file which decides whether each key passes or fails, and its severity")
+var baselineFile = flag.String("baseline", "", "YAML file listing accepted service account keys, which are reported as suppressed instead of bad")
+var ignoreSAs = stringSliceFlag("ignore-sa", "Glob pattern (or regular expression if prefixed with re:) of service accounts whose keys are reported as suppressed instead of bad, can be repeated")
+var ignoreKeyIDs = stringSliceFlag("ignore-key-id", "Glob pattern (or regular expression if prefixed with re:) of key IDs which are reported as suppressed instead of bad, can be repeated")
+var rankByPrivilege = flag.Bool("rank-by-privilege", false, "If specified, will look up the roles of each service account with an IAM policy search in the asset inventory, and list the bad keys with the most privileged service accounts first")
+var showWorkloads = flag.Bool("workloads", false, "If specified, will look up the running instances, Cloud Run services and GKE node pools which run as each service account in the asset inventory")
+var terraformStates = stringSliceFlag("terraform-state", "A Terraform state file (- for stdin, like from terraform state pull), to warn about the user managed keys in its projects which aren't in it. Can be repeated")
+var terraformUnmanagedScript = flag.String("terraform-unmanaged-script", "", "With --terraform-state, write a shell script with the gcloud commands to disable (and, commented out, delete) the user managed keys which aren't in the states to this file")
+var weakKeyFiles = stringSliceFlag("weak-keys", "Blocklist of Debian weak keys, in the format of the openssl-blacklist package (like blacklist.RSA-2048), to flag keys which are in it. Can be repeated")
+var configFile = flag.String("config", "", "YAML config file, which can define extra classification rules")
+var disableCheckersFlag = flag.String("disable-checkers", "", "Comma separated list of heuristic checkers to disable (names, crypto, validity, extensions, keyid)")
+
+var printStats = flag.Bool("stats", false, "If specified, will print statistics about the scanned keys, like the number of each kind of key and the age of user managed keys")
+var reportFile = flag.String("report", "", "Write a JSON report of every key to this file")
+var showCert = flag.Bool("show-cert", false, "If specified, will print the whole decoded certificate of each key printed (and add it to the --report), like the extensions and SPKI hash")
+var fingerprintsFile = flag.String("fingerprints", "", "Write a CSV of the SPKI SHA-256 fingerprint of every key to this file, to look for the public keys in other tools")
+var x509Retries = flag.Int("x509-retries", 3, "How many times to retry fetching the certificates of a service account after a transient error, like a 429 or 5xx response")
+var timeout = durationFlag("timeout", 0, "If specified, will stop the run after this long (like 30m), reporting the service accounts scanned so far like on Ctrl-C")
+var requestTimeout = durationFlag("request-timeout", 0, "If specified, the timeout of each HTTP request to the x509 endpoint and the GCP APIs (like 10s), instead of 30s for the x509 endpoint and none for the APIs")
+var showAPIUsage = flag.Bool("api-usage", false, "If specified, will print how many requests were made to each API and the peak request rates, to size the quotas and concurrency")
+var onlyKind = flag.String("only-kind", "", "Comma separated key kinds (like USER_PROVIDED/USER_MANAGED) to only report the keys of, in every output. The other keys of the service accounts are still checked, but aren't printed, counted or in the --report")
+var createdAfter = flag.String("created-after", "", "Only report the keys created (by their NotBefore) at or after this date (YYYY-MM-DD, in UTC) or RFC 3339 time")
+var createdBefore = flag.String("created-before", "", "Only report the keys created (by their NotBefore) before this date (YYYY-MM-DD, in UTC) or RFC 3339 time")
+var dryRun = flag.Bool("dry-run", false, "If specified, will only list the service accounts in scope, and print how many requests scanning them would make")
+var maxErrors = flag.Int("max-errors", -1, "Abort the run if the keys of more than this many service accounts can't be fetched, -1 to never abort")
+var batchSize = flag.Int("batch-size", 1000, "How many service accounts to fetch and classify at a time, the results of each batch are printed before the next is fetched. 0 to fetch them all at once")
+var resumeFile = flag.String("resume", "", "State file to save the progress of the scan to after each batch, so an interrupted scan run again with the same flags only scans the remaining service accounts. Removed once the scan completes")
+var stateFile = flag.String("state", "", "File recording the keys and their classification, updated at the end of every run. When it has the results of a previous run, only new keys, removed keys and keys whose classification changed since are reported")
+var historyFile = flag.String("history", "", "JSON Lines file to append the keys of every service account to at the end of the run, for the history and trends subcommands")
+var redact = flag.Bool("redact", false, "Replace the service account emails and project IDs in the output, --report and --fingerprints with keyed hashes, so they can be shared without sharing the names")
+var redactKeyFile = flag.String("redact-key-file", "", "With --redact, file with the secret key for the hashes, so the same names get the same hashes in every run. Without it a random key is used for each run")
+var cacheDir = flag.String("cache-dir", "", "Directory to cache the x509 endpoint responses in, so later runs make conditional requests and only download the certificates which changed")
+var cacheTTL = durationFlag("cache-ttl", time.Hour, "With --cache-dir, how long to use the cached certificates without asking the x509 endpoint at all (like 30m or 1d), after which they are revalidated. 0 to always revalidate")
+var groundTruthCacheTTL = durationFlag("ground-truth-cache-ttl", 10*time.Minute, "With --cache-dir and --ground-truth, how long to use the cached IAM API responses (like 5m), after which they are fetched again")
+var noCache = flag.Bool("no-cache", false, "With --cache-dir, ignore the cached certificates and fetch them all again, updating the cache")
+var recordDir = flag.String("record", "", "Directory to save every HTTP response from the x509 endpoint and GCP APIs to, so the run can be reproduced with --replay")
+var replayDir = flag.String("replay", "", "Directory of responses saved with --record to answer every request from, instead of making any requests. No credentials are needed")
 var outDir = flag.String("out-dir", "", "Output directory to write PEM x509 certificates to")
+var outFormat = flag.String("out-format", OUT_FORMAT_PEM, "With --out-dir, how to write the keys: pem (PEM certificates), der (DER certificates) or pkix (PEM public keys, without the certificate)")
+var outJWKS = flag.Bool("out-jwks", false, "With --out-dir, also write the public keys of each service account as a JWKS (<sa>/jwks.json), and of every service account as jwks.json")
+var credentialsFile = flag.String("credentials-file", "", "The credentials to call the GCP APIs with, instead of the application default credentials. Can be a workload identity federation credential configuration, to run from CI outside of GCP")
+var universeDomainFlag = flag.String("universe-domain", DEFAULT_UNIVERSE_DOMAIN, "The domain of the GCP APIs, for Trusted Partner Cloud and sovereign cloud deployments")
+var serviceAccountDomain = flag.String("service-account-domain", DEFAULT_SERVICE_ACCOUNT_DOMAIN, "The domain of the user managed service accounts (after the project ID), where it isn't iam.gserviceaccount.com")
+var caBundle = flag.String("ca-bundle", "", "A PEM file of CA certificates to trust on top of the system ones, for TLS intercepting proxies")
 var quotaProject = flag.String("quota-project", "", "Quota project to use for the GCP API. This is required if you are using a service account that is not in the same project as the service account you are trying to list keys for. This is also required if you are using the cloud asset API with --scope.")
+var perProjectQuota = flag.Bool("per-project-quota", false, "In ground truth mode, bill the IAM requests for each service account to its own project, with a separate rate limit for each project, instead of sharing the quota of one project")
+
+// ground truth sources
+const (
+	GROUND_TRUTH_IAM   = "iam"
+	GROUND_TRUTH_ASSET = "asset"
+)
 
 // output modes
 const (
 	OUTPUT_NORMAL       = "normal"
 	OUTPUT_VERBOSE      = "verbose"
 	OUTPUT_GROUND_TRUTH = "ground-truth"
+	OUTPUT_QUIET        = "quiet"
+	OUTPUT_SUMMARY      = "summary"
 )
 
 // return false if more than one of the flags is true
@@ -42,8 +140,8 @@ func checkMultualExcluveFlags(flags []bool) bool {
 }
 
 func decideOutputMode() (string, error) {
-	if !checkMultualExcluveFlags([]bool{*groundTruth, *verbose}) {
-		return "", fmt.Errorf("must specify one of --ground-truth, or --verbose")
+	if !checkMultualExcluveFlags([]bool{*groundTruth, *verbose, *quiet, *summaryOnly}) {
+		return "", fmt.Errorf("must specify one of --ground-truth, --verbose, --quiet or --summary-only")
 	}
 	if *groundTruth {
 		return OUTPUT_GROUND_TRUTH, nil
@@ -51,43 +149,300 @@ func decideOutputMode() (string, error) {
 	if *verbose {
 		return OUTPUT_VERBOSE, nil
 	}
+	if *quiet {
+		return OUTPUT_QUIET, nil
+	}
+	if *summaryOnly {
+		return OUTPUT_SUMMARY, nil
+	}
 	return OUTPUT_NORMAL, nil
 }
 
-func gcpClientOptions() []option.ClientOption {
-	var options []option.ClientOption
+// The clients build their endpoints from the universe domain, even with their own HTTP client
+func endpointClientOptions() []option.ClientOption {
+	if isDefaultUniverse() {
+		return nil
+	}
+	return []option.ClientOption{option.WithUniverseDomain(universeDomain)}
+}
+
+// The options of every client, before the HTTP client of the REST ones
+func commonClientOptions() []option.ClientOption {
+	options := append(endpointClientOptions(), option.WithUserAgent(userAgent()))
+	if *credentialsFile != "" {
+		options = append(options, option.WithCredentialsFile(*credentialsFile))
+	}
 	if *quotaProject != "" {
 		options = append(options, option.WithQuotaProject(*quotaProject))
 	}
 	return options
 }
 
+// For the REST clients, the asset inventory client uses gRPC so it gets grpcClientOptions instead
+func gcpClientOptions() []option.ClientOption {
+	endpoints := endpointClientOptions()
+	options := commonClientOptions()
+	var base http.RoundTripper = http.DefaultTransport
+	if *replayDir != "" {
+		base = &replayTransport{dir: *replayDir}
+	}
+	if apiUsage != nil {
+		base = &countingTransport{base: base}
+	}
+	if *replayDir != "" {
+		// no credentials are needed to replay
+		return append(endpoints, option.WithHTTPClient(&http.Client{Transport: base}))
+	}
+	if *recordDir != "" || *requestTimeout > 0 || apiUsage != nil {
+		transport, err := htransport.NewTransport(context.Background(), base, append(options, option.WithScopes(CLOUD_PLATFORM_SCOPE))...)
+		if err != nil {
+			fatal(err.Error())
+		}
+		if *recordDir != "" {
+			transport = &recordingTransport{dir: *recordDir, base: transport}
+		}
+		return append(endpoints, option.WithHTTPClient(&http.Client{Transport: transport, Timeout: *requestTimeout}))
+	}
+	return options
+}
+
+// Switches the x509 client to record or replay, the GCP API clients pick it up in gcpClientOptions
+func setupRecording() error {
+	if *recordDir != "" && *replayDir != "" {
+		return fmt.Errorf("--record and --replay can't be used together")
+	}
+	if *cacheDir != "" {
+		return fmt.Errorf("--cache-dir can't be used with --record or --replay, as the cached responses wouldn't be recorded")
+	}
+	// the asset inventory client uses gRPC rather than HTTP
+	if len(*scopes) > 0 || *groundTruthSource == GROUND_TRUTH_ASSET {
+		return fmt.Errorf("--scope and --ground-truth-source %v can't be used with --record or --replay", GROUND_TRUTH_ASSET)
+	}
+	if *replayDir != "" {
+		x509Client.Transport = &replayTransport{dir: *replayDir}
+		return nil
+	}
+	if err := os.MkdirAll(*recordDir, 0755); err != nil {
+		return fmt.Errorf("error creating directory %v: %v", *recordDir, err)
+	}
+	x509Client.Transport = &recordingTransport{dir: *recordDir, base: x509Client.Transport}
+	return nil
+}
+
+// The project the IAM requests for a service account should be billed to, or empty for the default quota project
+func iamConsumerProject(sa string) string {
+	if !*perProjectQuota {
+		return ""
+	}
+	return projectFromServiceAccount(sa)
+}
+
 var iamService = sync.OnceValue(func() *iam.Service {
 	iamService, err := iam.NewService(context.Background(), gcpClientOptions()...)
 	if err != nil {
-		fmt.Println(err)
-		os.Exit(1)
+		fatal(err.Error())
 	}
 	return iamService
 })
 
-func getTargetServiceAccounts() ([]string, error) {
-	if !checkMultualExcluveFlags([]bool{*inFile != "", flag.NArg() > 0, *scope != "", *project != ""}) {
-		return nil, fmt.Errorf("must specify one of --scope, --project, --in, or service accounts as arguments")
+var policyAnalyzerService = sync.OnceValue(func() *policyanalyzer.Service {
+	policyAnalyzerService, err := policyanalyzer.NewService(context.Background(), gcpClientOptions()...)
+	if err != nil {
+		fatal(err.Error())
+	}
+	return policyAnalyzerService
+})
+
+var loggingService = sync.OnceValue(func() *logging.Service {
+	loggingService, err := logging.NewService(context.Background(), gcpClientOptions()...)
+	if err != nil {
+		fatal(err.Error())
+	}
+	return loggingService
+})
+
+var storageService = sync.OnceValue(func() *storage.Service {
+	storageService, err := storage.NewService(context.Background(), gcpClientOptions()...)
+	if err != nil {
+		fatal(err.Error())
+	}
+	return storageService
+})
+
+var bigqueryService = sync.OnceValue(func() *bigquery.Service {
+	bigqueryService, err := bigquery.NewService(context.Background(), gcpClientOptions()...)
+	if err != nil {
+		fatal(err.Error())
+	}
+	return bigqueryService
+})
+
+var resourceManagerService = sync.OnceValue(func() *cloudresourcemanager.Service {
+	resourceManagerService, err := cloudresourcemanager.NewService(context.Background(), gcpClientOptions()...)
+	if err != nil {
+		fatal(err.Error())
+	}
+	return resourceManagerService
+})
+
+func getTargetServiceAccounts(ctx context.Context) ([]string, error) {
+	serviceAccountIDs, err := enumerateServiceAccounts(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// before anything is logged about their projects, like a failed per project lookup
+	for _, sa := range serviceAccountIDs {
+		redactor.addProject(projectFromServiceAccount(sa))
+	}
+
+	// dedupe both before resolving unique IDs, to save quota, and after, since an email and
+	// a unique ID might be the same service account
+	serviceAccountIDs, duplicates := dedupeServiceAccounts(serviceAccountIDs)
+	serviceAccountIDs, err = resolveUniqueIDs(ctx, serviceAccountIDs)
+	if err != nil {
+		return nil, err
+	}
+	serviceAccountIDs, n := dedupeServiceAccounts(serviceAccountIDs)
+	duplicates += n
+	if duplicates > 0 {
+		slog.Info("Skipping duplicate service accounts in the input", "count", duplicates)
+	}
+
+	// before the other filters, so the jobs only look up the projects of their own shard
+	if *shardFlag != "" {
+		shard, err := parseShard(*shardFlag)
+		if err != nil {
+			return nil, err
+		}
+		total := len(serviceAccountIDs)
+		serviceAccountIDs = shard.filter(serviceAccountIDs)
+		slog.Info("Scanning shard", "shard", *shardFlag, "serviceAccounts", len(serviceAccountIDs), "total", total)
+	}
+
+	if len(*excludeProjectLabels) > 0 {
+		if len(*scopes) == 0 && len(*projects) == 0 && len(*folders) == 0 && len(*organizations) == 0 && *assetExport == "" {
+			return nil, fmt.Errorf("--exclude-project-label can only be used with --project, --scope, --folder, --organization or --asset-export")
+		}
+		var filters []LabelFilter
+		for _, s := range *excludeProjectLabels {
+			f, err := parseLabelFilter(s)
+			if err != nil {
+				return nil, err
+			}
+			filters = append(filters, f)
+		}
+		serviceAccountIDs = excludeByProjectLabels(ctx, serviceAccountIDs, filters)
+	}
+
+	if len(*excludeProjectPatterns) > 0 {
+		patterns, err := parsePatterns(*excludeProjectPatterns)
+		if err != nil {
+			return nil, err
+		}
+		serviceAccountIDs = excludeProjects(ctx, serviceAccountIDs, patterns)
+	}
+
+	if *skipServiceAgents || *skipDefaultSAs {
+		serviceAccountIDs = skipServiceAccountCategories(serviceAccountIDs, *skipServiceAgents, *skipDefaultSAs)
 	}
 
-	if *scope != "" {
-		c, err := asset.NewClient(context.Background(), gcpClientOptions()...)
+	if len(*includeSAs) > 0 || len(*excludeSAs) > 0 {
+		include, err := parsePatterns(*includeSAs)
 		if err != nil {
 			return nil, err
 		}
-		return getServiceAccountIDsViaAssetInventory(context.Background(), c, *scope)
-	} else if *project != "" {
-		return getServiceAccountIDsInProject(context.Background(), iamService(), *project)
+		exclude, err := parsePatterns(*excludeSAs)
+		if err != nil {
+			return nil, err
+		}
+		serviceAccountIDs = filterServiceAccounts(serviceAccountIDs, include, exclude)
+	}
+
+	return serviceAccountIDs, nil
+}
+
+func enumerateServiceAccounts(ctx context.Context) ([]string, error) {
+	traverse := len(*folders) > 0 || len(*organizations) > 0
+	if *useGcloudProject && !slices.Contains([]bool{*inFile != "", flag.NArg() > 0, len(*scopes) > 0, len(*projects) > 0, traverse, *assetExport != "", *fromDir != ""}, true) {
+		project, source, err := defaultProject()
+		if err != nil {
+			return nil, err
+		}
+		redactor.addProject(project)
+		slog.Info("Using default project", "project", project, "source", source)
+		*projects = []string{project}
+	}
+	if !checkMultualExcluveFlags([]bool{*inFile != "", flag.NArg() > 0, len(*scopes) > 0, len(*projects) > 0, traverse, *assetExport != "", *fromDir != ""}) {
+		return nil, fmt.Errorf("must specify one of --scope, --project, --folder/--organization, --asset-export, --in, --from-dir, or service accounts as arguments")
+	}
+
+	if *fromDir != "" {
+		return loadCertsFromDir(*fromDir)
+	}
+
+	if len(*scopes) > 0 {
+		c, err := asset.NewClient(ctx, grpcClientOptions()...)
+		if err != nil {
+			return nil, err
+		}
+		// service accounts under more than one of the scopes are deduplicated with the rest of the input
+		var res []string
+		for _, scope := range *scopes {
+			serviceAccountIDs, err := getServiceAccountIDsViaAssetInventory(ctx, c, scope)
+			if err != nil {
+				return nil, fmt.Errorf("unable to search service accounts in %v: %v", scope, err)
+			}
+			res = append(res, serviceAccountIDs...)
+		}
+		return res, nil
+	} else if len(*projects) > 0 {
+		var res []string
+		for _, project := range *projects {
+			serviceAccountIDs, err := getServiceAccountIDsInProject(ctx, iamService(), project)
+			if err != nil {
+				return nil, fmt.Errorf("unable to list service accounts in project %v: %v", project, err)
+			}
+			res = append(res, serviceAccountIDs...)
+		}
+		return res, nil
+	} else if traverse {
+		var parents []string
+		for _, folder := range *folders {
+			parents = append(parents, "folders/"+strings.TrimPrefix(folder, "folders/"))
+		}
+		for _, organization := range *organizations {
+			parents = append(parents, "organizations/"+strings.TrimPrefix(organization, "organizations/"))
+		}
+		var res []string
+		for _, parent := range parents {
+			projects, err := getProjectsUnder(ctx, resourceManagerService(), parent)
+			if err != nil {
+				return nil, err
+			}
+			slog.Info("Found projects", "parent", parent, "count", len(projects))
+			// there can be projects we can't list service accounts in, which shouldn't stop the whole run
+			for _, project := range projects {
+				serviceAccountIDs, err := getServiceAccountIDsInProject(ctx, iamService(), project)
+				if err != nil {
+					slog.Warn("Unable to list service accounts in project, skipping it", "project", project, "error", err)
+					continue
+				}
+				res = append(res, serviceAccountIDs...)
+			}
+		}
+		return res, nil
+	} else if *assetExport != "" {
+		return getServiceAccountIDsFromAssetExport(ctx, *assetExport)
+	} else if strings.HasSuffix(strings.ToLower(*inFile), ".csv") {
+		return getServiceAccountsFromCSV(*inFile, *inColumn)
 	} else if *inFile != "" {
-		return getServiceAccountsFromFile(*inFile)
+		lines, err := getServiceAccountsFromFile(*inFile)
+		if err != nil {
+			return nil, err
+		}
+		return parseServiceAccountIDs(lines, func(i int) string { return fmt.Sprintf("%v:%d", *inFile, i+1) })
 	} else {
-		return flag.Args(), nil
+		return parseServiceAccountIDs(flag.Args(), func(i int) string { return fmt.Sprintf("argument %d", i+1) })
 	}
 }
 
@@ -110,100 +465,547 @@ func getServiceAccountsFromFile(s string) ([]string, error) {
 	return res, nil
 }
 
+// metadata is nil unless it was fetched in ground truth mode
+func printServiceAccountHeader(serviceAccountID string, metadata *ServiceAccountReport) {
+	fmt.Printf("Service Account: %v\n", serviceAccountID)
+	if note := serviceAccountCategoryNote(serviceAccountID); note != "" {
+		fmt.Printf("  Note: %v\n", note)
+	}
+	if metadata != nil {
+		fmt.Printf("  Unique ID: %v\n", metadata.UniqueID)
+		if metadata.DisplayName != "" {
+			fmt.Printf("  Display name: %v\n", metadata.DisplayName)
+		}
+		if metadata.Description != "" {
+			fmt.Printf("  Description: %v\n", metadata.Description)
+		}
+		if metadata.Disabled {
+			fmt.Printf("  Disabled: the service account is disabled, so its keys can't currently be used\n")
+		}
+	}
+}
+
+// Registers the rules from the --config file, and turns off the --disable-checkers
+func setupCheckers(configFile string, disable string) error {
+	if configFile != "" {
+		config, err := loadConfig(configFile)
+		if err != nil {
+			return err
+		}
+		if err := registerRules(config.Rules); err != nil {
+			return err
+		}
+	}
+	if disable != "" {
+		if err := disableCheckers(strings.Split(disable, ",")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "merge":
+			mergeCommand(os.Args[2:])
+		case "diff":
+			diffCommand(os.Args[2:])
+		case "history":
+			historyCommand(os.Args[2:])
+		case "trends":
+			trendsCommand(os.Args[2:])
+		case "check-cert":
+			checkCertCommand(os.Args[2:])
+		case "check-keyfile":
+			checkKeyFileCommand(os.Args[2:])
+		case "scan-fs":
+			scanFSCommand(os.Args[2:])
+		case "triage":
+			triageCommand(os.Args[2:])
+		case "inspect-jwt":
+			inspectJWTCommand(os.Args[2:])
+		}
+	}
+
 	flag.Parse()
+	if *versionFlag {
+		printVersion()
+		exit(EXIT_OK)
+	}
+	setupColor(*noColor)
+	if err := setupUniverse(*universeDomainFlag, *serviceAccountDomain); err != nil {
+		fatal(err.Error())
+	}
+
+	if *redact {
+		if *projectMetadata {
+			fatal("--redact can't be used with --project-metadata, as the project names and folders would be shared")
+		}
+		var key []byte
+		if *redactKeyFile != "" {
+			b, err := os.ReadFile(*redactKeyFile)
+			if err != nil {
+				fatal(fmt.Sprintf("error reading --redact-key-file: %v", err))
+			}
+			if key = bytes.TrimSpace(b); len(key) == 0 {
+				fatal("--redact-key-file is empty")
+			}
+		}
+		redactor = NewRedactor(*projects, key)
+		for _, scope := range *scopes {
+			if project, found := strings.CutPrefix(scope, "projects/"); found {
+				redactor.addProject(project)
+			}
+		}
+		if err := redactor.redactStdout(); err != nil {
+			fatal(err.Error())
+		}
+	}
+	if err := setupLogging(*logLevel, *logFormat, *quiet); err != nil {
+		fatal(err.Error())
+	}
+	// these are read back by the tool, which needs the names, and the certificates have the names in them anyway
+	if *redact {
+		var unredacted []string
+		for _, f := range []struct{ name, value string }{
+			{"--out-dir", *outDir}, {"--state", *stateFile}, {"--history", *historyFile}, {"--resume", *resumeFile},
+			{"--cache-dir", *cacheDir}, {"--record", *recordDir},
+		} {
+			if f.value != "" {
+				unredacted = append(unredacted, f.name)
+			}
+		}
+		if len(unredacted) > 0 {
+			slog.Warn("--redact doesn't apply to the files written by these flags, they have the real names and shouldn't be shared", "flags", unredacted)
+		}
+	}
+	if *showAPIUsage {
+		apiUsage = NewAPIUsage()
+	}
+	if *caBundle != "" {
+		if err := setupCABundle(*caBundle); err != nil {
+			fatal(err.Error())
+		}
+	}
+	logProxy()
+
+	// Ctrl-C stops starting new requests, and cancels the ones in flight. The service accounts fetched so far are
+	// still reported, and a second Ctrl-C exits right away
+	signalCtx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-signalCtx.Done()
+		stop()
+	}()
+	ctx := signalCtx
+	if *timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *timeout)
+		defer cancel()
+	}
+	if *requestTimeout > 0 {
+		x509Client.Timeout = *requestTimeout
+	}
+	if *credentialsFile != "" {
+		if err := checkCredentialsFile(*credentialsFile); err != nil {
+			fatal(err.Error())
+		}
+	}
+
+	if err := setupCheckers(*configFile, *disableCheckersFlag); err != nil {
+		fatal(err.Error())
+	}
 
-	serviceAccountIDs, err := getTargetServiceAccounts()
+	if len(*weakKeyFiles) > 0 {
+		if err := loadWeakKeyBlocklist(*weakKeyFiles); err != nil {
+			fatal(err.Error())
+		}
+	}
+
+	if *recordDir != "" || *replayDir != "" {
+		if err := setupRecording(); err != nil {
+			fatal(err.Error())
+		}
+	}
+
+	serviceAccountIDs, err := getTargetServiceAccounts(ctx)
 	if err != nil {
-		fmt.Println(err)
-		os.Exit(1)
+		fatal(err.Error())
 	}
 
-	if len(serviceAccountIDs) == 0 {
-		fmt.Println("No service accounts specified. Please specify one or more service accounts or use --project or --scope.")
-		os.Exit(1)
+	// a shard can be empty, but it still writes its (empty) report for the merge
+	if len(serviceAccountIDs) == 0 && *shardFlag == "" {
+		fatal("No service accounts specified. Please specify one or more service accounts or use --project or --scope.")
+	}
+
+	if *x509Retries < 0 || *x509Retries > MaxRetries {
+		fatal(fmt.Sprintf("invalid --x509-retries %v, must be between 0 and %v", *x509Retries, MaxRetries))
+	}
+
+	if *groundTruthSource != GROUND_TRUTH_IAM && *groundTruthSource != GROUND_TRUTH_ASSET {
+		fatal(fmt.Sprintf("invalid --ground-truth-source %v, must be %v or %v", *groundTruthSource, GROUND_TRUTH_IAM, GROUND_TRUTH_ASSET))
+	}
+
+	if *crossCheckJWK && *fromDir != "" {
+		fatal("--cross-check-jwk can't be used with --from-dir, as the certificates aren't fetched")
+	}
+
+	if *perProjectQuota && *quotaProject != "" {
+		fatal("--per-project-quota and --quota-project can't be used together")
+	}
+
+	if *cacheDir != "" {
+		x509Cache, err = NewX509Cache(*cacheDir, *cacheTTL, *noCache)
+		if err != nil {
+			fatal(err.Error())
+		}
+		groundTruthCache, err = NewGroundTruthCache(*cacheDir, *groundTruthCacheTTL, *noCache)
+		if err != nil {
+			fatal(err.Error())
+		}
+	}
+
+	if *tui && !stdinIsTerminal() {
+		fatal("--tui needs a terminal to read the commands from")
+	}
+	if *tui && *quiet {
+		fatal("--tui can't be used with --quiet, which exits once the failing keys are counted")
+	}
+
+	if *outJWKS && *outDir == "" {
+		fatal("--out-jwks needs --out-dir")
 	}
 
 	outputMode, err := decideOutputMode()
 	if err != nil {
-		fmt.Println(err)
-		os.Exit(1)
+		fatal(err.Error())
 	}
 
-	fmt.Printf("Analyzing %d service accounts\n", len(serviceAccountIDs))
+	// after the checks of the flags, so a dry run also catches those
+	if *dryRun {
+		planScan(serviceAccountIDs).print()
+		exit(EXIT_OK)
+	}
+	var out *OutDir
+	if *outDir != "" {
+		out, err = NewOutDir(*outDir, *outFormat, *outJWKS)
+		if err != nil {
+			fatal(err.Error())
+		}
+	}
+
+	scan := NewScan(outputMode)
+	if *recordDir != "" {
+		if err := saveRecordedScan(*recordDir, scan.now); err != nil {
+			fatal(err.Error())
+		}
+	}
+	if *replayDir != "" {
+		scan.now, err = loadRecordedScan(*replayDir)
+		if err != nil {
+			fatal(err.Error())
+		}
+	}
+	if *policyFile != "" {
+		scan.policy, err = loadPolicy(ctx, *policyFile)
+		if err != nil {
+			fatal(err.Error())
+		}
+	}
+
+	if *baselineFile != "" {
+		scan.baseline, err = loadBaseline(*baselineFile)
+		if err != nil {
+			fatal(err.Error())
+		}
+	}
 
-	keyCollection := NewKeyCollection(serviceAccountIDs)
-	err = keyCollection.FetchKeys(*groundTruth, *quotaProject)
+	scan.ignores.serviceAccounts, err = parsePatterns(*ignoreSAs)
 	if err != nil {
-		fmt.Println(err)
-		os.Exit(1)
+		fatal(err.Error())
+	}
+	scan.ignores.keyIDs, err = parsePatterns(*ignoreKeyIDs)
+	if err != nil {
+		fatal(err.Error())
 	}
 
-	if *outDir != "" {
-		err = keyCollection.WritePublicKeysToDir(*outDir)
+	if *terraformUnmanagedScript != "" && len(*terraformStates) == 0 {
+		fatal("--terraform-unmanaged-script needs --terraform-state")
+	}
+	if len(*terraformStates) > 0 {
+		scan.terraform, err = loadTerraformStates(*terraformStates)
 		if err != nil {
-			fmt.Println(err)
-			os.Exit(1)
+			fatal(err.Error())
 		}
 	}
 
-	good := 0
-	bad := 0
+	scan.keyFilter, err = parseKeyFilter(*onlyKind, *createdAfter, *createdBefore)
+	if err != nil {
+		fatal(err.Error())
+	}
+
+	scan.failOnSeverity, err = parseFailOn(*failOn)
+	if err != nil {
+		fatal(err.Error())
+	}
 
-	for i, serviceAccountID := range serviceAccountIDs {
-		if keyCollection.isBadSA(serviceAccountID) {
-			continue
+	scan.keyPolicy = KeyPolicy{
+		maxKeyAge:      *maxKeyAge,
+		expiringWithin: *expiringWithin,
+	}
+
+	slog.Info("Analyzing service accounts", "count", len(serviceAccountIDs))
+
+	if *stateFile != "" {
+		if outputMode == OUTPUT_GROUND_TRUTH {
+			fatal("--state can't be used with --ground-truth")
 		}
-		printedName := false
-		if outputMode == OUTPUT_VERBOSE {
-			fmt.Printf("Service Account: %v\n", serviceAccountID)
+		scan.previousSnapshot, err = loadSnapshot(*stateFile)
+		if err != nil {
+			fatal(err.Error())
 		}
+		scan.snapshot = NewSnapshot(scan.previousSnapshot, scan.now)
+	}
 
-		hasBadKeys := false
-		for keyId, cert := range keyCollection.observedKeys[i] {
-			key := NewSAKey(serviceAccountID, cert)
-			keyKind := key.determineKeyKind()
-			switch outputMode {
-			case OUTPUT_NORMAL:
-				if keyKind != GOOGLE_PROVIDED_SYSTEM_MANAGED {
-					if !printedName {
-						fmt.Printf("Service Account: %v\n", serviceAccountID)
-						printedName = true
-					}
-					key.dump("  ", true)
-					hasBadKeys = true
-				}
-			case OUTPUT_VERBOSE:
-				key.dump("  ", true)
-				if keyKind != GOOGLE_PROVIDED_SYSTEM_MANAGED {
-					hasBadKeys = true
-				}
-			case OUTPUT_GROUND_TRUTH:
-				realKey := keyCollection.groundTruthKeys[i][keyId]
-				realKeyKind := keyTypeAndOriginToMuxedKeyKind(realKey.KeyType, realKey.KeyOrigin)
-				if realKeyKind != keyKind {
-					hasBadKeys = true
-					if !printedName {
-						fmt.Printf("Service Account: %v\n", serviceAccountID)
-						printedName = true
-					}
-					fmt.Printf("  Key ID: %v - expected %v, got %v\n", key.cert.SerialNumber, realKeyKind, keyKind)
-					key.dump("    ", true)
-				}
+	if *historyFile != "" {
+		scan.history = []HistoryRecord{}
+	}
+
+	if *resumeFile != "" {
+		err = scan.loadState(*resumeFile)
+		if err != nil {
+			fatal(err.Error())
+		}
+		if len(scan.completed) > 0 {
+			completed := map[string]bool{}
+			for _, sa := range scan.completed {
+				completed[sa] = true
+			}
+			serviceAccountIDs = slices.DeleteFunc(serviceAccountIDs, func(sa string) bool { return completed[sa] })
+			slog.Info("Resuming", "file", *resumeFile, "scanned", len(scan.completed), "left", len(serviceAccountIDs))
+		}
+	}
+
+	size := *batchSize
+	if size <= 0 {
+		size = len(serviceAccountIDs)
+	}
+	// each batch is fetched, classified and printed before the next one is fetched, so only one batch of
+	// certificates is held in memory and the results of large scopes come out as they go
+	var keyCollection *KeyCollection
+	interrupted := false
+	// --max-errors stops the run as soon as the limit is passed, rather than once the batch has been fetched
+	var abortRun context.CancelCauseFunc
+	ctx, abortRun = context.WithCancelCause(ctx)
+	defer abortRun(nil)
+	// the service accounts scanned (or skipped) by this run, the rest are left for a --resume if it is interrupted
+	done := 0
+	for start := 0; start < len(serviceAccountIDs) && !interrupted; start += size {
+		batch := serviceAccountIDs[start:min(start+size, len(serviceAccountIDs))]
+		if size < len(serviceAccountIDs) {
+			slog.Info("Fetching batch of service accounts", "from", start+1, "to", start+len(batch), "total", len(serviceAccountIDs))
+		}
+		keyCollection = keyCollection.nextBatch(batch)
+		if *maxErrors >= 0 {
+			keyCollection.errorBudget = *maxErrors - len(scan.skipped)
+			keyCollection.tooManyErrors = func() { abortRun(errTooManyErrors) }
+		}
+
+		// once interrupted, the keys fetched so far are still classified and reported, without the later lookups
+		fetch := func(f func(context.Context) error) {
+			if interrupted {
+				return
+			}
+			err := f(ctx)
+			if ctx.Err() != nil {
+				interrupted = true
+			} else if err != nil {
+				fatal(err.Error())
 			}
 		}
-		if hasBadKeys {
-			bad++
-		} else {
-			good++
+
+		fetch(func(ctx context.Context) error {
+			return keyCollection.FetchKeys(ctx, *groundTruth, *groundTruthSource, *quotaProject)
+		})
+
+		if skipped := len(scan.skipped) + len(keyCollection.skippedSAs()); *maxErrors >= 0 && (skipped > *maxErrors || errors.Is(context.Cause(ctx), errTooManyErrors)) {
+			printSkipped(append(scan.skipped, keyCollection.skippedSAs()...))
+			fatal(fmt.Sprintf("Aborting, more than --max-errors %d service accounts couldn't be fetched", *maxErrors))
+		}
+
+		if *crossCheckJWK {
+			fetch(keyCollection.FetchJWKs)
+		}
+
+		if *lastAuth {
+			fetch(keyCollection.FetchLastAuthentications)
+		}
+
+		if *auditLogWindow > 0 {
+			fetch(func(ctx context.Context) error {
+				return keyCollection.FetchKeyUsage(ctx, *auditLogWindow, scan.now)
+			})
+		}
+
+		if *projectMetadata {
+			fetch(keyCollection.FetchProjectMetadata)
+		}
+
+		if *rankByPrivilege {
+			fetch(keyCollection.FetchRoles)
+		}
+
+		if *showWorkloads {
+			fetch(keyCollection.FetchWorkloads)
+		}
+
+		if out != nil {
+			err = out.write(keyCollection)
+			if err != nil {
+				fatal(err.Error())
+			}
+		}
+
+		analyzeCtx := ctx
+		if interrupted {
+			analyzeCtx = context.WithoutCancel(ctx)
+		}
+		err = scan.analyze(analyzeCtx, keyCollection)
+		if err != nil {
+			fatal(err.Error())
+		}
+		done += len(batch) - keyCollection.interruptedSAs()
+
+		// appended as each batch is done, so the history isn't held in memory for the whole run
+		if scan.history != nil {
+			if err := appendHistory(*historyFile, scan.history); err != nil {
+				fatal(err.Error())
+			}
+			scan.history = scan.history[:0]
+		}
+
+		if *resumeFile != "" {
+			err = scan.saveState(*resumeFile)
+			if err != nil {
+				fatal(err.Error())
+			}
 		}
 	}
 
-	fmt.Printf("Good SAs: %d, Bad SAs: %d\n", good, bad)
+	if out != nil {
+		if err := out.close(); err != nil {
+			fatal(err.Error())
+		}
+	}
 
-	if bad > 0 {
-		os.Exit(1)
+	report := scan.report
+	if interrupted {
+		reason := "interrupted"
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			reason = "--timeout reached"
+		}
+		report.NotScanned = len(serviceAccountIDs) - done
+		slog.Warn("The scan was stopped, reporting the service accounts scanned so far", "reason", reason, "notScanned", report.NotScanned)
+	}
+	report.Stats = scan.stats
+	report.Skipped = scan.skipped
+	report.SharedModuli = scan.sharedModuli()
+	if scan.sharedModuliFail(report.SharedModuli) {
+		scan.failed = true
+	}
+	// the other shards scan the rest of the projects' service accounts, and interrupted runs didn't get to them
+	if scan.previousSnapshot != nil && *shardFlag == "" && !interrupted {
+		scan.delta.RemovedServiceAccounts = scan.previousSnapshot.removedServiceAccounts(serviceAccountIDs)
+		scan.snapshot.remove(scan.delta.RemovedServiceAccounts)
+	}
+	if scan.previousSnapshot != nil {
+		report.Changes = &scan.delta
+	}
+	if apiUsage != nil {
+		report.APIUsage = apiUsage.report()
+	}
+	if *rankByPrivilege {
+		report.Ranking = scan.ranking()
+	}
+	if *reportFile != "" {
+		if err := writeReport(*reportFile, report); err != nil {
+			fatal(err.Error())
+		}
+	}
+
+	if *fingerprintsFile != "" {
+		if err := writeFingerprints(*fingerprintsFile, report); err != nil {
+			fatal(err.Error())
+		}
+	}
+
+	if *terraformUnmanagedScript != "" {
+		if err := writeTerraformUnmanagedScript(*terraformUnmanagedScript, report); err != nil {
+			fatal(err.Error())
+		}
+	}
+
+	if scan.snapshot != nil {
+		if err := scan.snapshot.save(*stateFile); err != nil {
+			fatal(err.Error())
+		}
+	}
+
+	// the scan is complete, so a later run with the same --resume starts from scratch
+	if *resumeFile != "" && !interrupted {
+		if err := os.Remove(*resumeFile); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			fatal(err.Error())
+		}
+	}
+
+	if outputMode == OUTPUT_QUIET {
+		fmt.Printf("Failing keys: %d, Bad SAs: %d, Skipped SAs: %d\n", scan.failingKeys, scan.bad, len(report.Skipped))
+		scan.exit(report)
+	}
+
+	if outputMode == OUTPUT_VERBOSE && x509Cache != nil {
+		x509Cache.printStats()
+	}
+	if *printStats {
+		scan.stats.print()
+	}
+	if apiUsage != nil {
+		printAPIUsage(report.APIUsage)
+	}
+	if outputMode != OUTPUT_SUMMARY {
+		printSharedModuli(report.SharedModuli)
+	}
+	printRanking(report.Ranking)
+	printSkipped(report.Skipped)
+	if report.NotScanned > 0 {
+		fmt.Printf("Not scanned, as the run was stopped: %d service accounts\n", report.NotScanned)
+	}
+	if scan.previousSnapshot != nil {
+		scan.delta.print(scan.previousSnapshot)
+	}
+	if outputMode == OUTPUT_SUMMARY {
+		report.printProjects()
 	} else {
-		os.Exit(0)
+		report.printSummary()
+	}
+	badColor := COLOR_GREEN
+	if scan.bad > 0 {
+		badColor = COLOR_RED
+	}
+	fmt.Printf("%v, %v\n", colorize(COLOR_GREEN, fmt.Sprintf("Good SAs: %d", scan.good)), colorize(badColor, fmt.Sprintf("Bad SAs: %d", scan.bad)))
+	if scan.warned > 0 {
+		fmt.Printf("SAs with warnings: %d\n", scan.warned)
+	}
+	if scan.unknown > 0 {
+		fmt.Printf("Keys of unknown kind: %d\n", scan.unknown)
+	}
+	if scan.suppressed > 0 {
+		fmt.Printf("Suppressed keys: %d\n", scan.suppressed)
+	}
+
+	if *tui {
+		NewBrowser(report).run(os.Stdin, os.Stdout)
 	}
+	scan.exit(report)
 }
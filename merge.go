@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+)
+
+// merge [-o combined.json] a.json b.json ...
+// Combines the --report files of several runs, like the shards of a scan, into one report
+func mergeCommand(args []string) {
+	fs := flag.NewFlagSet("merge", flag.ExitOnError)
+	out := fs.String("o", "", "File to write the combined report to, instead of stdout")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %v merge [-o combined.json] report.json...\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+
+	// flags can come after the reports too
+	var files []string
+	for {
+		fs.Parse(args)
+		args = fs.Args()
+		if len(args) == 0 {
+			break
+		}
+		files = append(files, args[0])
+		args = args[1:]
+	}
+	if len(files) == 0 {
+		fs.Usage()
+		os.Exit(EXIT_FATAL)
+	}
+
+	var reports []*Report
+	for _, file := range files {
+		report, err := readReport(file)
+		if err != nil {
+			fatal(err.Error())
+		}
+		reports = append(reports, report)
+	}
+	combined, duplicates := mergeReports(reports, time.Now())
+	if len(duplicates) > 0 {
+		// stderr, so the combined report on stdout is still valid JSON
+		slog.Warn("Service accounts are in more than one report, using the results of the last one", "count", len(duplicates), "serviceAccounts", strings.Join(duplicates, ", "))
+	}
+
+	if *out == "" {
+		data, err := json.MarshalIndent(combined, "", "  ")
+		if err != nil {
+			fatal(err.Error())
+		}
+		fmt.Println(string(data))
+		os.Exit(EXIT_OK)
+	}
+
+	if err := writeReport(*out, combined); err != nil {
+		fatal(err.Error())
+	}
+	fmt.Printf("Merged %d reports into %v\n", len(files), *out)
+	combined.printSummary()
+	fmt.Printf("Good SAs: %d, Bad SAs: %d\n", combined.Good, combined.Bad)
+	os.Exit(EXIT_OK)
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+
+	"google.golang.org/api/option"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+// nil unless --ca-bundle
+var caBundleTLSConfig *tls.Config
+
+// Trusts the certificates in the --ca-bundle on top of the system ones, for TLS intercepting proxies. This covers the
+// x509 fetches, the REST API clients and the token requests of the credentials, which all base their transports on
+// http.DefaultTransport, and the gRPC clients through grpcClientOptions
+func setupCABundle(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("unable to read --ca-bundle: %v", err)
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(data) {
+		return fmt.Errorf("invalid --ca-bundle %v: no PEM certificates found", path)
+	}
+	caBundleTLSConfig = &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}
+	http.DefaultTransport.(*http.Transport).TLSClientConfig = caBundleTLSConfig.Clone()
+	x509Client.Transport.(*http.Transport).TLSClientConfig = caBundleTLSConfig.Clone()
+	return nil
+}
+
+// gRPC picks up HTTPS_PROXY and NO_PROXY from the environment like net/http, but not the --ca-bundle
+// A gRPC client can't be given an HTTP client, so --record, --replay and --request-timeout don't apply to it either
+func grpcClientOptions() []option.ClientOption {
+	options := commonClientOptions()
+	if caBundleTLSConfig != nil {
+		options = append(options, option.WithGRPCDialOption(grpc.WithTransportCredentials(credentials.NewTLS(caBundleTLSConfig.Clone()))))
+	}
+	if apiUsage != nil {
+		options = append(options, countingGRPCOption())
+	}
+	return options
+}
+
+// Every client uses http.ProxyFromEnvironment, so they all go through the same proxy
+func logProxy() {
+	req := &http.Request{URL: &url.URL{Scheme: "https", Host: "www." + universeDomain}}
+	proxy, err := http.ProxyFromEnvironment(req)
+	if err != nil {
+		slog.Warn("Invalid proxy in the environment", "error", err)
+	} else if proxy != nil {
+		slog.Debug("Using proxy", "proxy", proxy.Redacted())
+	}
+}
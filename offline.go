@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// service account -> its certificates read from --from-dir, nil unless it was given
+// These are used instead of fetching the certificates from the x509 endpoint
+var offlineCerts map[string]ServiceAccountCerts
+
+// Reads the certificates in a directory written by --out-dir, named <sa>/<keyid>.pem (or <sa>_<keyid>.pem, as older
+// versions wrote them). The index.json has the real names, in case they had to be changed to be safe file names
+// Returns the service accounts in the order of their files
+func loadCertsFromDir(dir string) ([]string, error) {
+	nested, err := filepath.Glob(filepath.Join(dir, "*", "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+	flat, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+	files := append(nested, flat...)
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no .pem files found in %v", dir)
+	}
+	index, err := readIndex(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	offlineCerts = map[string]ServiceAccountCerts{}
+	var serviceAccountIDs []string
+	for _, file := range files {
+		rel, err := filepath.Rel(dir, file)
+		if err != nil {
+			return nil, err
+		}
+		var sa, keyID string
+		if entry, ok := index[filepath.ToSlash(rel)]; ok {
+			sa, keyID = entry.ServiceAccount, entry.KeyID
+		} else if parent, name, found := strings.Cut(filepath.ToSlash(rel), "/"); found {
+			sa, keyID = strings.ToLower(parent), strings.TrimSuffix(name, ".pem")
+		} else {
+			// key IDs are hex, so the last _ separates them from the email
+			name := strings.TrimSuffix(rel, ".pem")
+			idx := strings.LastIndex(name, "_")
+			if idx < 0 {
+				return nil, fmt.Errorf("%v: file name must be <service account>/<key id>.pem or <service account>_<key id>.pem", file)
+			}
+			sa, keyID = strings.ToLower(name[:idx]), name[idx+1:]
+		}
+		if !SERVICE_ACCOUNT_EMAIL.MatchString(sa) {
+			return nil, fmt.Errorf("%v: %q is not a service account email", file, sa)
+		}
+
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("error reading %v: %v", file, err)
+		}
+		cert, err := parseCertificatePEM(bytes.TrimSpace(data))
+		if err != nil {
+			return nil, fmt.Errorf("%v: %v", file, err)
+		}
+
+		if offlineCerts[sa] == nil {
+			offlineCerts[sa] = ServiceAccountCerts{}
+			serviceAccountIDs = append(serviceAccountIDs, sa)
+		}
+		offlineCerts[sa][keyID] = cert
+	}
+	slog.Info("Read certificates", "dir", dir, "certificates", len(files), "serviceAccounts", len(serviceAccountIDs))
+	return serviceAccountIDs, nil
+}
+
+// Returns nil if the directory has no index.json
+func readIndex(dir string) (map[string]IndexEntry, error) {
+	b, err := os.ReadFile(filepath.Join(dir, "index.json"))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading index: %v", err)
+	}
+	var index map[string]IndexEntry
+	if err := json.Unmarshal(b, &index); err != nil {
+		return nil, fmt.Errorf("error parsing %v: %v", filepath.Join(dir, "index.json"), err)
+	}
+	return index, nil
+}
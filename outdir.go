@@ -0,0 +1,181 @@
+package main
+
+import (
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// --out-format values
+const (
+	OUT_FORMAT_PEM  = "pem"  // PEM encoded certificates
+	OUT_FORMAT_DER  = "der"  // DER encoded certificates
+	OUT_FORMAT_PKIX = "pkix" // PEM encoded SubjectPublicKeyInfo, ie. just the public keys
+)
+
+// Writes the certificates of each batch to --out-dir as it is fetched, and the files covering the whole run at the end
+type OutDir struct {
+	dir    string
+	format string
+	jwks   bool
+	// the keys of every service account so far, for jwks.json
+	allJWKs []jwk
+	// file name -> what is in it, for index.json
+	index map[string]IndexEntry
+}
+
+// An entry of index.json, so consumers of the directory don't have to parse the file names
+type IndexEntry struct {
+	ServiceAccount string    `json:"serviceAccount"`
+	KeyID          string    `json:"keyId"`
+	KeyKind        string    `json:"keyKind"`
+	NotBefore      time.Time `json:"notBefore"`
+	NotAfter       time.Time `json:"notAfter"`
+}
+
+func NewOutDir(dir string, format string, jwks bool) (*OutDir, error) {
+	if format != OUT_FORMAT_PEM && format != OUT_FORMAT_DER && format != OUT_FORMAT_PKIX {
+		return nil, fmt.Errorf("invalid --out-format %v, must be %v, %v or %v", format, OUT_FORMAT_PEM, OUT_FORMAT_DER, OUT_FORMAT_PKIX)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("error creating directory %v: %v", dir, err)
+	}
+	return &OutDir{dir: dir, format: format, jwks: jwks, index: map[string]IndexEntry{}}, nil
+}
+
+// Each service account gets its own subdirectory, <sa>/<keyid>.pem, so large scans don't make one huge directory
+func (o *OutDir) write(k *KeyCollection) error {
+	for i, sa := range k.serviceAccountIDs {
+		if k.isBadSA(sa) {
+			continue
+		}
+		saDir := safeFileName(sa)
+		if err := os.MkdirAll(filepath.Join(o.dir, saDir), 0755); err != nil {
+			return fmt.Errorf("error creating directory %v: %v", filepath.Join(o.dir, saDir), err)
+		}
+		var saJWKs []jwk
+		for keyID, cert := range k.observedKeys[i] {
+			ext, data := o.encode(cert)
+			// the index has the real key ID, in case it had to be changed to be a safe file name
+			name := saDir + "/" + safeFileName(keyID) + ext
+			fname := filepath.Join(o.dir, filepath.FromSlash(name))
+			if err := os.WriteFile(fname, data, 0644); err != nil {
+				return fmt.Errorf("error writing file %v: %v", fname, err)
+			}
+			key := NewSAKey(sa, keyID, cert)
+			o.index[name] = IndexEntry{
+				ServiceAccount: sa,
+				KeyID:          keyID,
+				KeyKind:        key.determineKeyKind(),
+				NotBefore:      cert.NotBefore,
+				NotAfter:       cert.NotAfter,
+			}
+
+			publicKey, ok := cert.PublicKey.(*rsa.PublicKey)
+			if o.jwks && !ok {
+				slog.Warn("Key is not an RSA key, not writing it to the JWKS", "serviceAccount", sa, "keyId", keyID)
+			} else if o.jwks {
+				saJWKs = append(saJWKs, rsaJWK(keyID, publicKey))
+			}
+		}
+		if o.jwks {
+			if err := writeJWKS(filepath.Join(o.dir, saDir, "jwks.json"), saJWKs); err != nil {
+				return err
+			}
+			o.allJWKs = append(o.allJWKs, saJWKs...)
+		}
+	}
+	return nil
+}
+
+// Replaces anything but letters, digits and ._-+@ so the key IDs and emails returned by the endpoint can't escape
+// the directory or make names the filesystem rejects. A name which had to be changed gets a short hash of the
+// original, so different names never end up in the same file
+func safeFileName(s string) string {
+	res := []rune(s)
+	for i, r := range res {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("._-+@", r)) {
+			res[i] = '_'
+		}
+	}
+	name := string(res)
+	if name == "" || strings.Trim(name, ".") == "" {
+		name = "_" + name
+	}
+	if name != s {
+		sum := sha256.Sum256([]byte(s))
+		name += "-" + hex.EncodeToString(sum[:])[:8]
+	}
+	return name
+}
+
+// Returns the file extension and contents for the certificate in the --out-format
+func (o *OutDir) encode(cert *x509.Certificate) (string, []byte) {
+	switch o.format {
+	case OUT_FORMAT_DER:
+		return ".der", cert.Raw
+	case OUT_FORMAT_PKIX:
+		// RawSubjectPublicKeyInfo is exactly the DER encoded SubjectPublicKeyInfo from the certificate
+		return ".pub", pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: cert.RawSubjectPublicKeyInfo})
+	default:
+		return ".pem", pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+	}
+}
+
+// Called once every batch has been written
+func (o *OutDir) close() error {
+	if o.jwks {
+		if err := writeJWKS(filepath.Join(o.dir, "jwks.json"), o.allJWKs); err != nil {
+			return err
+		}
+	}
+	// map keys are marshalled in order, so the index is stable between runs
+	data, err := json.MarshalIndent(o.index, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding index: %v", err)
+	}
+	path := filepath.Join(o.dir, "index.json")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("error writing file %v: %v", path, err)
+	}
+	return nil
+}
+
+// In the same form as the JWK endpoint publishes them
+func rsaJWK(keyID string, publicKey *rsa.PublicKey) jwk {
+	return jwk{
+		Kty: "RSA",
+		Alg: "RS256",
+		Use: "sig",
+		Kid: keyID,
+		N:   base64.RawURLEncoding.EncodeToString(publicKey.N.Bytes()),
+		E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(publicKey.E)).Bytes()),
+	}
+}
+
+func writeJWKS(path string, keys []jwk) error {
+	if keys == nil {
+		keys = []jwk{}
+	}
+	data, err := json.MarshalIndent(struct {
+		Keys []jwk `json:"keys"`
+	}{keys}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding JWKS: %v", err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("error writing file %v: %v", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/open-policy-agent/opa/v1/rego"
+)
+
+// A Rego policy which decides whether each key passes, and how severe it is if it doesn't
+// The policy must be in the sa_key_checker package, and gets a KeyReport as its input. It can define:
+//   - fail: a bool, if undefined the built in decision is used
+//   - severity: a string, which is reported alongside the decision
+type Policy struct {
+	query rego.PreparedEvalQuery
+}
+
+type PolicyDecision struct {
+	// false if the policy didn't define a fail rule for this key
+	decided  bool
+	fail     bool
+	severity string
+}
+
+func loadPolicy(ctx context.Context, path string) (*Policy, error) {
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading policy %v: %v", path, err)
+	}
+
+	query, err := rego.New(
+		rego.Query("data.sa_key_checker"),
+		rego.Module(path, string(src)),
+	).PrepareForEval(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error compiling policy %v: %v", path, err)
+	}
+	return &Policy{query: query}, nil
+}
+
+func (p *Policy) evaluate(ctx context.Context, report KeyReport) (*PolicyDecision, error) {
+	// round trip through JSON so the policy sees the same field names as the JSON output
+	b, err := json.Marshal(report)
+	if err != nil {
+		return nil, err
+	}
+	var input any
+	if err := json.Unmarshal(b, &input); err != nil {
+		return nil, err
+	}
+
+	results, err := p.query.Eval(ctx, rego.EvalInput(input))
+	if err != nil {
+		return nil, fmt.Errorf("error evaluating policy for key %v of %v: %v", report.KeyID, report.ServiceAccount, err)
+	}
+
+	decision := &PolicyDecision{}
+	if len(results) == 0 || len(results[0].Expressions) == 0 {
+		return decision, nil
+	}
+	doc, ok := results[0].Expressions[0].Value.(map[string]any)
+	if !ok {
+		return decision, nil
+	}
+	if fail, ok := doc["fail"].(bool); ok {
+		decision.decided = true
+		decision.fail = fail
+	}
+	if severity, ok := doc["severity"].(string); ok {
+		decision.severity = severity
+	}
+	return decision, nil
+}
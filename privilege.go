@@ -0,0 +1,197 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+
+	asset "cloud.google.com/go/asset/apiv1"
+	"cloud.google.com/go/asset/apiv1/assetpb"
+)
+
+// How much the roles of a service account allow, so the keys of the most privileged service accounts can be fixed first
+const (
+	// roles/owner or roles/editor, which allow almost anything in the project
+	PRIVILEGE_BASIC = "BASIC"
+	// admin roles, and the roles which allow impersonating other service accounts
+	PRIVILEGE_ADMIN = "ADMIN"
+	// any other role, including custom roles, whose permissions aren't looked up
+	PRIVILEGE_SCOPED = "SCOPED"
+	// no roles were found
+	PRIVILEGE_NONE = "NONE"
+	// no roles were found, but the IAM policy search which would have found them failed
+	PRIVILEGE_UNKNOWN = "UNKNOWN"
+)
+
+// lowest first, the service accounts whose roles are unknown could have any, so they come before the ones known to
+// only have scoped roles
+var privilegeOrder = []string{
+	PRIVILEGE_NONE,
+	PRIVILEGE_SCOPED,
+	PRIVILEGE_UNKNOWN,
+	PRIVILEGE_ADMIN,
+	PRIVILEGE_BASIC,
+}
+
+// A service account granted these (on the project, or on other service accounts) can act as the other service
+// accounts, so it has their roles too
+var impersonationRoles = []string{
+	"roles/iam.serviceAccountTokenCreator",
+	"roles/iam.serviceAccountUser",
+	"roles/iam.workloadIdentityUser",
+}
+
+type RoleBinding struct {
+	Role string `json:"role"`
+	// the full resource name the role is granted on, like //cloudresourcemanager.googleapis.com/projects/p
+	Resource string `json:"resource"`
+}
+
+type PrivilegeReport struct {
+	Level string        `json:"level"` // one of the PRIVILEGE_ constants
+	Roles []RoleBinding `json:"roles"`
+	// an IAM policy search which could have found more roles failed
+	Incomplete bool `json:"incomplete,omitempty"`
+}
+
+func rolePrivilege(role string) string {
+	switch {
+	case role == "roles/owner" || role == "roles/editor":
+		return PRIVILEGE_BASIC
+	case strings.HasPrefix(role, "roles/") && strings.Contains(strings.ToLower(role), "admin"), slices.Contains(impersonationRoles, role):
+		return PRIVILEGE_ADMIN
+	}
+	return PRIVILEGE_SCOPED
+}
+
+// The level is the one of the most privileged role, or unknown if the search failed and none were found
+func privilegeReport(roles []RoleBinding, searchFailed bool) *PrivilegeReport {
+	level := PRIVILEGE_NONE
+	if searchFailed && len(roles) == 0 {
+		level = PRIVILEGE_UNKNOWN
+	}
+	for _, binding := range roles {
+		if p := rolePrivilege(binding.Role); slices.Index(privilegeOrder, p) > slices.Index(privilegeOrder, level) {
+			level = p
+		}
+	}
+	if roles == nil {
+		roles = []RoleBinding{}
+	}
+	return &PrivilegeReport{Level: level, Roles: roles, Incomplete: searchFailed}
+}
+
+func (p *PrivilegeReport) print() {
+	switch {
+	case p.Level == PRIVILEGE_UNKNOWN:
+		fmt.Printf("  Privilege: %v (the IAM policy search failed)\n", p.Level)
+	case p.Incomplete:
+		fmt.Printf("  Privilege: %v (an IAM policy search failed, so there may be more roles)\n", p.Level)
+	default:
+		fmt.Printf("  Privilege: %v\n", p.Level)
+	}
+	for _, binding := range p.Roles {
+		fmt.Printf("    %v on %v\n", binding.Role, binding.Resource)
+	}
+}
+
+// Finds the roles of every service account granted anything under the scope with one IAM policy search
+// Returns a map of service account email to its roles
+func getServiceAccountRolesViaAssetInventory(ctx context.Context, c *asset.Client, scope string) (map[string][]RoleBinding, error) {
+	res := map[string][]RoleBinding{}
+	for policy, err := range c.SearchAllIamPolicies(ctx, &assetpb.SearchAllIamPoliciesRequest{
+		Scope:    scope,
+		Query:    "memberTypes:serviceAccount",
+		PageSize: 500, // max
+	}).All() {
+		if err != nil {
+			return nil, err
+		}
+		for _, binding := range policy.GetPolicy().GetBindings() {
+			for _, member := range binding.Members {
+				// deleted service accounts are deleted:serviceAccount:EMAIL?uid=ID, and can't have keys
+				email, ok := strings.CutPrefix(member, "serviceAccount:")
+				if !ok {
+					continue
+				}
+				email = strings.ToLower(email)
+				res[email] = append(res[email], RoleBinding{Role: binding.Role, Resource: policy.Resource})
+			}
+		}
+	}
+	return res, nil
+}
+
+// Looks up the roles of the service accounts with one IAM policy search per --scope, or per project of the service
+// accounts if there are no scopes, in which case only the roles granted in their own project are found
+func (k *KeyCollection) FetchRoles(ctx context.Context) error {
+	c, err := asset.NewClient(ctx, grpcClientOptions()...)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	if k.roles == nil {
+		k.roles = map[string][]RoleBinding{}
+	}
+	for _, scope := range k.assetScopes("role lookup") {
+		roles, err := getServiceAccountRolesViaAssetInventory(ctx, c, scope)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		// the roles are informational, so the keys are still reported without them
+		if err != nil {
+			slog.Warn("Error searching the IAM policies", "scope", scope, "error", err)
+			k.failedScopes["role lookup/"+scope] = true
+			continue
+		}
+		for sa, bindings := range roles {
+			k.roles[sa] = append(k.roles[sa], bindings...)
+		}
+	}
+	return nil
+}
+
+// A bad key in the --rank-by-privilege list
+type RankedKeyReport struct {
+	ServiceAccount string `json:"serviceAccount"`
+	KeyID          string `json:"keyId"`
+	KeyKind        string `json:"keyKind"`
+	Severity       string `json:"severity"`
+	Privilege      string `json:"privilege"`
+	Roles          int    `json:"roles"`
+	// only with --workloads
+	Workloads int `json:"workloads,omitempty"`
+}
+
+// The bad keys, the ones of the most privileged service accounts first, then by severity, then by how many workloads
+// run as the service account, then by how many roles it has
+func (s *Scan) ranking() []RankedKeyReport {
+	res := slices.Clone(s.ranked)
+	slices.SortStableFunc(res, func(a, b RankedKeyReport) int {
+		return cmp.Or(
+			cmp.Compare(slices.Index(privilegeOrder, b.Privilege), slices.Index(privilegeOrder, a.Privilege)),
+			cmp.Compare(slices.Index(severityOrder, b.Severity), slices.Index(severityOrder, a.Severity)),
+			cmp.Compare(b.Workloads, a.Workloads),
+			cmp.Compare(b.Roles, a.Roles),
+		)
+	})
+	return res
+}
+
+func printRanking(ranking []RankedKeyReport) {
+	if len(ranking) == 0 {
+		return
+	}
+	fmt.Println("Bad keys by privilege of their service account:")
+	for i, key := range ranking {
+		workloads := ""
+		if key.Workloads > 0 {
+			workloads = fmt.Sprintf(", used by %d workloads", key.Workloads)
+		}
+		fmt.Printf("  %d. %v key %v: %v, %v, %v privilege with %d roles%v\n", i+1, key.ServiceAccount, key.KeyID, key.KeyKind, colorize(severityColor(key.Severity), key.Severity), key.Privilege, key.Roles, workloads)
+	}
+}
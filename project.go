@@ -0,0 +1,292 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+	"sync"
+
+	"google.golang.org/api/cloudresourcemanager/v3"
+)
+
+// Many service accounts share a project, so project (and folder) lookups are cached for the whole run
+type ProjectCache struct {
+	lock     sync.Mutex
+	projects map[string]*cloudresourcemanager.Project
+	folders  map[string]*cloudresourcemanager.Folder
+	// keyed by project, or folder resource name
+	errors map[string]error
+}
+
+var projectCache = &ProjectCache{
+	projects: map[string]*cloudresourcemanager.Project{},
+	folders:  map[string]*cloudresourcemanager.Folder{},
+	errors:   map[string]error{},
+}
+
+// project can be a project ID or number
+func (c *ProjectCache) get(ctx context.Context, project string) (*cloudresourcemanager.Project, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if p, ok := c.projects[project]; ok {
+		return p, nil
+	}
+	if err, ok := c.errors[project]; ok {
+		return nil, err
+	}
+
+	p, err := resourceManagerService().Projects.Get("projects/" + project).Context(ctx).Do()
+	if err != nil {
+		c.errors[project] = err
+		return nil, err
+	}
+	c.projects[project] = p
+	redactor.addProject(p.ProjectId)
+	redactor.addProject(strings.TrimPrefix(p.Name, "projects/"))
+	return p, nil
+}
+
+// name is the folder resource name, like folders/123
+func (c *ProjectCache) getFolder(ctx context.Context, name string) (*cloudresourcemanager.Folder, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if f, ok := c.folders[name]; ok {
+		return f, nil
+	}
+	if err, ok := c.errors[name]; ok {
+		return nil, err
+	}
+
+	f, err := resourceManagerService().Folders.Get(name).Context(ctx).Do()
+	if err != nil {
+		c.errors[name] = err
+		return nil, err
+	}
+	c.folders[name] = f
+	return f, nil
+}
+
+// Looks up the metadata of a project for the report, including the display names of the folders above it
+// If a folder can't be looked up the path is cut short there, with a warning
+func getProjectReport(ctx context.Context, project string) (*ProjectReport, error) {
+	p, err := projectCache.get(ctx, project)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &ProjectReport{
+		ProjectID:     p.ProjectId,
+		ProjectNumber: strings.TrimPrefix(p.Name, "projects/"),
+		DisplayName:   p.DisplayName,
+		Labels:        p.Labels,
+		Parent:        p.Parent,
+	}
+	parent := p.Parent
+	for strings.HasPrefix(parent, "folders/") {
+		f, err := projectCache.getFolder(ctx, parent)
+		if err != nil {
+			slog.Warn("Error getting folder, the folder path of the project will be incomplete", "folder", parent, "project", project, "error", err)
+			break
+		}
+		res.FolderPath = append([]string{f.DisplayName}, res.FolderPath...)
+		parent = f.Parent
+	}
+	return res, nil
+}
+
+// Adds a project found some other way (like listing a folder), under both its ID and number
+func (c *ProjectCache) add(p *cloudresourcemanager.Project) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.projects[p.ProjectId] = p
+	c.projects[strings.TrimPrefix(p.Name, "projects/")] = p
+	redactor.addProject(p.ProjectId)
+	redactor.addProject(strings.TrimPrefix(p.Name, "projects/"))
+}
+
+// Recursively lists the IDs of the active projects under a folder or organization, like folders/123
+func getProjectsUnder(ctx context.Context, svc *cloudresourcemanager.Service, parent string) ([]string, error) {
+	var res []string
+	err := svc.Projects.List().Parent(parent).Pages(ctx, func(page *cloudresourcemanager.ListProjectsResponse) error {
+		for _, p := range page.Projects {
+			if p.State != "ACTIVE" {
+				continue
+			}
+			projectCache.add(p)
+			res = append(res, p.ProjectId)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to list projects in %v: %v", parent, err)
+	}
+
+	// deleted folders are not listed by default
+	var folders []string
+	err = svc.Folders.List().Parent(parent).Pages(ctx, func(page *cloudresourcemanager.ListFoldersResponse) error {
+		for _, f := range page.Folders {
+			folders = append(folders, f.Name)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to list folders in %v: %v", parent, err)
+	}
+
+	for _, folder := range folders {
+		projects, err := getProjectsUnder(ctx, svc, folder)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, projects...)
+	}
+	return res, nil
+}
+
+// Returns the project the user is working in, and where it came from. This is the GOOGLE_CLOUD_PROJECT
+// (or CLOUDSDK_CORE_PROJECT) environment variable if set, or else the project in the active gcloud configuration
+func defaultProject() (string, string, error) {
+	for _, env := range []string{"GOOGLE_CLOUD_PROJECT", "CLOUDSDK_CORE_PROJECT"} {
+		if project := os.Getenv(env); project != "" {
+			return project, env, nil
+		}
+	}
+
+	out, err := exec.Command("gcloud", "config", "get-value", "project").Output()
+	if err != nil {
+		return "", "", fmt.Errorf("unable to get the gcloud project: %v", err)
+	}
+	project := strings.TrimSpace(string(out))
+	if project == "" {
+		return "", "", fmt.Errorf("no project is set in the gcloud configuration")
+	}
+	return project, "gcloud config", nil
+}
+
+// A filter on project labels, one of:
+//   - key=value: the label is set to value
+//   - key!=value: the label is not set to value
+//   - key: the label is set to anything
+//   - !key: the label isn't set
+type LabelFilter struct {
+	key    string
+	value  string
+	negate bool
+	// if false, only the presence of the key is checked
+	hasValue bool
+}
+
+func parseLabelFilter(s string) (LabelFilter, error) {
+	var f LabelFilter
+	if key, value, found := strings.Cut(s, "!="); found {
+		f = LabelFilter{key: key, value: value, negate: true, hasValue: true}
+	} else if key, value, found := strings.Cut(s, "="); found {
+		f = LabelFilter{key: key, value: value, hasValue: true}
+	} else if key, found := strings.CutPrefix(s, "!"); found {
+		f = LabelFilter{key: key, negate: true}
+	} else {
+		f = LabelFilter{key: s}
+	}
+	if f.key == "" {
+		return f, fmt.Errorf("invalid label filter %q, must be like key=value, key!=value, key or !key", s)
+	}
+	return f, nil
+}
+
+func (f LabelFilter) matches(labels map[string]string) bool {
+	value, ok := labels[f.key]
+	var res bool
+	if f.hasValue {
+		res = ok && value == f.value
+	} else {
+		res = ok
+	}
+	return res != f.negate
+}
+
+// Removes service accounts whose project matches any of the filters
+// If the project of a service account can't be determined it is kept, with a warning
+func excludeByProjectLabels(ctx context.Context, serviceAccountIDs []string, filters []LabelFilter) []string {
+	var res []string
+	excluded := 0
+	for _, sa := range serviceAccountIDs {
+		project := projectFromServiceAccount(sa)
+		if project == "" {
+			slog.Warn("Unable to determine project, not filtering it by project labels", "serviceAccount", sa)
+			res = append(res, sa)
+			continue
+		}
+		p, err := projectCache.get(ctx, project)
+		if err != nil {
+			slog.Warn("Error getting project, not filtering by project labels", "project", project, "serviceAccount", sa, "error", err)
+			res = append(res, sa)
+			continue
+		}
+
+		matched := false
+		for _, f := range filters {
+			if f.matches(p.Labels) {
+				matched = true
+				break
+			}
+		}
+		if matched {
+			excluded++
+		} else {
+			res = append(res, sa)
+		}
+	}
+
+	if excluded > 0 {
+		slog.Info("Excluded service accounts in projects matching --exclude-project-label", "count", excluded)
+	}
+	return res
+}
+
+// Removes service accounts whose project ID or number matches any of the patterns
+// Service agents and default compute service accounts only have the project number in their email, and the others
+// only the project ID, so the project is looked up to match on the other one as well. The projects with an ID are
+// only looked up if a pattern could match a number, so patterns like sandbox-* don't need any lookups
+func excludeProjects(ctx context.Context, serviceAccountIDs []string, patterns []Pattern) []string {
+	numberPatterns := slices.ContainsFunc(patterns, func(p Pattern) bool {
+		return p.re != nil || strings.Trim(p.raw, "0123456789*?[]-^") == ""
+	})
+	var res []string
+	excluded := 0
+	for _, sa := range serviceAccountIDs {
+		project := projectFromServiceAccount(sa)
+		matched := project != "" && matchAny(patterns, project)
+		isNumber := PROJECT_NUMBER.MatchString(project)
+		if !matched && project != "" && (isNumber || numberPatterns) {
+			p, err := projectCache.get(ctx, project)
+			switch {
+			case err != nil && isNumber:
+				slog.Warn("Error getting project, only matching against --exclude-project by project number", "project", project, "serviceAccount", sa, "error", err)
+			case err != nil:
+				slog.Warn("Error getting project, only matching against --exclude-project by project ID", "project", project, "serviceAccount", sa, "error", err)
+			case isNumber:
+				matched = matchAny(patterns, p.ProjectId)
+			default:
+				// the name of a project is projects/NUMBER
+				matched = matchAny(patterns, strings.TrimPrefix(p.Name, "projects/"))
+			}
+		}
+		if matched {
+			excluded++
+		} else {
+			res = append(res, sa)
+		}
+	}
+
+	if excluded > 0 {
+		slog.Info("Excluded service accounts in projects matching --exclude-project", "count", excluded)
+	}
+	return res
+}
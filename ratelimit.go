@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// A rate limiter which halves its rate whenever a request is rate limited, and slowly recovers towards the
+// maximum as requests succeed, so scans in quota constrained environments slow down instead of erroring
+type AdaptiveLimiter struct {
+	limiter *rate.Limiter
+	max     rate.Limit
+	lock    sync.Mutex
+	// set from Retry-After, no requests are made until then
+	pausedUntil time.Time
+}
+
+func NewAdaptiveLimiter(requestsPerMinute int) *AdaptiveLimiter {
+	limit := rate.Limit(float64(requestsPerMinute) / 60.0)
+	return &AdaptiveLimiter{
+		limiter: rate.NewLimiter(limit, 1),
+		max:     limit,
+	}
+}
+
+func (l *AdaptiveLimiter) Wait(ctx context.Context) error {
+	l.lock.Lock()
+	pause := time.Until(l.pausedUntil)
+	l.lock.Unlock()
+	if pause > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(pause):
+		}
+	}
+	return l.limiter.Wait(ctx)
+}
+
+// Feeds the result of a request back into the limiter
+func (l *AdaptiveLimiter) observe(err error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	current := l.limiter.Limit()
+	if isRateLimited(err) {
+		l.limiter.SetLimit(max(current/2, l.max*AdaptiveLimiterMinFraction))
+		if retryAfter := retryAfter(err); retryAfter > 0 {
+			l.pausedUntil = time.Now().Add(retryAfter)
+		}
+	} else if err == nil && current < l.max {
+		l.limiter.SetLimit(min(current+l.max*AdaptiveLimiterRecoveryFraction, l.max))
+	}
+}
+
+// IAM read quotas are per consumer project, so when requests are billed to the project of each service account
+// there is a separate limiter for each project
+type ProjectLimiters struct {
+	lock              sync.Mutex
+	limiters          map[string]*AdaptiveLimiter
+	requestsPerMinute int
+}
+
+func NewProjectLimiters(requestsPerMinute int) *ProjectLimiters {
+	return &ProjectLimiters{
+		limiters:          map[string]*AdaptiveLimiter{},
+		requestsPerMinute: requestsPerMinute,
+	}
+}
+
+// project is the consumer project, or empty for the default one
+func (p *ProjectLimiters) get(project string) *AdaptiveLimiter {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	l, ok := p.limiters[project]
+	if !ok {
+		l = NewAdaptiveLimiter(p.requestsPerMinute)
+		p.limiters[project] = l
+	}
+	return l
+}
+
+// Calls f when the limiter allows it, feeding the result back into the limiter and retrying transient
+// errors (including being rate limited) with backoff
+func limitedCall[T any](ctx context.Context, l *AdaptiveLimiter, retries int, f func() (T, error)) (T, error) {
+	return withRetries(ctx, retries, func() (T, error) {
+		if err := l.Wait(ctx); err != nil {
+			var zero T
+			return zero, err
+		}
+		res, err := f()
+		l.observe(err)
+		return res, err
+	})
+}
+
+func isRateLimited(err error) bool {
+	if err == nil {
+		return false
+	}
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) {
+		return apiErr.Code == http.StatusTooManyRequests || strings.Contains(apiErr.Message, "RESOURCE_EXHAUSTED")
+	}
+	if s, ok := status.FromError(err); ok {
+		return s.Code() == codes.ResourceExhausted
+	}
+	return false
+}
+
+// Only the delay-seconds form of Retry-After is used by Google APIs
+func retryAfter(err error) time.Duration {
+	var apiErr *googleapi.Error
+	if !errors.As(err, &apiErr) || apiErr.Header == nil {
+		return 0
+	}
+	seconds, parseErr := strconv.Atoi(apiErr.Header.Get("Retry-After"))
+	if parseErr != nil || seconds <= 0 {
+		return 0
+	}
+	return time.Duration(seconds) * time.Second
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// A response captured by --record, named after a hash of the request so --replay can find it again
+type RecordedResponse struct {
+	Method string      `json:"method"`
+	URL    string      `json:"url"`
+	Status int         `json:"status"`
+	Header http.Header `json:"header"`
+	Body   string      `json:"body"`
+}
+
+var errNotRecorded = errors.New("no recorded response")
+
+// The time of the recorded scan, which the audit log window and the findings are relative to
+// so that --replay sends the same requests and reports the same findings as the recorded run
+const RECORDED_SCAN_FILE = "scan.json"
+
+type RecordedScan struct {
+	Now time.Time `json:"now"`
+}
+
+func saveRecordedScan(dir string, now time.Time) error {
+	data, err := json.MarshalIndent(RecordedScan{Now: now.UTC()}, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(filepath.Join(dir, RECORDED_SCAN_FILE), data); err != nil {
+		return fmt.Errorf("error recording scan time: %v", err)
+	}
+	return nil
+}
+
+// Recordings made before the scan time was saved are replayed at the current time
+func loadRecordedScan(dir string) (time.Time, error) {
+	b, err := os.ReadFile(filepath.Join(dir, RECORDED_SCAN_FILE))
+	if errors.Is(err, os.ErrNotExist) {
+		slog.Warn("The recording has no scan time, replaying it at the current time", "dir", dir)
+		return time.Now(), nil
+	}
+	if err != nil {
+		return time.Time{}, fmt.Errorf("error reading recorded scan time: %v", err)
+	}
+	var recorded RecordedScan
+	if err := json.Unmarshal(b, &recorded); err != nil {
+		return time.Time{}, fmt.Errorf("error parsing recorded scan time: %v", err)
+	}
+	return recorded.Now, nil
+}
+
+// The request body is part of the name, for APIs like entries.list which POST their parameters
+func recordedResponsePath(dir string, req *http.Request, body []byte) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%v %v\n", req.Method, req.URL.String())
+	h.Write(body)
+	return filepath.Join(dir, hex.EncodeToString(h.Sum(nil))[:32]+".json")
+}
+
+func readRequestBody(req *http.Request) ([]byte, error) {
+	if req.Body == nil {
+		return nil, nil
+	}
+	body, err := io.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	return body, nil
+}
+
+// Saves every response to dir, the credentials are only in the requests so they are never saved
+type recordingTransport struct {
+	dir  string
+	base http.RoundTripper
+}
+
+func (t *recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	body, err := readRequestBody(req)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	respBody, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(respBody))
+
+	header := resp.Header.Clone()
+	header.Del("Set-Cookie")
+	data, err := json.MarshalIndent(RecordedResponse{
+		Method: req.Method,
+		URL:    req.URL.String(),
+		Status: resp.StatusCode,
+		Header: header,
+		Body:   string(respBody),
+	}, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := writeFileAtomic(recordedResponsePath(t.dir, req, body), data); err != nil {
+		return nil, fmt.Errorf("error recording response: %v", err)
+	}
+	return resp, nil
+}
+
+// Answers every request from the responses saved by a recordingTransport, without making any requests
+type replayTransport struct {
+	dir string
+}
+
+func (t *replayTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	body, err := readRequestBody(req)
+	if err != nil {
+		return nil, err
+	}
+	b, err := os.ReadFile(recordedResponsePath(t.dir, req, body))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w for %v %v", errNotRecorded, req.Method, req.URL)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var recorded RecordedResponse
+	if err := json.Unmarshal(b, &recorded); err != nil {
+		return nil, fmt.Errorf("error parsing recorded response for %v %v: %v", req.Method, req.URL, err)
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %v", recorded.Status, http.StatusText(recorded.Status)),
+		StatusCode:    recorded.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        recorded.Header,
+		Body:          io.NopCloser(bytes.NewReader([]byte(recorded.Body))),
+		ContentLength: int64(len(recorded.Body)),
+		Request:       req,
+	}, nil
+}
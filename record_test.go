@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/time/rate"
+	"google.golang.org/api/logging/v2"
+	"google.golang.org/api/option"
+)
+
+const testAuditLogResponse = `{
+  "entries": [
+    {
+      "insertId": "1",
+      "timestamp": "2024-05-01T10:00:00Z",
+      "protoPayload": {
+        "authenticationInfo": {"serviceAccountKeyName": "//iam.googleapis.com/projects/p/serviceAccounts/a@p.iam.gserviceaccount.com/keys/abc"},
+        "requestMetadata": {"callerIp": "192.0.2.1"}
+      }
+    }
+  ]
+}`
+
+func testLoggingService(t *testing.T, endpoint string, transport http.RoundTripper) *logging.Service {
+	t.Helper()
+	service, err := logging.NewService(context.Background(),
+		option.WithEndpoint(endpoint),
+		option.WithHTTPClient(&http.Client{Transport: transport}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return service
+}
+
+func TestReplayAuditLogs(t *testing.T) {
+	dir := t.TempDir()
+	var filters []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req logging.ListLogEntriesRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("error decoding request: %v", err)
+		}
+		filters = append(filters, req.Filter)
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, testAuditLogResponse)
+	}))
+	defer server.Close()
+
+	// the recorded run saves its scan time, the replay starts the audit log window from it
+	recordedAt := time.Date(2024, 5, 2, 12, 0, 0, 0, time.UTC)
+	if err := saveRecordedScan(dir, recordedAt); err != nil {
+		t.Fatal(err)
+	}
+	recorder := testLoggingService(t, server.URL, &recordingTransport{dir: dir, base: http.DefaultTransport})
+	recorded, err := getKeyUsageFromAuditLogs(context.Background(), recorder, rate.NewLimiter(rate.Inf, 1), "p", recordedAt.Add(-24*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	replayedAt, err := loadRecordedScan(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !replayedAt.Equal(recordedAt) {
+		t.Fatalf("replayed scan time is %v, recorded %v", replayedAt, recordedAt)
+	}
+	server.Close()
+	replayer := testLoggingService(t, server.URL, &replayTransport{dir: dir})
+	replayed, err := getKeyUsageFromAuditLogs(context.Background(), replayer, rate.NewLimiter(rate.Inf, 1), "p", replayedAt.Add(-24*time.Hour))
+	if err != nil {
+		t.Fatalf("replaying audit logs: %v", err)
+	}
+	if len(filters) != 1 {
+		t.Fatalf("expected 1 request to the server, got %d", len(filters))
+	}
+	if !strings.Contains(filters[0], `timestamp>="2024-05-01T12:00:00Z"`) {
+		t.Errorf("filter doesn't start the window from the scan time: %v", filters[0])
+	}
+
+	for _, res := range []map[string]*KeyUsage{recorded, replayed} {
+		usage := res["abc"]
+		if usage == nil || usage.count != 1 || len(usage.callerIPs) != 1 || usage.callerIPs[0] != "192.0.2.1" {
+			t.Fatalf("unexpected key usage %+v", res)
+		}
+	}
+
+	// a different window is a different request, which wasn't recorded
+	_, err = getKeyUsageFromAuditLogs(context.Background(), replayer, rate.NewLimiter(rate.Inf, 1), "p", time.Now().Add(-24*time.Hour))
+	if !errors.Is(err, errNotRecorded) {
+		t.Errorf("expected %v for a request that wasn't recorded, got %v", errNotRecorded, err)
+	}
+}
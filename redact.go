@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// nil unless --redact
+var redactor *Redactor
+
+// Anything which can be part of an email or project ID, the output is redacted one of these at a time
+var redactToken = regexp.MustCompile(`[A-Za-z0-9._@+-]+`)
+
+// Replaces the service account emails and project IDs in the output with keyed hashes, so the output can be shared
+// without sharing the names. The emails are found by their syntax, and the projects (IDs and numbers) are the ones
+// in the emails seen so far, given with --project or --scope, or found while listing the service accounts
+type Redactor struct {
+	mu sync.Mutex
+	// the HMAC key, without it anyone could hash a list of likely names and match them to the output
+	key      []byte
+	projects map[string]bool
+	// the form of the email in the CN of the certificates of system managed keys, like
+	// name.project.iam.gserviceaccount.com
+	dottedServiceAccount *regexp.Regexp
+	// closes the pipe os.Stdout was replaced with, returning once everything printed has been written out
+	flush func()
+}
+
+// A random key is used if key is empty, so the hashes are only the same within the run
+func NewRedactor(projects []string, key []byte) *Redactor {
+	if len(key) == 0 {
+		key = make([]byte, 32)
+		rand.Read(key)
+	}
+	r := &Redactor{
+		key:                  key,
+		projects:             map[string]bool{},
+		dottedServiceAccount: regexp.MustCompile(`^([a-z0-9-]+)\.([a-z0-9-]+` + regexp.QuoteMeta(userManagedServiceAccountDomain) + `)$`),
+	}
+	for _, project := range projects {
+		r.projects[project] = true
+	}
+	return r
+}
+
+// Registers a project ID or number found some other way than in an email, like listing a folder, so it's redacted
+// in what's logged about it before any of its service accounts are seen. Does nothing without --redact
+func (r *Redactor) addProject(project string) {
+	if r == nil || project == "" {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.projects[strings.ToLower(project)] = true
+}
+
+func (r *Redactor) hash(s string) string {
+	mac := hmac.New(sha256.New, r.key)
+	mac.Write([]byte(s))
+	return hex.EncodeToString(mac.Sum(nil))[:10]
+}
+
+// Keeps the domain, so the kind of service account can still be told, except for the project in it
+func (r *Redactor) redactEmail(email string) string {
+	_, domain, _ := strings.Cut(email, "@")
+	if project := projectFromServiceAccount(email); project != "" {
+		r.projects[project] = true
+	}
+	if project, found := strings.CutSuffix(domain, userManagedServiceAccountDomain); found && !strings.HasPrefix(project, "gcp-sa-") {
+		domain = "project-" + r.hash(project) + userManagedServiceAccountDomain
+	}
+	return "sa-" + r.hash(email) + "@" + domain
+}
+
+func (r *Redactor) redactToken(token string) string {
+	// at the end of a sentence
+	token, dot := strings.CutSuffix(token, ".")
+	suffix := ""
+	if dot {
+		suffix = "."
+	}
+	lower := strings.ToLower(token)
+	switch {
+	case SERVICE_ACCOUNT_EMAIL.MatchString(lower) && (strings.HasSuffix(lower, ".gserviceaccount.com") || strings.HasSuffix(lower, userManagedServiceAccountDomain)):
+		return r.redactEmail(lower) + suffix
+	case r.dottedServiceAccount.MatchString(lower):
+		m := r.dottedServiceAccount.FindStringSubmatch(lower)
+		return strings.Replace(r.redactEmail(m[1]+"@"+m[2]), "@", ".", 1) + suffix
+	case r.projects[lower]:
+		return "project-" + r.hash(lower) + suffix
+	}
+	return token + suffix
+}
+
+// Does nothing without --redact
+func (r *Redactor) redact(s string) string {
+	if r == nil {
+		return s
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return redactToken.ReplaceAllStringFunc(s, r.redactToken)
+}
+
+func (r *Redactor) redactBytes(b []byte) []byte {
+	if r == nil {
+		return b
+	}
+	// the projects can come before the emails they are learned from, like in a --report
+	r.redact(string(b))
+	return []byte(r.redact(string(b)))
+}
+
+func isNotRedactTokenRune(c rune) bool {
+	return !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.ContainsRune("._@+-", c))
+}
+
+// Everything printed to os.Stdout goes through the redactor, up to the last character which can't be part of a name
+// each time, so partial lines like the --tui prompt are written out right away but a name is never split
+func (r *Redactor) redactStdout() error {
+	stdout := os.Stdout
+	pr, pw, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	os.Stdout = pw
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		buf := make([]byte, 32*1024)
+		var pending []byte
+		for {
+			n, err := pr.Read(buf)
+			pending = append(pending, buf[:n]...)
+			if err != nil {
+				stdout.WriteString(r.redact(string(pending)))
+				return
+			}
+			if i := bytes.LastIndexFunc(pending, isNotRedactTokenRune); i >= 0 {
+				_, size := utf8.DecodeRune(pending[i:])
+				stdout.WriteString(r.redact(string(pending[:i+size])))
+				pending = slices.Clone(pending[i+size:])
+			}
+		}
+	}()
+	r.flush = func() {
+		pw.Close()
+		<-done
+		os.Stdout = stdout
+	}
+	return nil
+}
+
+// Like os.Exit, after the redacted output has been written out
+func exit(code int) {
+	if redactor != nil && redactor.flush != nil {
+		redactor.flush()
+	}
+	os.Exit(code)
+}
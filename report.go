@@ -0,0 +1,451 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"google.golang.org/api/iam/v1"
+)
+
+// The structured form of the results for a single key
+type KeyReport struct {
+	ServiceAccount            string                `json:"serviceAccount"`
+	ServiceAccountCategory    string                `json:"serviceAccountCategory"` // one of the SA_CATEGORY_ constants
+	KeyID                     string                `json:"keyId"`
+	KeyKind                   string                `json:"keyKind"`
+	Confidence                float64               `json:"confidence"`
+	NotBefore                 time.Time             `json:"notBefore"`
+	NotAfter                  time.Time             `json:"notAfter"`
+	SPKISHA256                string                `json:"spkiSha256"`              // hex SHA-256 of the DER encoded public key
+	ModulusSHA256             string                `json:"modulusSha256,omitempty"` // hex SHA-256 of the RSA modulus
+	Signals                   []SignalReport        `json:"signals"`
+	Findings                  []FindingReport       `json:"findings"`
+	LastAuthenticated         *time.Time            `json:"lastAuthenticated,omitempty"`
+	LastAuthenticationUnknown bool                  `json:"lastAuthenticationUnknown,omitempty"` // the activity analyzer query for the project failed
+	AuditLogUsage             *UsageReport          `json:"auditLogUsage,omitempty"`
+	AuditLogUsageUnknown      bool                  `json:"auditLogUsageUnknown,omitempty"` // the audit log read for the project failed
+	Bad                       bool                  `json:"bad"`
+	Severity                  string                `json:"severity,omitempty"` // only for bad keys
+	Suppressed                *SuppressionReport    `json:"suppressed,omitempty"`
+	Attributes                map[string]string     `json:"attributes,omitempty"` // from the columns of a CSV input
+	Project                   *ProjectReport        `json:"project,omitempty"`
+	ServiceAccountMetadata    *ServiceAccountReport `json:"serviceAccountMetadata,omitempty"` // only in ground truth mode
+	IAMKey                    *IAMKeyReport         `json:"iamKey,omitempty"`                 // only in ground truth mode
+	Change                    string                `json:"change,omitempty"`                 // only with a previous --state
+	Certificate               *CertificateReport    `json:"certificate,omitempty"`            // only with --show-cert
+	Privilege                 *PrivilegeReport      `json:"privilege,omitempty"`              // only with --rank-by-privilege
+	Workloads                 []WorkloadReport      `json:"workloads,omitempty"`              // only with --workloads
+	WorkloadLookupFailed      bool                  `json:"workloadLookupFailed,omitempty"`   // the workloads may be missing some, or all
+}
+
+type ProjectReport struct {
+	ProjectID     string            `json:"projectId"`
+	ProjectNumber string            `json:"projectNumber"`
+	DisplayName   string            `json:"displayName"`
+	Labels        map[string]string `json:"labels,omitempty"`
+	Parent        string            `json:"parent"`
+	// display names of the folders the project is in, starting at the top
+	FolderPath []string `json:"folderPath,omitempty"`
+}
+
+type ServiceAccountReport struct {
+	UniqueID    string `json:"uniqueId"`
+	DisplayName string `json:"displayName,omitempty"`
+	Description string `json:"description,omitempty"`
+	Disabled    bool   `json:"disabled"`
+}
+
+// sa can be nil if the lookup failed
+func serviceAccountReport(sa *iam.ServiceAccount) *ServiceAccountReport {
+	if sa == nil {
+		return nil
+	}
+	if redactor != nil {
+		// the display name and description can say what the service account is for
+		return &ServiceAccountReport{UniqueID: sa.UniqueId, Disabled: sa.Disabled}
+	}
+	return &ServiceAccountReport{
+		UniqueID:    sa.UniqueId,
+		DisplayName: sa.DisplayName,
+		Description: sa.Description,
+		Disabled:    sa.Disabled,
+	}
+}
+
+// The key as the IAM API (or asset inventory) has it
+type IAMKeyReport struct {
+	KeyOrigin       string `json:"keyOrigin"`
+	KeyType         string `json:"keyType"`
+	KeyAlgorithm    string `json:"keyAlgorithm,omitempty"`
+	Disabled        bool   `json:"disabled"`
+	DisableReason   string `json:"disableReason,omitempty"`
+	ValidAfterTime  string `json:"validAfterTime,omitempty"`
+	ValidBeforeTime string `json:"validBeforeTime,omitempty"`
+}
+
+// key can be nil if it isn't in the ground truth
+func iamKeyReport(key *iam.ServiceAccountKey) *IAMKeyReport {
+	if key == nil {
+		return nil
+	}
+	return &IAMKeyReport{
+		KeyOrigin:       key.KeyOrigin,
+		KeyType:         key.KeyType,
+		KeyAlgorithm:    key.KeyAlgorithm,
+		Disabled:        key.Disabled,
+		DisableReason:   key.DisableReason,
+		ValidAfterTime:  key.ValidAfterTime,
+		ValidBeforeTime: key.ValidBeforeTime,
+	}
+}
+
+type SuppressionReport struct {
+	Reason  string     `json:"reason"`
+	Expires *time.Time `json:"expires,omitempty"`
+}
+
+type SignalReport struct {
+	KeyKind     string `json:"keyKind"`
+	Checker     string `json:"checker"`
+	Explanation string `json:"explanation"`
+}
+
+type FindingReport struct {
+	Category    string `json:"category"`
+	Explanation string `json:"explanation"`
+	Warning     bool   `json:"warning"`
+}
+
+type UsageReport struct {
+	Count     int        `json:"count"`
+	LastSeen  *time.Time `json:"lastSeen,omitempty"` // only when there were authentications
+	CallerIPs []string   `json:"callerIps,omitempty"`
+}
+
+// Must be called after determineKeyKind and checkFindings
+func (k *SAKey) report() KeyReport {
+	res := KeyReport{
+		ServiceAccount:            k.serviceAccount,
+		ServiceAccountCategory:    serviceAccountCategory(k.serviceAccount),
+		KeyID:                     k.keyID,
+		KeyKind:                   k.keyKind,
+		Confidence:                k.confidence,
+		NotBefore:                 k.cert.NotBefore,
+		NotAfter:                  k.cert.NotAfter,
+		SPKISHA256:                hex.EncodeToString(spkiSHA256(k.cert)),
+		ModulusSHA256:             modulusSHA256(k.cert),
+		Signals:                   []SignalReport{},
+		Findings:                  []FindingReport{},
+		LastAuthenticated:         k.lastAuthenticated,
+		LastAuthenticationUnknown: k.lastAuthenticationUnknown,
+		AuditLogUsageUnknown:      k.usageUnknown,
+		Bad:                       k.isBad(),
+		Severity:                  k.severity(),
+		Attributes:                serviceAccountAttributes[k.serviceAccount],
+		Project:                   k.project,
+		Privilege:                 k.privilege,
+		Workloads:                 k.workloads,
+		WorkloadLookupFailed:      k.workloadsFailed,
+		ServiceAccountMetadata:    k.serviceAccountMetadata,
+		IAMKey:                    k.iamKey,
+		Change:                    k.change,
+	}
+	if *showCert {
+		res.Certificate = certificateReport(k.cert)
+	}
+	for _, signal := range k.signals {
+		res.Signals = append(res.Signals, SignalReport{
+			KeyKind:     signal.keyKind,
+			Checker:     signal.checker,
+			Explanation: signal.explanation,
+		})
+	}
+	for _, finding := range k.findings {
+		res.Findings = append(res.Findings, FindingReport{
+			Category:    finding.category,
+			Explanation: finding.explanation,
+			Warning:     finding.warning,
+		})
+	}
+	if k.suppression != nil {
+		res.Suppressed = &SuppressionReport{
+			Reason: k.suppression.reason,
+		}
+		if !k.suppression.expires.IsZero() {
+			res.Suppressed.Expires = &k.suppression.expires
+		}
+	}
+	if k.usage != nil {
+		res.AuditLogUsage = &UsageReport{
+			Count:     k.usage.count,
+			CallerIPs: k.usage.callerIPs,
+		}
+		if k.usage.count > 0 {
+			res.AuditLogUsage.LastSeen = &k.usage.lastSeen
+		}
+	}
+	return res
+}
+
+// The structured results of a whole run, rolled up by project so that org wide runs can be navigated
+type Report struct {
+	Good     int             `json:"good"`
+	Bad      int             `json:"bad"`
+	Projects []*ProjectGroup `json:"projects"`
+	// only if the project metadata was looked up, as that has the folders
+	Folders []*FolderGroup `json:"folders,omitempty"`
+	Stats   *Stats         `json:"stats"`
+	// service accounts which couldn't be fetched, so aren't in the results
+	Skipped []SkippedReport `json:"skipped"`
+	// only if any RSA modulus is shared by more than one key
+	SharedModuli []SharedModulusReport `json:"sharedModuli,omitempty"`
+	// only with a previous --state
+	Changes *SnapshotDelta `json:"changes,omitempty"`
+	// service accounts which weren't scanned because the run was interrupted or hit --timeout
+	NotScanned int `json:"notScanned,omitempty"`
+	// only with --api-usage
+	APIUsage []APIUsageReport `json:"apiUsage,omitempty"`
+	// only with --rank-by-privilege
+	Ranking []RankedKeyReport `json:"ranking,omitempty"`
+}
+
+type SkippedReport struct {
+	ServiceAccount string `json:"serviceAccount"`
+	Error          string `json:"error"`
+}
+
+func printSkipped(skipped []SkippedReport) {
+	if len(skipped) == 0 {
+		return
+	}
+	fmt.Printf("Skipped %d service accounts which couldn't be fetched:\n", len(skipped))
+	for _, s := range skipped {
+		fmt.Printf("  %v: %v\n", s.ServiceAccount, s.Error)
+	}
+}
+
+type ProjectGroup struct {
+	// as in the service account emails, so either the ID or number, empty if it couldn't be determined
+	Project         string                 `json:"project"`
+	Metadata        *ProjectReport         `json:"metadata,omitempty"`
+	Good            int                    `json:"good"`
+	Bad             int                    `json:"bad"`
+	ServiceAccounts []*ServiceAccountGroup `json:"serviceAccounts"`
+}
+
+type ServiceAccountGroup struct {
+	ServiceAccount string      `json:"serviceAccount"`
+	Bad            bool        `json:"bad"`
+	Keys           []KeyReport `json:"keys"`
+	// about the service account as a whole, like having no system managed keys
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+// The counts for all the projects under a folder, including subfolders
+type FolderGroup struct {
+	// display names of the folders, joined with /
+	FolderPath string `json:"folderPath"`
+	Good       int    `json:"good"`
+	Bad        int    `json:"bad"`
+}
+
+func NewReport() *Report {
+	return &Report{
+		Projects: []*ProjectGroup{},
+	}
+}
+
+// project is nil unless the project metadata was looked up
+func (r *Report) addServiceAccount(serviceAccountID string, bad bool, keys []KeyReport, warnings []string, project *ProjectReport) {
+	name := projectFromServiceAccount(serviceAccountID)
+	idx := slices.IndexFunc(r.Projects, func(g *ProjectGroup) bool { return g.Project == name })
+	if idx < 0 {
+		r.Projects = append(r.Projects, &ProjectGroup{
+			Project:         name,
+			Metadata:        project,
+			ServiceAccounts: []*ServiceAccountGroup{},
+		})
+		idx = len(r.Projects) - 1
+	}
+	group := r.Projects[idx]
+
+	if keys == nil {
+		keys = []KeyReport{}
+	}
+	group.ServiceAccounts = append(group.ServiceAccounts, &ServiceAccountGroup{
+		ServiceAccount: serviceAccountID,
+		Bad:            bad,
+		Keys:           keys,
+		Warnings:       warnings,
+	})
+
+	folders := []string{}
+	if project != nil {
+		for i := range project.FolderPath {
+			folders = append(folders, strings.Join(project.FolderPath[:i+1], "/"))
+		}
+	}
+	for _, folderPath := range folders {
+		idx := slices.IndexFunc(r.Folders, func(g *FolderGroup) bool { return g.FolderPath == folderPath })
+		if idx < 0 {
+			r.Folders = append(r.Folders, &FolderGroup{FolderPath: folderPath})
+			idx = len(r.Folders) - 1
+		}
+		if bad {
+			r.Folders[idx].Bad++
+		} else {
+			r.Folders[idx].Good++
+		}
+	}
+
+	if bad {
+		r.Bad++
+		group.Bad++
+	} else {
+		r.Good++
+		group.Good++
+	}
+}
+
+// Only worth printing when more than one project was scanned
+func (r *Report) printSummary() {
+	if len(r.Projects) < 2 {
+		return
+	}
+	r.printProjects()
+}
+
+func (r *Report) printProjects() {
+	fmt.Println("Per project:")
+	for _, g := range r.Projects {
+		name := g.Project
+		if name == "" {
+			name = "(unknown project)"
+		}
+		fmt.Printf("  %v: good %d, bad %d\n", name, g.Good, g.Bad)
+	}
+	if len(r.Folders) > 0 {
+		fmt.Println("Per folder:")
+		for _, g := range r.Folders {
+			fmt.Printf("  %v: good %d, bad %d\n", g.FolderPath, g.Good, g.Bad)
+		}
+	}
+}
+
+// Combines reports, like the ones of the shards of a scan, recomputing the counts, folders and stats from the service
+// accounts. A service account which is in more than one report is only included once, with the results of the last
+// report it is in, and is returned. The key ages in the stats are as of now
+func mergeReports(reports []*Report, now time.Time) (*Report, []string) {
+	type entry struct {
+		group   *ServiceAccountGroup
+		project *ProjectReport
+	}
+	var order []string
+	entries := map[string]entry{}
+	var duplicates []string
+	for _, report := range reports {
+		for _, project := range report.Projects {
+			for _, group := range project.ServiceAccounts {
+				previous, ok := entries[group.ServiceAccount]
+				if ok {
+					duplicates = append(duplicates, group.ServiceAccount)
+				} else {
+					order = append(order, group.ServiceAccount)
+				}
+				metadata := project.Metadata
+				if metadata == nil {
+					metadata = previous.project
+				}
+				entries[group.ServiceAccount] = entry{group: group, project: metadata}
+			}
+		}
+	}
+
+	res := NewReport()
+	res.Stats = NewStats()
+	res.Skipped = []SkippedReport{}
+	// the metadata of a project can be in a report which only has some of its service accounts
+	projects := map[string]*ProjectReport{}
+	for _, sa := range order {
+		if e := entries[sa]; e.project != nil {
+			projects[projectFromServiceAccount(sa)] = e.project
+		}
+	}
+	for _, sa := range order {
+		e := entries[sa]
+		res.addServiceAccount(sa, e.group.Bad, e.group.Keys, e.group.Warnings, projects[projectFromServiceAccount(sa)])
+		res.Stats.addKeyReports(e.group.Keys, now)
+	}
+
+	// a service account skipped by one run may have been scanned by another, like a rerun of a shard
+	skipped := map[string]bool{}
+	for _, report := range reports {
+		for _, s := range report.Skipped {
+			if _, ok := entries[s.ServiceAccount]; !ok && !skipped[s.ServiceAccount] {
+				skipped[s.ServiceAccount] = true
+				res.Skipped = append(res.Skipped, s)
+			}
+		}
+	}
+
+	// the moduli are found again from the keys, as keys in different shards can share one too. The shared moduli of
+	// the inputs are kept for the reports without modulusSha256 in their keys
+	moduli := map[string][]KeyRef{}
+	add := func(modulus string, key KeyRef) {
+		if !slices.Contains(moduli[modulus], key) {
+			moduli[modulus] = append(moduli[modulus], key)
+		}
+	}
+	for _, report := range reports {
+		for _, shared := range report.SharedModuli {
+			for _, key := range shared.Keys {
+				add(shared.ModulusSHA256, key)
+			}
+		}
+	}
+	for _, project := range res.Projects {
+		for _, sa := range project.ServiceAccounts {
+			for _, key := range sa.Keys {
+				if key.ModulusSHA256 != "" {
+					add(key.ModulusSHA256, KeyRef{ServiceAccount: key.ServiceAccount, KeyID: key.KeyID})
+				}
+			}
+		}
+	}
+	for _, modulus := range slices.Sorted(maps.Keys(moduli)) {
+		if len(moduli[modulus]) > 1 {
+			res.SharedModuli = append(res.SharedModuli, SharedModulusReport{ModulusSHA256: modulus, Keys: moduli[modulus]})
+		}
+	}
+	slices.Sort(duplicates)
+	return res, slices.Compact(duplicates)
+}
+
+func readReport(path string) (*Report, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading report %v: %v", path, err)
+	}
+	var report Report
+	if err := json.Unmarshal(b, &report); err != nil {
+		return nil, fmt.Errorf("error parsing report %v: %v", path, err)
+	}
+	return &report, nil
+}
+
+func writeReport(path string, report *Report) error {
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, append(redactor.redactBytes(data), '\n'), 0644); err != nil {
+		return fmt.Errorf("unable to write report: %v", err)
+	}
+	return nil
+}
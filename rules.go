@@ -0,0 +1,149 @@
+package main
+
+import (
+	"crypto/rsa"
+	"crypto/x509"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/google/cel-go/cel"
+)
+
+// A user defined rule, which emits a signal for keyKind when the CEL expression evaluates to true
+// The expression can also be written as "<expression> => <key kind>", in which case keyKind can be omitted
+type RuleConfig struct {
+	Name        string `yaml:"name"`
+	Expression  string `yaml:"expression"`
+	KeyKind     string `yaml:"keyKind"`
+	Explanation string `yaml:"explanation"`
+}
+
+// ruleChecker is a Checker backed by a compiled RuleConfig
+type ruleChecker struct {
+	name        string
+	keyKind     string
+	explanation string
+	program     cel.Program
+}
+
+var celEnv = sync.OnceValues(func() (*cel.Env, error) {
+	return cel.NewEnv(
+		cel.Variable("cert", cel.MapType(cel.StringType, cel.DynType)),
+		cel.Variable("serviceAccount", cel.StringType),
+		cel.Variable("keyId", cel.StringType),
+	)
+})
+
+func newRuleChecker(rule RuleConfig) (*ruleChecker, error) {
+	expression, keyKind := rule.Expression, rule.KeyKind
+	if keyKind == "" {
+		if i := strings.LastIndex(expression, "=>"); i != -1 {
+			expression, keyKind = strings.TrimSpace(expression[:i]), strings.TrimSpace(expression[i+2:])
+		}
+	}
+
+	kind, err := parseKeyKind(keyKind)
+	if err != nil {
+		return nil, fmt.Errorf("rule %v: %v", rule.Name, err)
+	}
+
+	env, err := celEnv()
+	if err != nil {
+		return nil, err
+	}
+	ast, issues := env.Compile(expression)
+	if issues.Err() != nil {
+		return nil, fmt.Errorf("rule %v: error compiling expression: %v", rule.Name, issues.Err())
+	}
+	if ast.OutputType() != cel.BoolType && ast.OutputType() != cel.DynType {
+		return nil, fmt.Errorf("rule %v: expression must evaluate to a bool, not %v", rule.Name, ast.OutputType())
+	}
+	program, err := env.Program(ast)
+	if err != nil {
+		return nil, fmt.Errorf("rule %v: %v", rule.Name, err)
+	}
+
+	explanation := rule.Explanation
+	if explanation == "" {
+		explanation = fmt.Sprintf("Certificate matches rule %v", expression)
+	}
+
+	return &ruleChecker{
+		name:        rule.Name,
+		keyKind:     kind,
+		explanation: explanation,
+		program:     program,
+	}, nil
+}
+
+func (r *ruleChecker) Name() string {
+	return r.name
+}
+
+func (r *ruleChecker) Run(cert *x509.Certificate, serviceAccount string, keyID string) []Signal {
+	out, _, err := r.program.Eval(map[string]any{
+		"cert":           certToCELInput(cert),
+		"serviceAccount": serviceAccount,
+		"keyId":          keyID,
+	})
+	if err != nil {
+		slog.Warn("Error evaluating rule", "rule", r.name, "serviceAccount", serviceAccount, "error", err)
+		return nil
+	}
+
+	if matched, ok := out.Value().(bool); !ok || !matched {
+		return nil
+	}
+	return []Signal{{
+		keyKind:     r.keyKind,
+		explanation: r.explanation,
+	}}
+}
+
+// The fields of the certificate which are available to rules as `cert`
+func certToCELInput(cert *x509.Certificate) map[string]any {
+	var extKeyUsage []int
+	for _, u := range cert.ExtKeyUsage {
+		extKeyUsage = append(extKeyUsage, int(u))
+	}
+
+	keySize := 0
+	if rsaKey, ok := cert.PublicKey.(*rsa.PublicKey); ok {
+		keySize = rsaKey.N.BitLen()
+	}
+
+	return map[string]any{
+		"notBefore":          cert.NotBefore,
+		"notAfter":           cert.NotAfter,
+		"serialNumber":       cert.SerialNumber.Text(16),
+		"subject":            cert.Subject.CommonName,
+		"issuer":             cert.Issuer.CommonName,
+		"publicKeyAlgorithm": cert.PublicKeyAlgorithm.String(),
+		"signatureAlgorithm": cert.SignatureAlgorithm.String(),
+		"keySize":            keySize,
+		"keyUsage":           int(cert.KeyUsage),
+		"extKeyUsage":        extKeyUsage,
+		"isCA":               cert.IsCA,
+	}
+}
+
+// Compiles the rules from the config and registers them as checkers
+func registerRules(rules []RuleConfig) error {
+	for i, rule := range rules {
+		if rule.Name == "" {
+			rule.Name = fmt.Sprintf("rule-%d", i+1)
+		}
+		if slices.Contains(checkerNames(), rule.Name) {
+			return fmt.Errorf("rule %v: a checker with this name already exists", rule.Name)
+		}
+		checker, err := newRuleChecker(rule)
+		if err != nil {
+			return err
+		}
+		RegisterChecker(checker)
+	}
+	return nil
+}
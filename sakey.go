@@ -3,154 +3,269 @@ package main
 import (
 	"crypto/rsa"
 	"crypto/x509"
+	"encoding/asn1"
 	"fmt"
 	"slices"
 	"strings"
+	"time"
 )
 
 type Signal struct {
 	keyKind     string
 	explanation string
+	// name of the Checker which emitted this signal
+	checker string
 }
 
 type SAKey struct {
 	serviceAccount string
+	keyID          string
 	cert           *x509.Certificate
 	signals        []Signal
 	keyKind        string
+	// fraction of the signals which agree with keyKind, and how many that is
+	confidence      float64
+	agreeingSignals int
+	findings        []Finding
+	// nil unless a --policy was evaluated for this key
+	policyDecision *PolicyDecision
+	// nil unless the key has been accepted, eg. by a --baseline
+	suppression *Suppression
+	// nil if the last authentication time was not looked up, zero if the key has not been used
+	lastAuthenticated *time.Time
+	// the activity analyzer query for the project of the key failed, so lastAuthenticated is nil
+	lastAuthenticationUnknown bool
+	// nil if the audit logs were not checked
+	usage *KeyUsage
+	// the audit log read for the project of the key failed, so usage is nil
+	usageUnknown bool
+	// nil unless --project-metadata was given and the project could be looked up
+	project *ProjectReport
+	// nil unless --rank-by-privilege
+	privilege *PrivilegeReport
+	// nil unless --workloads
+	workloads []WorkloadReport
+	// whether a workload search which could have covered the service account failed
+	workloadsFailed bool
+	// nil unless the service account was looked up in ground truth mode
+	serviceAccountMetadata *ServiceAccountReport
+	// nil unless the key was found in the ground truth
+	iamKey *IAMKeyReport
+	// how the key changed since the previous --state, empty if it didn't or there is no previous state
+	change string
+	// when the findings were checked, which the age of the key is relative to, zero if they weren't
+	checkedAt time.Time
 }
 
-func NewSAKey(serviceAccount string, cert *x509.Certificate) *SAKey {
+func NewSAKey(serviceAccount string, keyID string, cert *x509.Certificate) *SAKey {
 	return &SAKey{
 		serviceAccount: serviceAccount,
+		keyID:          keyID,
 		cert:           cert,
 		signals:        []Signal{},
 	}
 }
 
-func (k *SAKey) CheckValidityPeriod() {
-	validityWindow := k.cert.NotAfter.Sub(k.cert.NotBefore)
+func checkValidityPeriod(cert *x509.Certificate, serviceAccount string, keyID string) (signals []Signal) {
+	validityWindow := cert.NotAfter.Sub(cert.NotBefore)
 
-	if k.cert.NotAfter == defaultMaxAfter {
-		k.signals = append(k.signals, Signal{
+	if cert.NotAfter == defaultMaxAfter {
+		signals = append(signals, Signal{
 			keyKind:     GOOGLE_PROVIDED_USER_MANAGED,
-			explanation: fmt.Sprintf("Certificate has a NotAfter date of %v", k.cert.NotAfter),
+			explanation: fmt.Sprintf("Certificate has a NotAfter date of %v", cert.NotAfter),
 		})
 	} else if validityWindow == legacyGoogleProvidedUserManagedValidity {
-		k.signals = append(k.signals, Signal{
+		signals = append(signals, Signal{
 			keyKind:     GOOGLE_PROVIDED_USER_MANAGED,
 			explanation: fmt.Sprintf("Certificate has a legacy 10y validity period of %v", validityWindow),
 		})
 	} else if validityWindow == googleProvidedSystemManagedValidityV1 {
-		k.signals = append(k.signals, Signal{
+		signals = append(signals, Signal{
 			keyKind:     GOOGLE_PROVIDED_SYSTEM_MANAGED,
 			explanation: fmt.Sprintf("Certificate has standard validity period of %v", validityWindow),
 		})
 	} else if slices.Contains(serviceAccountKeyExpiryHours, validityWindow) {
-		k.signals = append(k.signals, Signal{
+		signals = append(signals, Signal{
 			keyKind:     GOOGLE_PROVIDED_USER_MANAGED,
 			explanation: fmt.Sprintf("Certificate has a validity period in constraints/iam.serviceAccountKeyExpiryHours of %v", validityWindow),
 		})
 	} else if validityWindow > googleProvidedSystemManagedValidityV2Min && validityWindow < googleProvidedSystemManagedValidityV2Max {
-		k.signals = append(k.signals, Signal{
+		signals = append(signals, Signal{
 			keyKind:     GOOGLE_PROVIDED_SYSTEM_MANAGED,
 			explanation: fmt.Sprintf("Certificate has a validity period of %v which is between %v and %v", validityWindow, googleProvidedSystemManagedValidityV2Min, googleProvidedSystemManagedValidityV2Max),
 		})
 	} else {
-		k.signals = append(k.signals, Signal{
+		signals = append(signals, Signal{
 			keyKind:     USER_PROVIDED_USER_MANAGED,
-			explanation: fmt.Sprintf("Certificate does not have a standard GCP validity window: %v (%v to %v)", validityWindow, k.cert.NotBefore, k.cert.NotAfter),
+			explanation: fmt.Sprintf("Certificate does not have a standard GCP validity window: %v (%v to %v)", validityWindow, cert.NotBefore, cert.NotAfter),
 		})
 	}
+	return
 }
 
-func (k *SAKey) CheckExtensions() {
-	if len(k.cert.ExtKeyUsage) != 1 || k.cert.ExtKeyUsage[0] != x509.ExtKeyUsageClientAuth {
-		k.signals = append(k.signals, Signal{
+func checkExtensions(cert *x509.Certificate, serviceAccount string, keyID string) (signals []Signal) {
+	if len(cert.ExtKeyUsage) != 1 || cert.ExtKeyUsage[0] != x509.ExtKeyUsageClientAuth {
+		signals = append(signals, Signal{
+			keyKind:     USER_PROVIDED_USER_MANAGED,
+			explanation: fmt.Sprintf("Certificate has unexpected ExtendedKeyUsage: %v", cert.ExtKeyUsage),
+		})
+	}
+
+	if cert.KeyUsage != x509.KeyUsageDigitalSignature {
+		signals = append(signals, Signal{
 			keyKind:     USER_PROVIDED_USER_MANAGED,
-			explanation: fmt.Sprintf("Certificate has unexpected ExtendedKeyUsage: %v", k.cert.ExtKeyUsage),
+			explanation: fmt.Sprintf("Certificate has unexpected KeyUsage: %v", cert.KeyUsage),
 		})
 	}
 
-	if k.cert.KeyUsage != x509.KeyUsageDigitalSignature {
-		k.signals = append(k.signals, Signal{
+	// tools like openssl add these by default, but GCP never does
+	if len(cert.SubjectKeyId) > 0 {
+		signals = append(signals, Signal{
 			keyKind:     USER_PROVIDED_USER_MANAGED,
-			explanation: fmt.Sprintf("Certificate has unexpected KeyUsage: %v", k.cert.KeyUsage),
+			explanation: "Certificate has a SubjectKeyId",
 		})
 	}
+	if len(cert.AuthorityKeyId) > 0 {
+		signals = append(signals, Signal{
+			keyKind:     USER_PROVIDED_USER_MANAGED,
+			explanation: "Certificate has an AuthorityKeyId",
+		})
+	}
+	if cert.IsCA {
+		signals = append(signals, Signal{
+			keyKind:     USER_PROVIDED_USER_MANAGED,
+			explanation: "Certificate is a CA",
+		})
+	}
+
+	// SubjectKeyId and AuthorityKeyId already have their own signals
+	for _, ext := range cert.Extensions {
+		if !slices.ContainsFunc(gcpCertExtensions, ext.Id.Equal) && !ext.Id.Equal(oidSubjectKeyId) && !ext.Id.Equal(oidAuthorityKeyId) {
+			signals = append(signals, Signal{
+				keyKind:     USER_PROVIDED_USER_MANAGED,
+				explanation: fmt.Sprintf("Certificate has extension %v, which GCP never adds", ext.Id),
+			})
+		}
+	}
+	return
 }
 
-func (k *SAKey) checkNames() {
-	expectedName := strings.Replace(k.serviceAccount, "@", ".", 1)
+// The only extensions in the certificates GCP generates: KeyUsage, ExtendedKeyUsage and BasicConstraints
+var gcpCertExtensions = []asn1.ObjectIdentifier{
+	{2, 5, 29, 15},
+	{2, 5, 29, 37},
+	{2, 5, 29, 19},
+}
+
+var oidSubjectKeyId = asn1.ObjectIdentifier{2, 5, 29, 14}
+var oidAuthorityKeyId = asn1.ObjectIdentifier{2, 5, 29, 35}
+
+func checkNames(cert *x509.Certificate, serviceAccount string, keyID string) (signals []Signal) {
+	expectedName := strings.Replace(serviceAccount, "@", ".", 1)
 	// 64 is the maximum length for a CN
 	var truncatedName string
 	if len(expectedName) >= 64 {
 		truncatedName = expectedName[:64]
 	}
+	legacyDomain := isLegacyServiceAccountDomain(serviceAccount)
 
 	checkName := func(t, v string) {
 		if GAIA_ID.MatchString(v) {
-			k.signals = append(k.signals, Signal{
+			signals = append(signals, Signal{
 				keyKind:     GOOGLE_PROVIDED_USER_MANAGED,
 				explanation: fmt.Sprintf("%v %v is a GAIA_ID", t, v),
 			})
 		} else if v == expectedName {
-			k.signals = append(k.signals, Signal{
+			signals = append(signals, Signal{
 				keyKind:     GOOGLE_PROVIDED_SYSTEM_MANAGED,
 				explanation: fmt.Sprintf("%v %v matches expected name %v", t, v, expectedName),
 			})
 		} else if truncatedName != "" && v == truncatedName {
-			k.signals = append(k.signals, Signal{
+			signals = append(signals, Signal{
 				keyKind:     GOOGLE_PROVIDED_SYSTEM_MANAGED,
 				explanation: fmt.Sprintf("%v %v matches expected truncated name %v", t, v, truncatedName),
 			})
+		} else if legacyDomain {
+			// the expected names were determined with iam.gserviceaccount.com accounts
+			// so a mismatch for the legacy domains isn't evidence of anything, leave it to the other checks
+			return
 		} else {
-			k.signals = append(k.signals, Signal{
+			signals = append(signals, Signal{
 				keyKind:     USER_PROVIDED_USER_MANAGED,
 				explanation: fmt.Sprintf("%v %v does not match any expected name %v", t, v, expectedName),
 			})
 		}
 	}
 
-	checkName("SubjectCN", k.cert.Subject.CommonName)
-	checkName("IssuerCN", k.cert.Issuer.CommonName)
+	checkName("SubjectCN", cert.Subject.CommonName)
+	checkName("IssuerCN", cert.Issuer.CommonName)
+	return
 }
 
 // Note: we don't emit positive signals for google provided keys here on purpose, only negative signals
 // because a key using the same parameters as a google provided key is not necessarily a google provided key
-func (k *SAKey) checkCrypto() {
-	if k.cert.PublicKeyAlgorithm != x509.RSA {
-		k.signals = append(k.signals, Signal{
+func checkCrypto(cert *x509.Certificate, serviceAccount string, keyID string) (signals []Signal) {
+	if cert.SignatureAlgorithm != x509.SHA1WithRSA {
+		signals = append(signals, Signal{
 			keyKind:     USER_PROVIDED_USER_MANAGED,
-			explanation: fmt.Sprintf("Public key algorithm %v is not RSA", k.cert.PublicKeyAlgorithm),
+			explanation: fmt.Sprintf("Signature algorithm %v is not SHA1WithRSA", cert.SignatureAlgorithm),
 		})
 	}
 
-	if k.cert.SignatureAlgorithm != x509.SHA1WithRSA {
-		k.signals = append(k.signals, Signal{
+	// GCP only generates RSA keys, but ECDSA or Ed25519 certificates could still show up, eg. from an odd upload
+	publicKey, ok := cert.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		signals = append(signals, Signal{
 			keyKind:     USER_PROVIDED_USER_MANAGED,
-			explanation: fmt.Sprintf("Signature algorithm %v is not SHA1WithRSA", k.cert.SignatureAlgorithm),
+			explanation: fmt.Sprintf("Public key algorithm %v is not RSA", cert.PublicKeyAlgorithm),
 		})
+		return
 	}
 
-	if k.cert.PublicKey.(*rsa.PublicKey).N.BitLen() == 1024 {
-		k.signals = append(k.signals, Signal{
+	if publicKey.N.BitLen() == 1024 {
+		signals = append(signals, Signal{
 			keyKind:     GOOGLE_PROVIDED_USER_MANAGED,
 			explanation: "Public key length is 1024",
 		})
-	} else if k.cert.PublicKey.(*rsa.PublicKey).N.BitLen() != 2048 {
-		k.signals = append(k.signals, Signal{
+	} else if publicKey.N.BitLen() != 2048 {
+		signals = append(signals, Signal{
+			keyKind:     USER_PROVIDED_USER_MANAGED,
+			explanation: fmt.Sprintf("Public key length %v is not 2048 or 1024", publicKey.N.BitLen()),
+		})
+	}
+	return
+}
+
+// GCP uses the serial number of the certificates it generates as the key ID, while an uploaded certificate has
+// whatever serial number it was created with. Skipped when the key ID isn't known, eg. for check-cert
+func checkKeyID(cert *x509.Certificate, serviceAccount string, keyID string) (signals []Signal) {
+	if keyID == "" {
+		return
+	}
+	if !KEY_ID.MatchString(keyID) {
+		signals = append(signals, Signal{
+			keyKind:     USER_PROVIDED_USER_MANAGED,
+			explanation: fmt.Sprintf("Key ID %v is not 40 hex digits like the key IDs GCP generates", keyID),
+		})
+		return
+	}
+	// the serial number drops any leading zeros of the key ID
+	if cert.SerialNumber.Text(16) != strings.TrimLeft(strings.ToLower(keyID), "0") {
+		signals = append(signals, Signal{
 			keyKind:     USER_PROVIDED_USER_MANAGED,
-			explanation: fmt.Sprintf("Public key length %v is not 2048 or 1024", k.cert.PublicKey.(*rsa.PublicKey).N.BitLen()),
+			explanation: fmt.Sprintf("Certificate serial number %v doesn't match the key ID %v", cert.SerialNumber.Text(16), keyID),
 		})
 	}
+	return
 }
 
 func (k *SAKey) check() {
-	k.checkNames()
-	k.checkCrypto()
-	k.CheckValidityPeriod()
-	k.CheckExtensions()
+	for _, checker := range enabledCheckers() {
+		for _, signal := range checker.Run(k.cert, k.serviceAccount, k.keyID) {
+			signal.checker = checker.Name()
+			k.signals = append(k.signals, signal)
+		}
+	}
 }
 
 // Returns the keyOrigin and keyType of the key
@@ -164,7 +279,8 @@ func (k *SAKey) determineKeyKind() (res string) {
 
 	// There should always be at least one signal from the validity period checks
 	if len(k.signals) == 0 {
-		panic("No signals found for key")
+		k.keyKind = KEY_KIND_UNKNOWN
+		return k.keyKind
 	}
 
 	for _, signal := range k.signals {
@@ -180,14 +296,109 @@ func (k *SAKey) determineKeyKind() (res string) {
 	}
 
 	k.keyKind = res
+	for _, signal := range k.signals {
+		if signal.keyKind == res {
+			k.agreeingSignals++
+		}
+	}
+	k.confidence = float64(k.agreeingSignals) / float64(len(k.signals))
 	return
 }
 
+// How old the key is and how long until it expires, which is the first thing asked about a bad key
+func (k *SAKey) lifetime() string {
+	now := k.checkedAt
+	if now.IsZero() {
+		now = time.Now()
+	}
+	res := fmt.Sprintf("%v (created %v)", humanDuration(now.Sub(k.cert.NotBefore)), k.cert.NotBefore.Format(time.DateOnly))
+	// like an uploaded certificate dated in the future
+	if k.cert.NotBefore.After(now) {
+		res = fmt.Sprintf("not valid yet, valid in %v (%v)", humanDuration(k.cert.NotBefore.Sub(now)), k.cert.NotBefore.Format(time.DateOnly))
+	}
+	switch {
+	case k.cert.NotAfter.Equal(defaultMaxAfter):
+		res += ", never expires"
+	case k.cert.NotAfter.Before(now):
+		res += fmt.Sprintf(", expired %v ago (%v)", humanDuration(now.Sub(k.cert.NotAfter)), k.cert.NotAfter.Format(time.DateOnly))
+	default:
+		res += fmt.Sprintf(", expires in %v (%v)", humanDuration(k.cert.NotAfter.Sub(now)), k.cert.NotAfter.Format(time.DateOnly))
+	}
+	return res
+}
+
 func (k *SAKey) dump(indent string, includeSignals bool) {
-	fmt.Printf("%vKey ID: %v - likely %v\n", indent, k.cert.SerialNumber, k.keyKind)
+	fmt.Printf("%vKey ID: %v - likely %v (confidence %.2f, %v of %v signals agree)\n", indent, k.cert.SerialNumber, colorize(keyKindColor(k.keyKind), k.keyKind), k.confidence, k.agreeingSignals, len(k.signals))
+	if severity := k.severity(); severity != "" {
+		fmt.Printf("%v  Severity: %v\n", indent, colorize(severityColor(severity), severity))
+	}
+	fmt.Printf("%v  Age: %v\n", indent, k.lifetime())
+	if k.change != "" {
+		fmt.Printf("%v  Change: %v\n", indent, k.change)
+	}
+	if k.lastAuthenticationUnknown {
+		fmt.Printf("%v  Last authenticated: unknown (lookup failed)\n", indent)
+	}
+	if k.lastAuthenticated != nil {
+		if k.lastAuthenticated.IsZero() {
+			fmt.Printf("%v  Last authenticated: never (within the activity analyzer observation period)\n", indent)
+		} else {
+			fmt.Printf("%v  Last authenticated: %v\n", indent, k.lastAuthenticated.Format(time.DateOnly))
+		}
+	}
+	if k.usageUnknown {
+		fmt.Printf("%v  Audit logs: unknown (lookup failed)\n", indent)
+	}
+	if k.usage != nil {
+		if k.usage.count == 0 {
+			fmt.Printf("%v  Audit logs: no authentications in the window\n", indent)
+		} else {
+			fmt.Printf("%v  Audit logs: %v authentications, most recently %v, from %v\n", indent, k.usage.count, k.usage.lastSeen.Format(time.RFC3339), strings.Join(k.usage.callerIPs, ", "))
+		}
+	}
+	if k.iamKey != nil {
+		details := fmt.Sprintf("%v/%v", k.iamKey.KeyOrigin, k.iamKey.KeyType)
+		if k.iamKey.KeyAlgorithm != "" {
+			details += ", " + k.iamKey.KeyAlgorithm
+		}
+		if k.iamKey.ValidAfterTime != "" {
+			details += ", valid from " + k.iamKey.ValidAfterTime
+		}
+		if k.iamKey.ValidBeforeTime != "" {
+			details += " until " + k.iamKey.ValidBeforeTime
+		}
+		fmt.Printf("%v  IAM API: %v\n", indent, details)
+		if k.iamKey.Disabled {
+			fmt.Printf("%v  IAM API: disabled (%v)\n", indent, k.iamKey.DisableReason)
+		}
+	}
+	if k.policyDecision != nil {
+		if k.policyDecision.decided {
+			fmt.Printf("%v  Policy: fail=%v severity=%v\n", indent, k.policyDecision.fail, k.policyDecision.severity)
+		} else if k.policyDecision.severity != "" {
+			fmt.Printf("%v  Policy: severity=%v\n", indent, k.policyDecision.severity)
+		}
+	}
+	if k.suppression != nil {
+		if k.suppression.expires.IsZero() {
+			fmt.Printf("%v  Suppressed: %v\n", indent, k.suppression.reason)
+		} else {
+			fmt.Printf("%v  Suppressed until %v: %v\n", indent, k.suppression.expires.Format(time.DateOnly), k.suppression.reason)
+		}
+	}
+	for _, finding := range k.findings {
+		if finding.warning {
+			fmt.Printf("%v  Warning %v: %v\n", indent, colorize(COLOR_YELLOW, finding.category), finding.explanation)
+		} else {
+			fmt.Printf("%v  Finding %v: %v\n", indent, colorize(severityColor(findingSeverities[finding.category]), finding.category), finding.explanation)
+		}
+	}
 	if includeSignals {
 		for _, signal := range k.signals {
-			fmt.Printf("%v  Signal for %v: %v\n", indent, signal.keyKind, signal.explanation)
+			fmt.Printf("%v  Signal for %v from %v: %v\n", indent, signal.keyKind, signal.checker, signal.explanation)
 		}
 	}
+	if *showCert {
+		certificateReport(k.cert).print(indent + "  ")
+	}
 }
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+)
+
+// key ID -> public key, from the JWK endpoint which publishes the same keys as the x509 endpoint
+type ServiceAccountJWKs map[string]*rsa.PublicKey
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Alg string `json:"alg,omitempty"`
+	Use string `json:"use,omitempty"`
+	Kid string `json:"kid"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+// Transient failures are retried like the x509 fetches
+func getServiceAccountJWKs(ctx context.Context, sa string, retries int) (ServiceAccountJWKs, error) {
+	return withRetries(ctx, retries, func() (ServiceAccountJWKs, error) {
+		return fetchServiceAccountJWKs(ctx, sa)
+	})
+}
+
+func fetchServiceAccountJWKs(ctx context.Context, sa string) (ServiceAccountJWKs, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, metadataURL("jwk", sa), nil)
+	if err != nil {
+		return nil, fmt.Errorf("error creating request: %v", err)
+	}
+	req.Header.Set("User-Agent", userAgent())
+	apiUsage.record(API_JWK)
+	resp, err := x509Client.Do(req)
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	if errors.Is(err, errNotRecorded) {
+		return nil, err
+	}
+	if err != nil {
+		return nil, transient(fmt.Errorf("error making request: %v", err))
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, errServiceAccountNotFound
+	}
+	if isTransientStatus(resp.StatusCode) {
+		return nil, transient(fmt.Errorf("error: unexpected status code: %v", resp.StatusCode))
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("error: unexpected status code: %v", resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, transient(fmt.Errorf("error reading response body: %v", err))
+	}
+	return parseServiceAccountJWKs(body)
+}
+
+// Parses a response of the JWK endpoint, a JWK set of RSA keys
+func parseServiceAccountJWKs(body []byte) (ServiceAccountJWKs, error) {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(body, &set); err != nil {
+		return nil, fmt.Errorf("error unmarshaling JSON: %v", err)
+	}
+
+	keys := ServiceAccountJWKs{}
+	for _, k := range set.Keys {
+		if k.Kty != "RSA" {
+			return nil, fmt.Errorf("error: unexpected key type %v for key %v", k.Kty, k.Kid)
+		}
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("error decoding modulus of key %v: %v", k.Kid, err)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, fmt.Errorf("error decoding exponent of key %v: %v", k.Kid, err)
+		}
+		exponent := new(big.Int).SetBytes(e)
+		if !exponent.IsInt64() || exponent.Int64() > 1<<31-1 {
+			return nil, fmt.Errorf("error: exponent of key %v is too large", k.Kid)
+		}
+		keys[k.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exponent.Int64())}
+	}
+	return keys, nil
+}
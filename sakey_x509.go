@@ -1,25 +1,87 @@
 package main
 
 import (
+	"context"
 	"crypto/x509"
 	"encoding/json"
 	"encoding/pem"
+	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
+	"time"
 )
 
 type ServiceAccountCerts map[string]*x509.Certificate
 
-func getServiceAccountKeyCerts(sa string) (ServiceAccountCerts, error) {
-	resp, err := http.Get("https://www.googleapis.com/service_accounts/v1/metadata/x509/" + sa)
+// The x509 endpoint doesn't tell deleted and disabled service accounts apart
+var errServiceAccountNotFound = errors.New("error: service account not found. Does it exist and is it enabled?")
+
+// Shared by all the x509 fetches, with enough idle connections for every inflight request, so connections to the
+// endpoint are reused instead of being churned (the default transport only keeps 2 idle connections per host)
+var x509Client = &http.Client{
+	Timeout: X509RequestTimeout,
+	Transport: &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   X509DialTimeout,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          MaxInflightX509,
+		MaxIdleConnsPerHost:   MaxInflightX509,
+		IdleConnTimeout:       X509IdleConnTimeout,
+		TLSHandshakeTimeout:   X509DialTimeout,
+		ExpectContinueTimeout: 1 * time.Second,
+	},
+}
+
+// Transient failures (429s, 5xxs and network errors) are retried up to retries times with backoff
+func getServiceAccountKeyCerts(ctx context.Context, sa string, retries int) (ServiceAccountCerts, error) {
+	return withRetries(ctx, retries, func() (ServiceAccountCerts, error) {
+		return fetchServiceAccountKeyCerts(ctx, sa)
+	})
+}
+
+func fetchServiceAccountKeyCerts(ctx context.Context, sa string) (ServiceAccountCerts, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, metadataURL("x509", sa), nil)
 	if err != nil {
-		return nil, fmt.Errorf("error making request: %v", err)
+		return nil, fmt.Errorf("error creating request: %v", err)
+	}
+	req.Header.Set("User-Agent", userAgent())
+	cached := x509Cache.get(sa)
+	if cached != nil {
+		if x509Cache.isFresh(cached) {
+			return parseServiceAccountKeyCerts([]byte(cached.Body))
+		}
+		cached.setConditionalHeaders(req)
+	}
+	apiUsage.record(API_X509)
+	resp, err := x509Client.Do(req)
+	if ctx.Err() != nil {
+		// not transient, so it isn't retried
+		return nil, ctx.Err()
+	}
+	if errors.Is(err, errNotRecorded) {
+		return nil, err
+	}
+	if err != nil {
+		return nil, transient(fmt.Errorf("error making request: %v", err))
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode == http.StatusNotModified && cached != nil {
+		x509Cache.touch(sa, cached)
+		return parseServiceAccountKeyCerts([]byte(cached.Body))
+	}
+
 	if resp.StatusCode == http.StatusNotFound {
-		return nil, fmt.Errorf("error: service account not found. Does it exist and is it enabled?")
+		return nil, errServiceAccountNotFound
+	}
+
+	if isTransientStatus(resp.StatusCode) {
+		return nil, transient(fmt.Errorf("error: unexpected status code: %v", resp.StatusCode))
 	}
 
 	if resp.StatusCode != http.StatusOK {
@@ -28,39 +90,57 @@ func getServiceAccountKeyCerts(sa string) (ServiceAccountCerts, error) {
 
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return nil, fmt.Errorf("error reading response body: %v", err)
+		return nil, transient(fmt.Errorf("error reading response body: %v", err))
 	}
 
+	certs, err := parseServiceAccountKeyCerts(body)
+	if err != nil {
+		return nil, err
+	}
+	x509Cache.put(sa, resp.Header, body)
+	return certs, nil
+}
+
+// Parses a response of the x509 endpoint, a JSON object of key ID to PEM encoded certificate
+func parseServiceAccountKeyCerts(body []byte) (ServiceAccountCerts, error) {
 	var keys map[string]string
-	err = json.Unmarshal(body, &keys)
+	err := json.Unmarshal(body, &keys)
 	if err != nil {
 		return nil, fmt.Errorf("error unmarshaling JSON: %v", err)
 	}
 
 	certs := map[string]*x509.Certificate{}
 	for keyId, v := range keys {
-		block, rest := pem.Decode([]byte(v))
-		if block == nil {
-			return nil, fmt.Errorf("error decoding PEM block")
-		}
-		if len(rest) > 0 {
-			return nil, fmt.Errorf("error: Extra data after PEM block")
-		}
-
-		if block.Type != "CERTIFICATE" {
-			return nil, fmt.Errorf("error: Unexpected PEM block type: %v. Expected CERTIFICATE", block.Type)
-		}
-		if len(block.Headers) > 0 {
-			return nil, fmt.Errorf("error: unexpected headers in PEM block %v", block.Headers)
-		}
-
-		cert, err := x509.ParseCertificate(block.Bytes)
+		cert, err := parseCertificatePEM([]byte(v))
 		if err != nil {
-			return nil, fmt.Errorf("error parsing certificate: %v", err)
+			return nil, err
 		}
-
 		certs[keyId] = cert
 	}
 
 	return certs, nil
 }
+
+// Exactly one PEM encoded certificate, without headers, like the x509 endpoint returns
+func parseCertificatePEM(data []byte) (*x509.Certificate, error) {
+	block, rest := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("error decoding PEM block")
+	}
+	if len(rest) > 0 {
+		return nil, fmt.Errorf("error: Extra data after PEM block")
+	}
+
+	if block.Type != "CERTIFICATE" {
+		return nil, fmt.Errorf("error: Unexpected PEM block type: %v. Expected CERTIFICATE", block.Type)
+	}
+	if len(block.Headers) > 0 {
+		return nil, fmt.Errorf("error: unexpected headers in PEM block %v", block.Headers)
+	}
+
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing certificate: %v", err)
+	}
+	return cert, nil
+}
@@ -0,0 +1,342 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"time"
+)
+
+// The state of a run which is carried over between the batches of service accounts
+// Only the counts, stats and report are kept, the certificates of earlier batches are dropped once they are classified
+type Scan struct {
+	outputMode     string
+	policy         *Policy
+	baseline       *Baseline
+	ignores        IgnoreList
+	keyFilter      KeyFilter
+	failOnSeverity string
+	keyPolicy      KeyPolicy
+	now            time.Time
+	// nil unless --terraform-state
+	terraform *TerraformState
+
+	good       int
+	bad        int
+	warned     int
+	unknown    int
+	suppressed int
+	// whether there are any failing keys at the --fail-on severity, in ground truth mode any mismatch fails
+	failed bool
+	// the keys which failed the run
+	failingKeys int
+	report      *Report
+	stats       *Stats
+	skipped     []SkippedReport
+	// service accounts which have been classified, for --resume
+	completed []string
+	// SHA-256 of each RSA modulus -> the keys with it, to find shared moduli across batches
+	moduli map[string][]KeyRef
+	// the moduli of the keys which aren't suppressed, a shared modulus only fails the run if one of its keys isn't
+	unsuppressedModuli map[string]bool
+	// the bad keys so far, for --rank-by-privilege
+	ranked []RankedKeyReport
+	// nil unless --state was given and has the results of a previous run, only what changed since is printed
+	previousSnapshot *Snapshot
+	// nil unless --state was given
+	snapshot *Snapshot
+	delta    SnapshotDelta
+	// the records of the current batch to append to the --history file, nil without --history
+	history []HistoryRecord
+}
+
+func NewScan(outputMode string) *Scan {
+	return &Scan{
+		outputMode:         outputMode,
+		now:                time.Now(),
+		report:             NewReport(),
+		stats:              NewStats(),
+		skipped:            []SkippedReport{},
+		moduli:             map[string][]KeyRef{},
+		unsuppressedModuli: map[string]bool{},
+	}
+}
+
+// Classifies and prints the keys of one batch of service accounts, adding them to the totals
+func (s *Scan) analyze(ctx context.Context, keyCollection *KeyCollection) error {
+	s.skipped = append(s.skipped, keyCollection.skippedSAs()...)
+
+	var err error
+	for i, serviceAccountID := range keyCollection.serviceAccountIDs {
+		if keyCollection.isBadSA(serviceAccountID) {
+			continue
+		}
+		var metadata *ServiceAccountReport
+		if keyCollection.serviceAccounts != nil {
+			metadata = serviceAccountReport(keyCollection.serviceAccounts[i])
+		}
+		var privilege *PrivilegeReport
+		if keyCollection.roles != nil {
+			privilege = privilegeReport(keyCollection.roles[serviceAccountID], keyCollection.assetSearchFailed("role lookup", serviceAccountID))
+		}
+		var workloads []WorkloadReport
+		workloadsFailed := false
+		if keyCollection.workloads != nil {
+			workloadsFailed = keyCollection.assetSearchFailed("workload lookup", serviceAccountID)
+			workloads = keyCollection.workloads[serviceAccountID]
+			if workloads == nil {
+				workloads = []WorkloadReport{}
+			}
+		}
+		printHeader := func() {
+			printServiceAccountHeader(serviceAccountID, metadata)
+			if privilege != nil {
+				privilege.print()
+			}
+			if workloads != nil {
+				printWorkloads(workloads, workloadsFailed)
+			}
+		}
+		printedName := false
+		if s.outputMode == OUTPUT_VERBOSE {
+			printHeader()
+		}
+
+		hasBadKeys := false
+		hasWarnings := false
+		var keyReports []KeyReport
+		var keys []*SAKey
+		for keyId, cert := range keyCollection.observedKeys[i] {
+			key := NewSAKey(serviceAccountID, keyId, cert)
+			key.determineKeyKind()
+			key.checkFindings(&s.keyPolicy, s.now)
+			if keyCollection.groundTruthKeys != nil {
+				key.iamKey = iamKeyReport(keyCollection.groundTruthKeys[i][keyId])
+				key.checkIAMValidity()
+			}
+			if keyCollection.jwks != nil && keyCollection.jwks[i] != nil {
+				key.checkJWK(keyCollection.jwks[i])
+			}
+			if s.terraform != nil {
+				key.checkTerraform(s.terraform)
+			}
+			keys = append(keys, key)
+		}
+		// the findings about the keys as a set need every key to be classified first
+		checkRotationSet(keys)
+		reported := slices.DeleteFunc(slices.Clone(keys), func(key *SAKey) bool { return !s.keyFilter.match(key) })
+		for _, key := range reported {
+			keyId, keyKind := key.keyID, key.keyKind
+			if keyKind == KEY_KIND_UNKNOWN && s.outputMode != OUTPUT_GROUND_TRUTH {
+				s.unknown++
+			}
+			if keyCollection.lastAuthentications != nil {
+				if keyCollection.projectLookupFailed("last authentication lookup", serviceAccountID) {
+					key.lastAuthenticationUnknown = true
+				} else {
+					lastAuthenticated := keyCollection.lastAuthentications[keyId]
+					key.lastAuthenticated = &lastAuthenticated
+				}
+			}
+			if keyCollection.keyUsage != nil && keyKind != GOOGLE_PROVIDED_SYSTEM_MANAGED {
+				usage := keyCollection.keyUsage[keyId]
+				if usage == nil && keyCollection.projectLookupFailed("audit log lookup", serviceAccountID) {
+					key.usageUnknown = true
+				} else {
+					if usage == nil {
+						usage = &KeyUsage{}
+					}
+					key.usage = usage
+				}
+			}
+			key.serviceAccountMetadata = metadata
+			if keyCollection.projectMetadata != nil {
+				key.project = keyCollection.projectMetadata[projectFromServiceAccount(serviceAccountID)]
+			}
+			key.privilege = privilege
+			key.workloads = workloads
+			key.workloadsFailed = workloadsFailed
+			if s.policy != nil {
+				key.policyDecision, err = s.policy.evaluate(ctx, key.report())
+				if err != nil {
+					return err
+				}
+			}
+			if s.baseline != nil {
+				key.suppression = s.baseline.match(serviceAccountID, keyId, s.now)
+			}
+			if key.suppression == nil {
+				key.suppression = s.ignores.match(serviceAccountID, keyId)
+			}
+			if key.suppression == nil && keyCollection.serviceAccounts != nil {
+				key.suppression = matchAnnotation(keyCollection.serviceAccounts[i], keyId, s.now)
+			}
+			if key.isBad() && key.suppression != nil && s.outputMode != OUTPUT_GROUND_TRUTH {
+				s.suppressed++
+			}
+			// after the suppression, as a baseline or ignore entry expiring is a change too
+			if s.previousSnapshot != nil {
+				key.change = s.previousSnapshot.change(key)
+				if key.change == CHANGE_NEW_KEY {
+					s.delta.NewKeys++
+				} else if key.change != "" {
+					s.delta.ChangedKeys++
+				}
+			}
+			if privilege != nil && key.isBad() && key.suppression == nil && s.outputMode != OUTPUT_GROUND_TRUTH {
+				s.ranked = append(s.ranked, RankedKeyReport{
+					ServiceAccount: serviceAccountID,
+					KeyID:          keyId,
+					KeyKind:        keyKind,
+					Severity:       key.severity(),
+					Privilege:      privilege.Level,
+					Roles:          len(privilege.Roles),
+					Workloads:      len(workloads),
+				})
+			}
+			if *reportFile != "" || *fingerprintsFile != "" || *terraformUnmanagedScript != "" || *tui {
+				keyReports = append(keyReports, key.report())
+			}
+			// a previous --state only changes what is printed, the keys which are still failing keep failing the run
+			if s.outputMode != OUTPUT_GROUND_TRUTH && key.isFailing() && severityAtLeast(key.severity(), s.failOnSeverity) {
+				s.failed = true
+				s.failingKeys++
+			}
+			s.addModulus(key, s.outputMode != OUTPUT_GROUND_TRUTH && key.suppression == nil)
+			switch s.outputMode {
+			case OUTPUT_NORMAL:
+				hasBadKeys = hasBadKeys || key.isFailing()
+				hasWarnings = hasWarnings || key.hasWarnings()
+				show := key.isBad() || key.hasWarnings()
+				if s.previousSnapshot != nil {
+					show = key.change != ""
+				}
+				if show {
+					if !printedName {
+						printHeader()
+						printedName = true
+					}
+					key.dump("  ", true)
+				}
+			case OUTPUT_QUIET, OUTPUT_SUMMARY:
+				hasBadKeys = hasBadKeys || key.isFailing()
+				hasWarnings = hasWarnings || key.hasWarnings()
+			case OUTPUT_VERBOSE:
+				key.dump("  ", true)
+				if key.isFailing() {
+					hasBadKeys = true
+				}
+				if key.hasWarnings() {
+					hasWarnings = true
+				}
+			case OUTPUT_GROUND_TRUTH:
+				realKeyKind := KEY_KIND_UNKNOWN
+				if realKey := keyCollection.groundTruthKeys[i][keyId]; realKey != nil {
+					realKeyKind = keyTypeAndOriginToMuxedKeyKind(realKey.KeyType, realKey.KeyOrigin)
+					if realKeyKind == KEY_KIND_UNKNOWN {
+						slog.Warn("Unknown key type and origin", "serviceAccount", serviceAccountID, "keyId", keyId, "keyType", realKey.KeyType, "keyOrigin", realKey.KeyOrigin)
+					}
+				}
+				if realKeyKind == KEY_KIND_UNKNOWN || keyKind == KEY_KIND_UNKNOWN {
+					s.unknown++
+				}
+				if realKeyKind != keyKind {
+					if key.suppression != nil {
+						s.suppressed++
+					} else {
+						hasBadKeys = true
+						s.failed = true
+					}
+					if !printedName {
+						printHeader()
+						printedName = true
+					}
+					fmt.Printf("  Key ID: %v - expected %v, got %v\n", key.cert.SerialNumber, realKeyKind, keyKind)
+					key.dump("    ", true)
+				} else if key.hasWarnings() {
+					// eg. the IAM validity doesn't match the certificate
+					if !printedName {
+						printHeader()
+						printedName = true
+					}
+					key.dump("  ", true)
+					hasWarnings = true
+				}
+			}
+		}
+		if s.previousSnapshot != nil {
+			for _, keyID := range s.previousSnapshot.removedKeys(serviceAccountID, keys) {
+				if !s.keyFilter.matchRemoved(s.previousSnapshot.ServiceAccounts[serviceAccountID][keyID]) {
+					continue
+				}
+				s.delta.RemovedKeys++
+				if !s.printsDetails() {
+					continue
+				}
+				if !printedName {
+					printHeader()
+					printedName = true
+				}
+				fmt.Printf("  Key ID: %v - removed since the last scan, was %v\n", keyID, s.previousSnapshot.ServiceAccounts[serviceAccountID][keyID].KeyKind)
+			}
+		}
+		if s.snapshot != nil {
+			s.snapshot.update(serviceAccountID, keys)
+		}
+		if s.history != nil {
+			s.history = append(s.history, historyRecord(serviceAccountID, keys, s.now))
+		}
+		var jwks ServiceAccountJWKs
+		if keyCollection.jwks != nil {
+			jwks = keyCollection.jwks[i]
+		}
+		warnings := serviceAccountWarnings(keys, jwks)
+		if s.outputMode == OUTPUT_GROUND_TRUTH {
+			warnings = append(warnings, groundTruthWarnings(keys, keyCollection.groundTruthKeys[i])...)
+		}
+		for _, warning := range warnings {
+			hasWarnings = true
+			if !s.printsDetails() {
+				continue
+			}
+			if !printedName && s.outputMode != OUTPUT_VERBOSE {
+				printHeader()
+				printedName = true
+			}
+			fmt.Printf("  %v %v\n", colorize(COLOR_YELLOW, "Warning:"), warning)
+		}
+		if hasBadKeys {
+			s.bad++
+		} else {
+			s.good++
+		}
+		var project *ProjectReport
+		if keyCollection.projectMetadata != nil {
+			project = keyCollection.projectMetadata[projectFromServiceAccount(serviceAccountID)]
+		}
+		s.report.addServiceAccount(serviceAccountID, hasBadKeys, keyReports, warnings, project)
+		s.stats.addServiceAccount(reported, s.now)
+		if hasWarnings {
+			s.warned++
+		}
+		s.completed = append(s.completed, serviceAccountID)
+	}
+	return nil
+}
+
+// The exit code of a completed scan
+func (s *Scan) exit(report *Report) {
+	if s.failed {
+		exit(EXIT_FINDINGS)
+	} else if len(report.Skipped) > 0 || report.NotScanned > 0 {
+		exit(EXIT_SCAN_ERRORS)
+	} else {
+		exit(EXIT_OK)
+	}
+}
+
+// Whether the keys and warnings of each service account are printed
+func (s *Scan) printsDetails() bool {
+	return s.outputMode != OUTPUT_QUIET && s.outputMode != OUTPUT_SUMMARY
+}
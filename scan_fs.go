@@ -0,0 +1,289 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/x509"
+	"flag"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/pkcs12"
+	"google.golang.org/api/iam/v1"
+)
+
+// formats of the key files found by scan-fs
+const (
+	KEY_FILE_JSON = "json"
+	KEY_FILE_P12  = "p12"
+)
+
+// A service account key found in a file
+type FoundKey struct {
+	path   string
+	format string
+	// empty if it couldn't be determined, like for some P12 files
+	serviceAccount string
+	// the unique ID of the service account, for P12 files with a GAIA ID as the certificate's name
+	uniqueID string
+	// empty for P12 files, which are matched by publicKey instead
+	keyID     string
+	publicKey crypto.PublicKey
+	// only for P12 files, the JSON ones always have the service account, to classify the key when it's unknown
+	cert *x509.Certificate
+
+	// set by checkFoundKeys, key is nil unless the key is published
+	status string
+	key    *SAKey
+	// the likely kind of the key from its certificate alone, when the service account is unknown
+	keyKind string
+	// the error looking up the key, if any
+	err error
+}
+
+// Returns nil if the file isn't a service account key
+func detectKeyFile(path string, data []byte) *FoundKey {
+	if bytes.Contains(data, []byte(`"private_key_id"`)) {
+		keyFile, err := parseServiceAccountKeyFile(data)
+		if err != nil {
+			return nil
+		}
+		// the public key is only used to find the service account of P12 files for the same key
+		publicKey, _ := keyFile.publicKey()
+		return &FoundKey{
+			path:           path,
+			format:         KEY_FILE_JSON,
+			serviceAccount: strings.ToLower(keyFile.ClientEmail),
+			keyID:          keyFile.PrivateKeyID,
+			publicKey:      publicKey,
+		}
+	}
+
+	ext := strings.ToLower(filepath.Ext(path))
+	if ext != ".p12" && ext != ".pfx" {
+		return nil
+	}
+	_, cert, err := pkcs12.Decode(data, P12_PASSWORD)
+	if err != nil {
+		return nil
+	}
+	found := &FoundKey{
+		path:           path,
+		format:         KEY_FILE_P12,
+		serviceAccount: serviceAccountFromCertName(cert),
+		publicKey:      cert.PublicKey,
+		cert:           cert,
+	}
+	// Google generated P12 keys of user managed keys are named after the unique ID of the service account
+	if isUniqueID(cert.Subject.CommonName) {
+		found.uniqueID = cert.Subject.CommonName
+	}
+	return found
+}
+
+// Google generated certificates are named after the service account, with the @ replaced by a dot
+// Returns empty if the name isn't like that, or may have been truncated
+func serviceAccountFromCertName(cert *x509.Certificate) string {
+	name := cert.Subject.CommonName
+	if len(name) >= 64 || !strings.HasSuffix(name, ".gserviceaccount.com") && !strings.HasSuffix(name, userManagedServiceAccountDomain) {
+		return ""
+	}
+	// account IDs can't contain dots, so the first one was the @
+	return strings.Replace(name, ".", "@", 1)
+}
+
+// Walks the directory tree looking for key files, skipping .git directories and large files
+func findKeyFiles(root string) ([]*FoundKey, error) {
+	var res []*FoundKey
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			slog.Warn("Skipping file", "path", path, "error", err)
+			if d != nil && d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() {
+			if d.Name() == ".git" {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil || info.Size() > MaxKeyFileSize {
+			return nil
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			slog.Warn("Skipping file", "path", path, "error", err)
+			return nil
+		}
+		if found := detectKeyFile(path, data); found != nil {
+			res = append(res, found)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// Fills in the service account of the P12 keys which weren't named after it, from a JSON key file for the same key
+// or by resolving the unique ID they are named after with the IAM API
+func resolveFoundKeyServiceAccounts(ctx context.Context, keys []*FoundKey) error {
+	var unresolved []*FoundKey
+	for _, found := range keys {
+		if found.serviceAccount != "" {
+			continue
+		}
+		for _, other := range keys {
+			if other.format == KEY_FILE_JSON && other.publicKey != nil && samePublicKey(other.publicKey, found.publicKey) {
+				found.serviceAccount = other.serviceAccount
+				found.keyID = other.keyID
+				break
+			}
+		}
+		if found.serviceAccount == "" && found.uniqueID != "" {
+			unresolved = append(unresolved, found)
+		}
+	}
+	if len(unresolved) == 0 {
+		return nil
+	}
+
+	// the rest of scan-fs doesn't need credentials, so without them these keys are just classified
+	iamClient, err := iam.NewService(ctx, gcpClientOptions()...)
+	if err != nil {
+		slog.Warn("Unable to create the IAM client, so the service accounts of P12 keys named after a unique ID can't be looked up", "keys", len(unresolved), "error", err)
+		return nil
+	}
+	limiter := NewAdaptiveLimiter(IAMReadRequestsPerMinutePerProjectMax)
+	_, err = parllelMap(ctx, unresolved, func(ctx context.Context, found *FoundKey) (any, error) {
+		res, err := limitedCall(ctx, limiter, IAMMaxRetries, func() (*iam.ServiceAccount, error) {
+			return getServiceAccount(ctx, iamClient, found.uniqueID, "")
+		})
+		if err != nil {
+			slog.Warn("Unable to resolve the unique ID in the certificate to a service account email", "path", found.path, "uniqueId", found.uniqueID, "error", err)
+			return nil, nil
+		}
+		found.serviceAccount = res.Email
+		return nil, nil
+	})
+	return err
+}
+
+// Looks up each of the keys on the x509 endpoint, to see if they can still be used
+// Keys whose service account is unknown are classified from their certificate alone
+func checkFoundKeys(ctx context.Context, keys []*FoundKey) error {
+	if err := resolveFoundKeyServiceAccounts(ctx, keys); err != nil {
+		return err
+	}
+	now := time.Now()
+	_, err := parllelMap(ctx, keys, func(ctx context.Context, found *FoundKey) (any, error) {
+		if found.serviceAccount == "" {
+			found.status = KEY_STATUS_UNKNOWN
+			key := NewSAKey("", "", found.cert)
+			key.determineKeyKind()
+			found.keyKind = key.keyKind
+			return nil, nil
+		}
+		key, err := lookupKey(ctx, found.serviceAccount, found.keyID, found.publicKey)
+		if err != nil {
+			found.status = KEY_STATUS_UNKNOWN
+			found.err = err
+			return nil, nil
+		}
+		found.key = key
+		found.status = keyStatus(key, now)
+		return nil, nil
+	})
+	return err
+}
+
+func (f *FoundKey) print() {
+	keyID := f.keyID
+	if keyID == "" {
+		keyID = "(unknown ID)"
+	}
+	switch {
+	case f.err != nil:
+		fmt.Printf("%v: %v - %v key %v of %v, error looking it up: %v\n", f.path, f.status, f.format, keyID, f.serviceAccount, f.err)
+	case f.serviceAccount == "":
+		fmt.Printf("%v: %v - %v key of an unknown service account (it couldn't be determined from the certificate or a JSON key file for the same key), likely %v\n", f.path, f.status, f.format, f.keyKind)
+	case f.key == nil:
+		fmt.Printf("%v: %v - %v key %v of %v\n", f.path, f.status, f.format, keyID, f.serviceAccount)
+	default:
+		fmt.Printf("%v: %v - %v key %v of %v, likely %v, valid until %v\n", f.path, f.status, f.format, f.key.keyID, f.serviceAccount, f.key.keyKind, f.key.cert.NotAfter.Format(time.DateOnly))
+	}
+}
+
+// scan-fs [-git-history] DIR...
+// Finds service account key files (JSON and P12) under the directories, and reports which are for keys which still work
+func scanFSCommand(args []string) {
+	fs := flag.NewFlagSet("scan-fs", flag.ExitOnError)
+	gitHistory := fs.Bool("git-history", false, "Also scan every commit of the git history of the directories, which must be git repositories, for key files which were committed (even if they were later deleted)")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %v scan-fs [flags] DIR...\n", os.Args[0])
+		fmt.Fprintln(fs.Output(), "Exits with 1 if any of the keys found are still active")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(EXIT_FATAL)
+	}
+
+	var keys []*FoundKey
+	for _, root := range fs.Args() {
+		found, err := findKeyFiles(root)
+		if err != nil {
+			fatal(err.Error())
+		}
+		keys = append(keys, found...)
+
+		if *gitHistory {
+			found, err := findKeyFilesInGitHistory(root)
+			if err != nil {
+				fatal(err.Error())
+			}
+			keys = append(keys, found...)
+		}
+	}
+	reportFoundKeys(keys)
+}
+
+// Looks up and prints the keys, then exits
+func reportFoundKeys(keys []*FoundKey) {
+	if err := checkFoundKeys(context.Background(), keys); err != nil {
+		fatal(err.Error())
+	}
+
+	active := 0
+	failed := 0
+	for _, key := range keys {
+		key.print()
+		if key.status == KEY_STATUS_ACTIVE {
+			active++
+		}
+		if key.err != nil {
+			failed++
+		}
+	}
+	fmt.Printf("Found %d key files, %d of them still active\n", len(keys), active)
+	if active > 0 {
+		os.Exit(EXIT_FINDINGS)
+	} else if failed > 0 {
+		os.Exit(EXIT_SCAN_ERRORS)
+	}
+	os.Exit(EXIT_OK)
+}
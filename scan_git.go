@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// Runs git in the repository, with input on stdin
+func runGit(repo string, input string, args ...string) ([]byte, error) {
+	cmd := exec.Command("git", append([]string{"-C", repo}, args...)...)
+	cmd.Stdin = strings.NewReader(input)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("error running git %v in %v: %v %v", args[0], repo, err, strings.TrimSpace(stderr.String()))
+	}
+	return out, nil
+}
+
+// Finds key files in every commit of the git repository, including ones which have since been deleted
+// Each version of a file is only checked once, however many commits it is in
+func findKeyFilesInGitHistory(repo string) ([]*FoundKey, error) {
+	// every object reachable from any ref, with the path it was first seen at
+	out, err := runGit(repo, "", "rev-list", "--all", "--objects")
+	if err != nil {
+		return nil, err
+	}
+	paths := map[string]string{}
+	var objects strings.Builder
+	for _, line := range strings.Split(string(out), "\n") {
+		sha, path, found := strings.Cut(line, " ")
+		if !found || path == "" {
+			continue
+		}
+		paths[sha] = path
+		objects.WriteString(sha + "\n")
+	}
+	if len(paths) == 0 {
+		return nil, nil
+	}
+
+	// only read the blobs which are small enough to be key files
+	out, err = runGit(repo, objects.String(), "cat-file", "--batch-check=%(objectname) %(objecttype) %(objectsize)")
+	if err != nil {
+		return nil, err
+	}
+	var blobs strings.Builder
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 3 || fields[1] != "blob" {
+			continue
+		}
+		if size, err := strconv.Atoi(fields[2]); err != nil || size > MaxKeyFileSize {
+			continue
+		}
+		blobs.WriteString(fields[0] + "\n")
+	}
+	if blobs.Len() == 0 {
+		return nil, nil
+	}
+
+	out, err = runGit(repo, blobs.String(), "cat-file", "--batch")
+	if err != nil {
+		return nil, err
+	}
+	var res []*FoundKey
+	r := bufio.NewReader(bytes.NewReader(out))
+	for {
+		// <sha> <type> <size>\n<content>\n
+		header, err := r.ReadString('\n')
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading git objects in %v: %v", repo, err)
+		}
+		fields := strings.Fields(header)
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("unexpected git cat-file output in %v: %q", repo, header)
+		}
+		size, err := strconv.Atoi(fields[2])
+		if err != nil {
+			return nil, fmt.Errorf("unexpected git cat-file output in %v: %q", repo, header)
+		}
+		data := make([]byte, size+1)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, fmt.Errorf("error reading git objects in %v: %v", repo, err)
+		}
+
+		sha := fields[0]
+		if found := detectKeyFile(paths[sha], data[:size]); found != nil {
+			commit, err := firstCommitWithObject(repo, sha)
+			if err != nil {
+				return nil, err
+			}
+			found.path = fmt.Sprintf("%v (%v:%v)", repo, commit, paths[sha])
+			res = append(res, found)
+		}
+	}
+	return res, nil
+}
+
+// The oldest commit which added the object, ie. when it was first exposed
+func firstCommitWithObject(repo string, sha string) (string, error) {
+	out, err := runGit(repo, "", "log", "--all", "--reverse", "--format=%h", "--find-object="+sha)
+	if err != nil {
+		return "", err
+	}
+	commit, _, _ := strings.Cut(string(out), "\n")
+	return commit, nil
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"log/slog"
+	"regexp"
+	"strings"
+)
+
+// Older service accounts, like the default compute and App Engine ones, use these domains instead
+// https://cloud.google.com/iam/docs/service-account-types#default
+const (
+	developerServiceAccountDomain = "developer.gserviceaccount.com"
+	appEngineServiceAccountDomain = "appspot.gserviceaccount.com"
+)
+
+var projectNumberPrefix = regexp.MustCompile(`^(?:service-)?([0-9]+)(?:-compute)?@`)
+
+// Returns the project ID (or number, for accounts which only contain that) that owns a service account, based on its email
+// returns "" if the project can't be determined from the email alone
+func projectFromServiceAccount(serviceAccount string) string {
+	name, domain, found := strings.Cut(serviceAccount, "@")
+	if !found {
+		return ""
+	}
+
+	switch serviceAccountCategory(serviceAccount) {
+	case SA_CATEGORY_SERVICE_AGENT, SA_CATEGORY_COMPUTE_DEFAULT:
+		// these are in a google owned domain, but are named after the number of the project they belong to
+		if m := projectNumberPrefix.FindStringSubmatch(serviceAccount); m != nil {
+			return m[1]
+		}
+		return ""
+	case SA_CATEGORY_APP_ENGINE_DEFAULT:
+		return name
+	}
+
+	project, found := strings.CutSuffix(domain, userManagedServiceAccountDomain)
+	if !found || strings.Contains(project, ".") {
+		return ""
+	}
+	return project
+}
+
+// The IAM API resource name for a service account, the - wildcard lets the API infer the project
+// which works for every domain, including the legacy ones where the project isn't in the email
+func serviceAccountResourceName(serviceAccount string) string {
+	return "projects/-/serviceAccounts/" + serviceAccount
+}
+
+// Whether the service account uses one of the older non iam.gserviceaccount.com domains
+func isLegacyServiceAccountDomain(serviceAccount string) bool {
+	_, domain, _ := strings.Cut(serviceAccount, "@")
+	return domain == developerServiceAccountDomain || domain == appEngineServiceAccountDomain
+}
+
+// Categories of service accounts, based on the shape of their email
+const (
+	SA_CATEGORY_USER_CREATED       = "user-created"
+	SA_CATEGORY_SERVICE_AGENT      = "service-agent"
+	SA_CATEGORY_COMPUTE_DEFAULT    = "compute-default"
+	SA_CATEGORY_APP_ENGINE_DEFAULT = "app-engine-default"
+)
+
+// The google owned projects of the older service agents, which aren't in a gcp-sa-SERVICE project
+// a service account named service-NUMBER can be created in any project, so only these are trusted to be service agents
+var legacyServiceAgentProjects = []string{
+	"compute-system",
+	"container-engine-robot",
+	"containerregistry",
+	"container-analysis",
+	"cloudcomposer-accounts",
+	"dataflow-service-producer-prod",
+	"dataproc-accounts",
+	"gcf-admin-robot",
+	"genomics-pipelines",
+	"gs-project-accounts",
+	"serverless-robot-prod",
+	"sourcerepo-service-accounts",
+	"firebase-rules",
+	"dlp-api",
+}
+
+// https://cloud.google.com/iam/docs/service-agents
+var serviceAgentPatterns = []*regexp.Regexp{
+	// service-PROJECT_NUMBER@gcp-sa-SERVICE.iam.gserviceaccount.com, the older ones in google owned projects like compute-system,
+	// and ones in google.com domain scoped projects (like cloud-ml.google.com), which only Google can create
+	regexp.MustCompile(`^service-[0-9]+@(?:gcp-sa-[a-z0-9-]+|` + strings.Join(legacyServiceAgentProjects, "|") + `|[a-z0-9-]+\.google\.com)\.iam\.gserviceaccount\.com$`),
+	regexp.MustCompile(`^[^@]+@gcp-sa-[a-z0-9-]+\.iam\.gserviceaccount\.com$`),
+	regexp.MustCompile(`^[0-9]+@cloudservices\.gserviceaccount\.com$`),
+	regexp.MustCompile(`^[0-9]+@cloudbuild\.gserviceaccount\.com$`),
+}
+
+// https://cloud.google.com/compute/docs/access/service-accounts#default_service_account
+var computeDefaultPattern = regexp.MustCompile(`^[0-9]+-compute@developer\.gserviceaccount\.com$`)
+
+// https://cloud.google.com/appengine/docs/standard/configure-service-accounts#default-service-account
+var appEngineDefaultPattern = regexp.MustCompile(`^[^@]+@appspot\.gserviceaccount\.com$`)
+
+func serviceAccountCategory(serviceAccount string) string {
+	for _, p := range serviceAgentPatterns {
+		if p.MatchString(serviceAccount) {
+			return SA_CATEGORY_SERVICE_AGENT
+		}
+	}
+	if computeDefaultPattern.MatchString(serviceAccount) {
+		return SA_CATEGORY_COMPUTE_DEFAULT
+	}
+	if appEngineDefaultPattern.MatchString(serviceAccount) {
+		return SA_CATEGORY_APP_ENGINE_DEFAULT
+	}
+	return SA_CATEGORY_USER_CREATED
+}
+
+func isDefaultServiceAccount(serviceAccount string) bool {
+	category := serviceAccountCategory(serviceAccount)
+	return category == SA_CATEGORY_COMPUTE_DEFAULT || category == SA_CATEGORY_APP_ENGINE_DEFAULT
+}
+
+// Informational remediation guidance for service accounts which aren't created by users
+func serviceAccountCategoryNote(serviceAccount string) string {
+	switch serviceAccountCategory(serviceAccount) {
+	case SA_CATEGORY_COMPUTE_DEFAULT:
+		return "this is the default Compute Engine service account, prefer disabling it and using dedicated service accounts over managing its keys"
+	case SA_CATEGORY_APP_ENGINE_DEFAULT:
+		return "this is the default App Engine service account, prefer disabling it and using dedicated service accounts over managing its keys"
+	}
+	return ""
+}
+
+// Removes service agents and/or default service accounts
+func skipServiceAccountCategories(serviceAccountIDs []string, skipServiceAgents bool, skipDefaultSAs bool) []string {
+	var res []string
+	for _, sa := range serviceAccountIDs {
+		if skipServiceAgents && serviceAccountCategory(sa) == SA_CATEGORY_SERVICE_AGENT {
+			continue
+		}
+		if skipDefaultSAs && isDefaultServiceAccount(sa) {
+			continue
+		}
+		res = append(res, sa)
+	}
+
+	if skipped := len(serviceAccountIDs) - len(res); skipped > 0 {
+		slog.Info("Skipped service agents / default service accounts", "count", skipped)
+	}
+	return res
+}
@@ -0,0 +1,29 @@
+package main
+
+import "testing"
+
+func TestServiceAccountCategory(t *testing.T) {
+	for _, c := range []struct {
+		serviceAccount string
+		category       string
+		project        string
+	}{
+		{"service-123456@gcp-sa-pubsub.iam.gserviceaccount.com", SA_CATEGORY_SERVICE_AGENT, "123456"},
+		{"service-123456@compute-system.iam.gserviceaccount.com", SA_CATEGORY_SERVICE_AGENT, "123456"},
+		{"service-123456@cloud-ml.google.com.iam.gserviceaccount.com", SA_CATEGORY_SERVICE_AGENT, "123456"},
+		{"123456@cloudservices.gserviceaccount.com", SA_CATEGORY_SERVICE_AGENT, "123456"},
+		{"123456-compute@developer.gserviceaccount.com", SA_CATEGORY_COMPUTE_DEFAULT, "123456"},
+		{"myproj@appspot.gserviceaccount.com", SA_CATEGORY_APP_ENGINE_DEFAULT, "myproj"},
+		{"deployer@myproj.iam.gserviceaccount.com", SA_CATEGORY_USER_CREATED, "myproj"},
+		// a user created service account can be named like a service agent, in any project
+		{"service-123456@myproj.iam.gserviceaccount.com", SA_CATEGORY_USER_CREATED, "myproj"},
+		{"service-123456@compute-system-copy.iam.gserviceaccount.com", SA_CATEGORY_USER_CREATED, "compute-system-copy"},
+	} {
+		if category := serviceAccountCategory(c.serviceAccount); category != c.category {
+			t.Errorf("serviceAccountCategory(%v) = %v, expected %v", c.serviceAccount, category, c.category)
+		}
+		if project := projectFromServiceAccount(c.serviceAccount); project != c.project {
+			t.Errorf("projectFromServiceAccount(%v) = %v, expected %v", c.serviceAccount, project, c.project)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+)
+
+// Severities of bad keys, so CI can block on only the worst cases with --fail-on
+const (
+	SEVERITY_LOW    = "low"
+	SEVERITY_MEDIUM = "medium"
+	SEVERITY_HIGH   = "high"
+	// only valid for --fail-on, fails on bad keys of any severity
+	SEVERITY_ANY = "any"
+)
+
+// lowest first
+var severityOrder = []string{
+	SEVERITY_LOW,
+	SEVERITY_MEDIUM,
+	SEVERITY_HIGH,
+}
+
+// Uploaded keys could have been generated anywhere and may never expire, so they are the worst.
+// Downloaded keys are only medium once they are older than --max-key-age
+var keyKindSeverities = map[string]string{
+	USER_PROVIDED_USER_MANAGED:   SEVERITY_HIGH,
+	GOOGLE_PROVIDED_USER_MANAGED: SEVERITY_LOW,
+	KEY_KIND_UNKNOWN:             SEVERITY_MEDIUM,
+}
+
+var findingSeverities = map[string]string{
+	FINDING_KEY_AGE:       SEVERITY_MEDIUM,
+	FINDING_EXPIRED:       SEVERITY_LOW,
+	FINDING_NOT_YET_VALID: SEVERITY_MEDIUM,
+	FINDING_INVERTED:      SEVERITY_MEDIUM,
+	FINDING_ROCA:          SEVERITY_HIGH,
+	FINDING_SHORT_KEY:     SEVERITY_MEDIUM,
+	FINDING_WEAK_EXPONENT: SEVERITY_MEDIUM,
+	FINDING_SMALL_FACTOR:  SEVERITY_HIGH,
+	FINDING_DEBIAN_WEAK:   SEVERITY_HIGH,
+}
+
+func parseSeverity(s string) (string, error) {
+	if !slices.Contains(severityOrder, s) {
+		return "", fmt.Errorf("invalid severity %q, must be one of %v", s, severityOrder)
+	}
+	return s, nil
+}
+
+// Parses the --fail-on flag, which also accepts any
+func parseFailOn(s string) (string, error) {
+	if s == SEVERITY_ANY {
+		return SEVERITY_LOW, nil
+	}
+	return parseSeverity(s)
+}
+
+// Returns true if severity a is at least as severe as b
+func severityAtLeast(a string, b string) bool {
+	return slices.Index(severityOrder, a) >= slices.Index(severityOrder, b)
+}
+
+// The highest severity of the key kind and the findings, or empty if the key isn't bad
+// A policy can override it by setting severity to one of the known severities
+func (k *SAKey) severity() string {
+	if !k.isBad() {
+		return ""
+	}
+	if k.policyDecision != nil {
+		if severity, err := parseSeverity(k.policyDecision.severity); err == nil {
+			return severity
+		}
+	}
+
+	res := keyKindSeverities[k.keyKind]
+	for _, finding := range k.findings {
+		if severity, ok := findingSeverities[finding.category]; ok && !finding.warning && (res == "" || severityAtLeast(severity, res)) {
+			res = severity
+		}
+	}
+	// a bad key always has a severity, even if a policy failed a key that would otherwise be fine
+	if res == "" {
+		res = SEVERITY_LOW
+	}
+	return res
+}
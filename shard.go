@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"strings"
+)
+
+// One of N slices of the service accounts, so that N jobs can each scan a slice
+type Shard struct {
+	index int
+	count int
+}
+
+// Parses K/N, where K is from 0 to N-1 to match task indexes like CLOUD_RUN_TASK_INDEX
+func parseShard(s string) (Shard, error) {
+	k, n, found := strings.Cut(s, "/")
+	if !found {
+		return Shard{}, fmt.Errorf("invalid shard %q, must be K/N", s)
+	}
+	index, err := strconv.Atoi(k)
+	if err != nil {
+		return Shard{}, fmt.Errorf("invalid shard %q, must be K/N: %v", s, err)
+	}
+	count, err := strconv.Atoi(n)
+	if err != nil {
+		return Shard{}, fmt.Errorf("invalid shard %q, must be K/N: %v", s, err)
+	}
+	if count < 1 || index < 0 || index >= count {
+		return Shard{}, fmt.Errorf("invalid shard %q, K must be from 0 to N-1", s)
+	}
+	return Shard{index: index, count: count}, nil
+}
+
+// Service accounts are assigned by a hash of their email rather than their position, so every job agrees on the
+// shards even if they enumerated the service accounts in a different order
+func (s Shard) contains(sa string) bool {
+	h := fnv.New32a()
+	h.Write([]byte(sa))
+	return int(h.Sum32()%uint32(s.count)) == s.index
+}
+
+func (s Shard) filter(serviceAccountIDs []string) []string {
+	var res []string
+	for _, sa := range serviceAccountIDs {
+		if s.contains(sa) {
+			res = append(res, sa)
+		}
+	}
+	return res
+}
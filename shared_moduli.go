@@ -0,0 +1,76 @@
+package main
+
+import (
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"maps"
+	"slices"
+)
+
+type KeyRef struct {
+	ServiceAccount string `json:"serviceAccount"`
+	KeyID          string `json:"keyId"`
+}
+
+// Keys which have the same RSA modulus, so they share a private key. GCP generates a new key pair for every key, so
+// this means the same key was uploaded more than once, eg. copy-pasted between service accounts
+type SharedModulusReport struct {
+	ModulusSHA256 string   `json:"modulusSha256"`
+	Keys          []KeyRef `json:"keys"`
+}
+
+// The SHA-256 of the RSA modulus in hex, empty for other keys
+func modulusSHA256(cert *x509.Certificate) string {
+	publicKey, ok := cert.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return ""
+	}
+	sum := sha256.Sum256(publicKey.N.Bytes())
+	return hex.EncodeToString(sum[:])
+}
+
+// Remembers the modulus of the key, to find the keys sharing it once every batch has been analyzed. counts is whether
+// the key can fail the run, ie. it isn't suppressed and this isn't a ground truth run
+func (s *Scan) addModulus(key *SAKey, counts bool) {
+	modulus := modulusSHA256(key.cert)
+	if modulus == "" {
+		return
+	}
+	s.moduli[modulus] = append(s.moduli[modulus], KeyRef{ServiceAccount: key.serviceAccount, KeyID: key.keyID})
+	if counts {
+		s.unsuppressedModuli[modulus] = true
+	}
+}
+
+// A private key which was uploaded more than once is as bad as a high severity key, so the shared moduli fail the run
+// with --fail-on high or lower, unless every key sharing the modulus is suppressed
+func (s *Scan) sharedModuliFail(shared []SharedModulusReport) bool {
+	return severityAtLeast(SEVERITY_HIGH, s.failOnSeverity) && slices.ContainsFunc(shared, func(m SharedModulusReport) bool { return s.unsuppressedModuli[m.ModulusSHA256] })
+}
+
+// The moduli shared by more than one key, in a stable order
+func (s *Scan) sharedModuli() []SharedModulusReport {
+	var res []SharedModulusReport
+	for _, modulus := range slices.Sorted(maps.Keys(s.moduli)) {
+		if keys := s.moduli[modulus]; len(keys) > 1 {
+			res = append(res, SharedModulusReport{ModulusSHA256: modulus, Keys: keys})
+		}
+	}
+	return res
+}
+
+func printSharedModuli(shared []SharedModulusReport) {
+	if len(shared) == 0 {
+		return
+	}
+	fmt.Printf("Found %d RSA moduli shared by more than one key, so the same private key was uploaded more than once:\n", len(shared))
+	for _, m := range shared {
+		fmt.Printf("  Modulus SHA-256 %v:\n", m.ModulusSHA256)
+		for _, key := range m.Keys {
+			fmt.Printf("    %v key %v\n", key.ServiceAccount, key.KeyID)
+		}
+	}
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+const CHANGE_NEW_KEY = "new since the last scan"
+
+// What a --state file records about each key, to compare the next run with
+type KeySnapshot struct {
+	KeyKind string `json:"keyKind"`
+	Bad     bool   `json:"bad"`
+	// the categories, sorted
+	Findings []string `json:"findings"`
+	// whether a --baseline, --ignore-file entry or annotation accepted the key
+	Suppressed bool `json:"suppressed,omitempty"`
+}
+
+// The keys and their classification as of the last run with --state
+type Snapshot struct {
+	ScannedAt time.Time `json:"scannedAt"`
+	// service account -> key ID -> key
+	ServiceAccounts map[string]map[string]KeySnapshot `json:"serviceAccounts"`
+}
+
+// How the results differ from the previous --state
+type SnapshotDelta struct {
+	NewKeys     int `json:"newKeys"`
+	ChangedKeys int `json:"changedKeys"`
+	RemovedKeys int `json:"removedKeys"`
+	// service accounts in the previous state which weren't found by this run, see removedServiceAccounts
+	RemovedServiceAccounts []string `json:"removedServiceAccounts,omitempty"`
+}
+
+// Returns nil if there is no state file yet, ie. on the first run
+func loadSnapshot(path string) (*Snapshot, error) {
+	b, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading state %v: %v", path, err)
+	}
+	var snapshot Snapshot
+	if err := json.Unmarshal(b, &snapshot); err != nil {
+		return nil, fmt.Errorf("error parsing state %v: %v", path, err)
+	}
+	if snapshot.ServiceAccounts == nil {
+		return nil, fmt.Errorf("error parsing state %v: missing serviceAccounts", path)
+	}
+	return &snapshot, nil
+}
+
+// Written atomically, so an interruption never loses the previous state
+func (s *Snapshot) save(path string) error {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(path, data); err != nil {
+		return fmt.Errorf("unable to write state: %v", err)
+	}
+	return nil
+}
+
+func keySnapshot(k *SAKey) KeySnapshot {
+	findings := []string{}
+	for _, finding := range k.findings {
+		findings = append(findings, finding.category)
+	}
+	slices.Sort(findings)
+	return KeySnapshot{
+		KeyKind:    k.keyKind,
+		Bad:        k.isBad(),
+		Findings:   slices.Compact(findings),
+		Suppressed: k.suppression != nil,
+	}
+}
+
+// Describes how the key changed since the snapshot, empty if it didn't
+func (s *Snapshot) change(k *SAKey) string {
+	previous, ok := s.ServiceAccounts[k.serviceAccount][k.keyID]
+	if !ok {
+		return CHANGE_NEW_KEY
+	}
+	current := keySnapshot(k)
+	var changes []string
+	if previous.KeyKind != current.KeyKind {
+		changes = append(changes, fmt.Sprintf("was %v", previous.KeyKind))
+	}
+	if previous.Bad != current.Bad {
+		if current.Bad {
+			changes = append(changes, "was not bad")
+		} else {
+			changes = append(changes, "was bad")
+		}
+	}
+	if previous.Suppressed != current.Suppressed {
+		if current.Suppressed {
+			changes = append(changes, "was not suppressed")
+		} else {
+			changes = append(changes, "was suppressed")
+		}
+	}
+	if !slices.Equal(previous.Findings, current.Findings) {
+		changes = append(changes, fmt.Sprintf("had findings [%v]", strings.Join(previous.Findings, ", ")))
+	}
+	if len(changes) == 0 {
+		return ""
+	}
+	return "changed since the last scan, " + strings.Join(changes, ", ")
+}
+
+// The keys of the service account which were in the snapshot, but aren't any more, sorted
+func (s *Snapshot) removedKeys(serviceAccount string, keys []*SAKey) []string {
+	var res []string
+	for _, keyID := range slices.Sorted(maps.Keys(s.ServiceAccounts[serviceAccount])) {
+		if !slices.ContainsFunc(keys, func(k *SAKey) bool { return k.keyID == keyID }) {
+			res = append(res, keyID)
+		}
+	}
+	return res
+}
+
+// The service accounts in the previous state which weren't found by this run, sorted. A run with --state usually
+// covers the same projects every time, so only the ones in the projects of the service accounts scanned this time are
+// taken as deleted, the ones in other projects are left alone like the service accounts which weren't scanned
+func (s *Snapshot) removedServiceAccounts(scanned []string) []string {
+	projects := map[string]bool{}
+	for _, sa := range scanned {
+		projects[projectFromServiceAccount(sa)] = true
+	}
+	var res []string
+	for _, sa := range slices.Sorted(maps.Keys(s.ServiceAccounts)) {
+		if project := projectFromServiceAccount(sa); project != "" && projects[project] && !slices.Contains(scanned, sa) {
+			res = append(res, sa)
+		}
+	}
+	return res
+}
+
+// A snapshot to record the current run in, starting from the previous one so that service accounts which aren't
+// scanned this time are kept
+func NewSnapshot(previous *Snapshot, now time.Time) *Snapshot {
+	res := &Snapshot{
+		ScannedAt:       now,
+		ServiceAccounts: map[string]map[string]KeySnapshot{},
+	}
+	if previous != nil {
+		maps.Copy(res.ServiceAccounts, previous.ServiceAccounts)
+	}
+	return res
+}
+
+// The service accounts which were deleted, so they aren't reported as removed again by the next run
+func (s *Snapshot) remove(serviceAccounts []string) {
+	for _, sa := range serviceAccounts {
+		delete(s.ServiceAccounts, sa)
+	}
+}
+
+// Replaces what the snapshot has for the service account with its current keys
+func (s *Snapshot) update(serviceAccount string, keys []*SAKey) {
+	current := map[string]KeySnapshot{}
+	for _, k := range keys {
+		current[k.keyID] = keySnapshot(k)
+	}
+	s.ServiceAccounts[serviceAccount] = current
+}
+
+func (d *SnapshotDelta) print(previous *Snapshot) {
+	fmt.Printf("Since the last scan at %v: %d new keys, %d changed keys, %d removed keys\n", previous.ScannedAt.Format(time.RFC3339), d.NewKeys, d.ChangedKeys, d.RemovedKeys)
+	if len(d.RemovedServiceAccounts) > 0 {
+		fmt.Printf("Service accounts removed since the last scan: %d\n", len(d.RemovedServiceAccounts))
+		for _, sa := range d.RemovedServiceAccounts {
+			fmt.Printf("  %v\n", sa)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+)
+
+// The progress of a scan, written after each batch so an interrupted scan can be resumed with --resume
+type State struct {
+	// service accounts whose results are in the state, those which were skipped are left out so they are retried
+	Completed   []string `json:"completed"`
+	Good        int      `json:"good"`
+	Bad         int      `json:"bad"`
+	Warned      int      `json:"warned"`
+	Unknown     int      `json:"unknown"`
+	Suppressed  int      `json:"suppressed"`
+	Failed      bool     `json:"failed"`
+	FailingKeys int      `json:"failingKeys"`
+	Report      *Report  `json:"report"`
+	Stats       *Stats   `json:"stats"`
+	// SHA-256 of each RSA modulus -> the keys with it
+	Moduli             map[string][]KeyRef `json:"moduli"`
+	UnsuppressedModuli map[string]bool     `json:"unsuppressedModuli,omitempty"`
+	// only with --rank-by-privilege
+	Ranked []RankedKeyReport `json:"ranked,omitempty"`
+	// only with --state
+	Snapshot *Snapshot     `json:"snapshot,omitempty"`
+	Delta    SnapshotDelta `json:"delta"`
+}
+
+// Does nothing if there is no state file yet, ie. on the first run
+func (s *Scan) loadState(path string) error {
+	b, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error reading state %v: %v", path, err)
+	}
+
+	var state State
+	if err := json.Unmarshal(b, &state); err != nil {
+		return fmt.Errorf("error parsing state %v: %v", path, err)
+	}
+	if state.Report == nil || state.Stats == nil || state.Stats.KeysByKind == nil {
+		return fmt.Errorf("error parsing state %v: missing report or stats", path)
+	}
+
+	s.completed = state.Completed
+	s.good = state.Good
+	s.bad = state.Bad
+	s.warned = state.Warned
+	s.unknown = state.Unknown
+	s.suppressed = state.Suppressed
+	s.failed = state.Failed
+	s.failingKeys = state.FailingKeys
+	s.report = state.Report
+	s.stats.merge(state.Stats)
+	if state.Moduli != nil {
+		s.moduli = state.Moduli
+	}
+	if state.UnsuppressedModuli != nil {
+		s.unsuppressedModuli = state.UnsuppressedModuli
+	}
+	s.ranked = state.Ranked
+	if state.Snapshot != nil && s.snapshot != nil {
+		s.snapshot = state.Snapshot
+	}
+	s.delta = state.Delta
+	return nil
+}
+
+// Written atomically, so an interruption never leaves a partial state
+func (s *Scan) saveState(path string) error {
+	data, err := json.Marshal(State{
+		Completed:          s.completed,
+		Good:               s.good,
+		Bad:                s.bad,
+		Warned:             s.warned,
+		Unknown:            s.unknown,
+		Suppressed:         s.suppressed,
+		Failed:             s.failed,
+		FailingKeys:        s.failingKeys,
+		Report:             s.report,
+		Stats:              s.stats,
+		Moduli:             s.moduli,
+		UnsuppressedModuli: s.unsuppressedModuli,
+		Ranked:             s.ranked,
+		Snapshot:           s.snapshot,
+		Delta:              s.delta,
+	})
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(path, data); err != nil {
+		return fmt.Errorf("unable to write state: %v", err)
+	}
+	return nil
+}
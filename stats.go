@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"time"
+)
+
+// Aggregate statistics over all the scanned keys, for reporting on the overall state of an organization
+type Stats struct {
+	KeysByKind      map[string]int `json:"keysByKind"`
+	ServiceAccounts int            `json:"serviceAccounts"`
+	// service accounts with no keys at all are counted in this too
+	ServiceAccountsWithOnlySystemManagedKeys int `json:"serviceAccountsWithOnlySystemManagedKeys"`
+	ServiceAccountsWithUserManagedKeys       int `json:"serviceAccountsWithUserManagedKeys"`
+	UserManagedKeys                          int `json:"userManagedKeys"`
+	// ages are from NotBefore, in days
+	AverageUserManagedKeyAgeDays float64 `json:"averageUserManagedKeyAgeDays"`
+	OldestUserManagedKeyAgeDays  float64 `json:"oldestUserManagedKeyAgeDays"`
+	totalUserManagedKeyAge       time.Duration
+}
+
+func NewStats() *Stats {
+	return &Stats{
+		KeysByKind: map[string]int{},
+	}
+}
+
+// keys must all be for the same service account, after determineKeyKind
+func (s *Stats) addServiceAccount(keys []*SAKey, now time.Time) {
+	kinds := make([]string, len(keys))
+	notBefores := make([]time.Time, len(keys))
+	for i, key := range keys {
+		kinds[i], notBefores[i] = key.keyKind, key.cert.NotBefore
+	}
+	s.add(kinds, notBefores, now)
+}
+
+// Like addServiceAccount, for the keys of a --report
+func (s *Stats) addKeyReports(keys []KeyReport, now time.Time) {
+	kinds := make([]string, len(keys))
+	notBefores := make([]time.Time, len(keys))
+	for i, key := range keys {
+		kinds[i], notBefores[i] = key.KeyKind, key.NotBefore
+	}
+	s.add(kinds, notBefores, now)
+}
+
+// The kind and NotBefore of each key of one service account
+func (s *Stats) add(kinds []string, notBefores []time.Time, now time.Time) {
+	s.ServiceAccounts++
+	userManaged := false
+	for i, kind := range kinds {
+		s.KeysByKind[kind]++
+		if !isUserManaged(kind) {
+			continue
+		}
+		userManaged = true
+		s.UserManagedKeys++
+		age := now.Sub(notBefores[i])
+		s.totalUserManagedKeyAge += age
+		s.OldestUserManagedKeyAgeDays = max(s.OldestUserManagedKeyAgeDays, age.Hours()/24)
+	}
+	if userManaged {
+		s.ServiceAccountsWithUserManagedKeys++
+	} else {
+		s.ServiceAccountsWithOnlySystemManagedKeys++
+	}
+	if s.UserManagedKeys > 0 {
+		s.AverageUserManagedKeyAgeDays = s.totalUserManagedKeyAge.Hours() / 24 / float64(s.UserManagedKeys)
+	}
+}
+
+func (s *Stats) print() {
+	fmt.Println("Keys by kind:")
+	for _, kind := range slices.Concat(keyKindPrecedence, []string{KEY_KIND_UNKNOWN}) {
+		if n := s.KeysByKind[kind]; n > 0 || kind != KEY_KIND_UNKNOWN {
+			fmt.Printf("  %-32v %d\n", kind, n)
+		}
+	}
+	fmt.Printf("SAs with only system managed keys: %d of %d\n", s.ServiceAccountsWithOnlySystemManagedKeys, s.ServiceAccounts)
+	fmt.Printf("SAs with user managed keys: %d of %d\n", s.ServiceAccountsWithUserManagedKeys, s.ServiceAccounts)
+	if s.UserManagedKeys > 0 {
+		fmt.Printf("User managed key age: average %.0f days, oldest %.0f days\n", s.AverageUserManagedKeyAgeDays, s.OldestUserManagedKeyAgeDays)
+	}
+}
+
+// Adds the stats of another run, like another shard of the same scan
+func (s *Stats) merge(o *Stats) {
+	for kind, n := range o.KeysByKind {
+		s.KeysByKind[kind] += n
+	}
+	s.ServiceAccounts += o.ServiceAccounts
+	s.ServiceAccountsWithOnlySystemManagedKeys += o.ServiceAccountsWithOnlySystemManagedKeys
+	s.ServiceAccountsWithUserManagedKeys += o.ServiceAccountsWithUserManagedKeys
+	s.totalUserManagedKeyAge += time.Duration(o.AverageUserManagedKeyAgeDays * float64(o.UserManagedKeys) * float64(24*time.Hour))
+	s.UserManagedKeys += o.UserManagedKeys
+	s.OldestUserManagedKeyAgeDays = max(s.OldestUserManagedKeyAgeDays, o.OldestUserManagedKeyAgeDays)
+	if s.UserManagedKeys > 0 {
+		s.AverageUserManagedKeyAgeDays = s.totalUserManagedKeyAge.Hours() / 24 / float64(s.UserManagedKeys)
+	}
+}
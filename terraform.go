@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+const TERRAFORM_KEY_RESOURCE = "google_service_account_key"
+const TERRAFORM_SERVICE_ACCOUNT_RESOURCE = "google_service_account"
+
+// The service account keys managed by Terraform, from --terraform-state files
+type TerraformState struct {
+	// service account -> key ID -> the address of the resource, like module.ci.google_service_account_key.deployer
+	keys map[string]map[string]string
+	// the projects with any service account or key in the states, only the keys in these are cross-checked, as the
+	// states usually cover some projects rather than the whole organization
+	projects map[string]bool
+}
+
+// The parts of a state file (format version 4, like from terraform state pull) which are needed
+type terraformStateFile struct {
+	Version   int `json:"version"`
+	Resources []struct {
+		Module    string `json:"module"`
+		Mode      string `json:"mode"`
+		Type      string `json:"type"`
+		Name      string `json:"name"`
+		Instances []struct {
+			IndexKey   any `json:"index_key"`
+			Attributes struct {
+				ID               string `json:"id"`
+				Name             string `json:"name"`
+				ServiceAccountID string `json:"service_account_id"`
+				Email            string `json:"email"`
+				Project          string `json:"project"`
+			} `json:"attributes"`
+		} `json:"instances"`
+	} `json:"resources"`
+}
+
+// Like module.ci.google_service_account_key.deployer["prod"]
+func terraformAddress(module string, resourceType string, name string, indexKey any) string {
+	address := resourceType + "." + name
+	if module != "" {
+		address = module + "." + address
+	}
+	switch k := indexKey.(type) {
+	case float64:
+		address += fmt.Sprintf("[%d]", int(k))
+	case string:
+		address += fmt.Sprintf("[%q]", k)
+	}
+	return address
+}
+
+// The name of a key is projects/PROJECT/serviceAccounts/EMAIL/keys/KEY_ID
+func parseKeyResourceName(name string) (serviceAccount string, keyID string, ok bool) {
+	parts := strings.Split(name, "/")
+	if len(parts) != 6 || parts[0] != "projects" || parts[2] != "serviceAccounts" || parts[4] != "keys" {
+		return "", "", false
+	}
+	return strings.ToLower(parts[3]), parts[5], true
+}
+
+// Paths of state files, - for stdin like terraform state pull | gcp-sa-key-checker --terraform-state -
+func loadTerraformStates(paths []string) (*TerraformState, error) {
+	res := &TerraformState{keys: map[string]map[string]string{}, projects: map[string]bool{}}
+	for _, path := range paths {
+		var data []byte
+		var err error
+		if path == "-" {
+			data, err = io.ReadAll(os.Stdin)
+		} else {
+			data, err = os.ReadFile(path)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading --terraform-state %v: %v", path, err)
+		}
+		if err := res.add(data); err != nil {
+			return nil, fmt.Errorf("invalid --terraform-state %v: %v", path, err)
+		}
+	}
+	return res, nil
+}
+
+func (t *TerraformState) add(data []byte) error {
+	var state terraformStateFile
+	if err := json.Unmarshal(data, &state); err != nil {
+		return err
+	}
+	if state.Version != 4 {
+		return fmt.Errorf("unsupported state format version %d, must be 4", state.Version)
+	}
+	for _, resource := range state.Resources {
+		if resource.Mode != "managed" {
+			continue
+		}
+		for _, instance := range resource.Instances {
+			switch resource.Type {
+			case TERRAFORM_SERVICE_ACCOUNT_RESOURCE:
+				if project := projectFromServiceAccount(strings.ToLower(instance.Attributes.Email)); project != "" {
+					t.projects[project] = true
+				}
+			case TERRAFORM_KEY_RESOURCE:
+				sa, keyID, ok := parseKeyResourceName(instance.Attributes.ID)
+				if !ok {
+					sa, keyID, ok = parseKeyResourceName(instance.Attributes.Name)
+				}
+				if !ok {
+					continue
+				}
+				if t.keys[sa] == nil {
+					t.keys[sa] = map[string]string{}
+				}
+				t.keys[sa][keyID] = terraformAddress(resource.Module, resource.Type, resource.Name, instance.IndexKey)
+				if project := projectFromServiceAccount(sa); project != "" {
+					t.projects[project] = true
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// Whether the keys of the service account are expected to be in the states
+func (t *TerraformState) covers(serviceAccount string) bool {
+	return t.projects[projectFromServiceAccount(serviceAccount)] || t.keys[serviceAccount] != nil
+}
+
+// Writes a shell script with the gcloud commands for every key with a NOT_IN_TERRAFORM warning in the report, which
+// disable the keys and, once nothing broke, delete them. The keys can't be imported instead, as the Google provider
+// doesn't support importing google_service_account_key
+func writeTerraformUnmanagedScript(path string, report *Report) error {
+	var b strings.Builder
+	b.WriteString("#!/bin/sh\n")
+	b.WriteString("# The user managed keys which aren't in the Terraform state, generated by " + TOOL_NAME + "\n")
+	b.WriteString("# Disables each key, uncomment the delete commands once nothing broke\n")
+	b.WriteString("set -e\n")
+	for _, project := range report.Projects {
+		for _, sa := range project.ServiceAccounts {
+			for _, key := range sa.Keys {
+				if !slices.ContainsFunc(key.Findings, func(f FindingReport) bool { return f.Category == FINDING_TERRAFORM }) {
+					continue
+				}
+				commands := remediationCommands(key)
+				if commands == nil {
+					continue
+				}
+				fmt.Fprintf(&b, "\n# %v of %v, %v, created %v\n", key.KeyID, key.ServiceAccount, key.KeyKind, key.NotBefore.Format(time.DateOnly))
+				fmt.Fprintf(&b, "%v\n# %v\n", commands[0], commands[1])
+			}
+		}
+	}
+	if err := os.WriteFile(path, redactor.redactBytes([]byte(b.String())), 0644); err != nil {
+		return fmt.Errorf("unable to write the script for the keys which aren't in Terraform: %v", err)
+	}
+	return nil
+}
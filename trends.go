@@ -0,0 +1,152 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"time"
+)
+
+// The week of t, like 2024-W07
+func isoWeek(t time.Time) string {
+	year, week := t.ISOWeek()
+	return fmt.Sprintf("%d-W%02d", year, week)
+}
+
+func isUserManaged(keyKind string) bool {
+	return keyKind == GOOGLE_PROVIDED_USER_MANAGED || keyKind == USER_PROVIDED_USER_MANAGED
+}
+
+// Keys by kind as of the end of each day with a run. A service account which wasn't scanned on a day is counted with
+// its keys as of its last run, so per team or sharded runs on different days still add up to the whole organization
+func keyCountsByDay(records []HistoryRecord) ([]string, map[string]map[string]int) {
+	latest := map[string]HistoryRecord{}
+	var days []string
+	counts := map[string]map[string]int{}
+	for i, record := range records {
+		latest[record.ServiceAccount] = record
+		day := record.ScannedAt.UTC().Format(time.DateOnly)
+		if i+1 < len(records) && records[i+1].ScannedAt.UTC().Format(time.DateOnly) == day {
+			continue
+		}
+		days = append(days, day)
+		counts[day] = map[string]int{}
+		for _, r := range latest {
+			for _, key := range r.Keys {
+				counts[day][key.KeyKind]++
+			}
+		}
+	}
+	return days, counts
+}
+
+// trends -history FILE [-since DATE]
+// Summarizes a --history file: the keys of each kind over time, the new user managed keys per week, and how long it
+// took for bad keys to be removed (or stop being bad)
+func trendsCommand(args []string) {
+	fs := flag.NewFlagSet("trends", flag.ExitOnError)
+	path := fs.String("history", "", "The --history file of earlier runs")
+	since := fs.String("since", "", "Only summarize from this date (YYYY-MM-DD), like the start of the quarter")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %v trends -history FILE [-since YYYY-MM-DD]\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if *path == "" || fs.NArg() > 0 {
+		fs.Usage()
+		os.Exit(EXIT_FATAL)
+	}
+	var from time.Time
+	if *since != "" {
+		var err error
+		from, err = time.Parse(time.DateOnly, *since)
+		if err != nil {
+			fatal(fmt.Sprintf("invalid -since %v, must be YYYY-MM-DD", *since))
+		}
+	}
+
+	records, err := readHistory(*path, func(*HistoryRecord) bool { return true })
+	if err != nil {
+		fatal(err.Error())
+	}
+	if len(records) == 0 {
+		fmt.Println("The history is empty")
+		os.Exit(EXIT_OK)
+	}
+	slices.SortStableFunc(records, func(a, b HistoryRecord) int { return a.ScannedAt.Compare(b.ScannedAt) })
+
+	kinds := slices.Concat(keyKindPrecedence, []string{KEY_KIND_UNKNOWN})
+	fmt.Println("Keys by kind:")
+	fmt.Printf("  %-10v", "Date")
+	for _, kind := range kinds {
+		fmt.Printf("  %v", kind)
+	}
+	fmt.Println()
+	days, counts := keyCountsByDay(records)
+	for _, day := range days {
+		if day < from.Format(time.DateOnly) {
+			continue
+		}
+		fmt.Printf("  %-10v", day)
+		for _, kind := range kinds {
+			fmt.Printf("  %*d", len(kind), counts[day][kind])
+		}
+		fmt.Println()
+	}
+
+	bySA := map[string][]HistoryRecord{}
+	for _, record := range records {
+		bySA[record.ServiceAccount] = append(bySA[record.ServiceAccount], record)
+	}
+	newPerWeek := map[string]int{}
+	var remediated []time.Duration
+	open := 0
+	for _, sa := range slices.Sorted(maps.Keys(bySA)) {
+		saRecords := bySA[sa]
+		for _, h := range keyHistories(saRecords) {
+			// keys which were there in the first run of the service account existed before the history started
+			if h.firstSeen.After(saRecords[0].ScannedAt) && !h.firstSeen.Before(from) && isUserManaged(h.events[0].key.KeyKind) {
+				newPerWeek[isoWeek(h.firstSeen)]++
+			}
+
+			var badSince time.Time
+			for _, event := range h.events {
+				bad := event.key != nil && event.key.Bad
+				if bad && badSince.IsZero() {
+					badSince = event.at
+				} else if !bad && !badSince.IsZero() {
+					if !event.at.Before(from) {
+						remediated = append(remediated, event.at.Sub(badSince))
+					}
+					badSince = time.Time{}
+				}
+			}
+			if !badSince.IsZero() {
+				open++
+			}
+		}
+	}
+
+	fmt.Println("New user managed keys per week:")
+	if len(newPerWeek) == 0 {
+		fmt.Println("  none")
+	}
+	for _, week := range slices.Sorted(maps.Keys(newPerWeek)) {
+		fmt.Printf("  %v: %d\n", week, newPerWeek[week])
+	}
+
+	if len(remediated) == 0 {
+		fmt.Println("Remediated bad keys: 0")
+	} else {
+		var total time.Duration
+		for _, d := range remediated {
+			total += d
+		}
+		mean := total / time.Duration(len(remediated))
+		fmt.Printf("Remediated bad keys: %d, mean time to remediation %.1f days\n", len(remediated), mean.Hours()/24)
+	}
+	fmt.Printf("Bad keys still open: %d\n", open)
+	os.Exit(EXIT_OK)
+}
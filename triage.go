@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// triage -sa EMAIL -key-id ID [-ground-truth]
+// Answers the questions asked about a possibly leaked key: does it still exist, what kind is it, when does it expire,
+// and with the ground truth, when was it created and is it disabled
+func triageCommand(args []string) {
+	fs := flag.NewFlagSet("triage", flag.ExitOnError)
+	sa := fs.String("sa", "", "The service account email")
+	keyID := fs.String("key-id", "", "The key ID, like the private_key_id of a key file or the kid of a JWT")
+	withGroundTruth := fs.Bool("ground-truth", false, "Also look up the key with the IAM API, for when it was created and whether it is disabled. Needs iam.serviceAccountKeys.list")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %v triage -sa EMAIL -key-id ID [-ground-truth]\n", os.Args[0])
+		fmt.Fprintln(fs.Output(), "Exits with 1 if the key is still active")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	serviceAccount := strings.ToLower(strings.TrimSpace(*sa))
+	if !SERVICE_ACCOUNT_EMAIL.MatchString(serviceAccount) || *keyID == "" || fs.NArg() > 0 {
+		fs.Usage()
+		os.Exit(EXIT_FATAL)
+	}
+
+	ctx := context.Background()
+	printServiceAccountHeader(serviceAccount, nil)
+	key, err := lookupKey(ctx, serviceAccount, *keyID, nil)
+	if err != nil {
+		fatal(err.Error())
+	}
+	if key == nil {
+		fmt.Printf("  Key ID: %v - not published on the x509 endpoint\n", *keyID)
+	} else {
+		key.dump("  ", true)
+		if key.cert.NotAfter == defaultMaxAfter {
+			fmt.Println("  Expires: never")
+		} else {
+			fmt.Printf("  Expires: %v\n", key.cert.NotAfter)
+		}
+	}
+
+	if *withGroundTruth {
+		keys, err := getServiceAccountKeys(ctx, iamService(), serviceAccount, "")
+		if err != nil {
+			fatal(fmt.Sprintf("error getting the keys from the IAM API: %v", err))
+		}
+		if realKey := keys[*keyID]; realKey == nil {
+			fmt.Println("  IAM API: the key doesn't exist, it has been deleted")
+		} else {
+			fmt.Printf("  IAM API: %v/%v, created %v, valid until %v\n", realKey.KeyOrigin, realKey.KeyType, realKey.ValidAfterTime, realKey.ValidBeforeTime)
+			if realKey.Disabled {
+				fmt.Println("  IAM API: the key is disabled")
+			}
+		}
+	}
+
+	switch keyStatus(key, time.Now()) {
+	case KEY_STATUS_ACTIVE:
+		fmt.Println("Status: ACTIVE - the key is published, so it can still be used to authenticate. Delete it if it has leaked")
+		os.Exit(EXIT_FINDINGS)
+	case KEY_STATUS_EXPIRED:
+		fmt.Printf("Status: EXPIRED - the key is still published, but expired on %v so it can't be used to authenticate\n", key.cert.NotAfter)
+	default:
+		fmt.Println("Status: INACTIVE - the key is not published, so it has been deleted or disabled (or the service account has)")
+	}
+	os.Exit(EXIT_OK)
+}
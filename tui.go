@@ -0,0 +1,242 @@
+package main
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Interactive browser of the results of a run for --tui, going from the projects to their service accounts, to their
+// keys, to the signals and findings of a key. It reads one command per line, so it works in any terminal
+type Browser struct {
+	report *Report
+	// a substring of the key kinds to show (case insensitive), "bad" for only the bad keys, empty for every key
+	filter string
+	// the indexes of the project, service account and key which are open, -1 if none
+	project        int
+	serviceAccount int
+	key            int
+	// the rows of the current level, which the numbers typed refer to
+	rows int
+}
+
+func NewBrowser(report *Report) *Browser {
+	return &Browser{report: report, project: -1, serviceAccount: -1, key: -1}
+}
+
+func (b *Browser) matches(key KeyReport) bool {
+	switch b.filter {
+	case "":
+		return true
+	case "bad":
+		return key.Bad
+	}
+	return strings.Contains(strings.ToLower(key.KeyKind), b.filter)
+}
+
+func (b *Browser) keys(sa *ServiceAccountGroup) []KeyReport {
+	var res []KeyReport
+	for _, key := range sa.Keys {
+		if b.matches(key) {
+			res = append(res, key)
+		}
+	}
+	return res
+}
+
+func (b *Browser) serviceAccounts(project *ProjectGroup) []*ServiceAccountGroup {
+	var res []*ServiceAccountGroup
+	for _, sa := range project.ServiceAccounts {
+		if b.filter == "" || len(b.keys(sa)) > 0 {
+			res = append(res, sa)
+		}
+	}
+	return res
+}
+
+func (b *Browser) projects() []*ProjectGroup {
+	var res []*ProjectGroup
+	for _, project := range b.report.Projects {
+		if len(b.serviceAccounts(project)) > 0 {
+			res = append(res, project)
+		}
+	}
+	return res
+}
+
+// The gcloud commands to get rid of the key, disabling it first so the removal can be undone if something breaks
+func remediationCommands(key KeyReport) []string {
+	if key.KeyKind == GOOGLE_PROVIDED_SYSTEM_MANAGED {
+		return nil
+	}
+	return []string{
+		fmt.Sprintf("gcloud iam service-accounts keys disable %v --iam-account %v", key.KeyID, key.ServiceAccount),
+		fmt.Sprintf("gcloud iam service-accounts keys delete %v --iam-account %v", key.KeyID, key.ServiceAccount),
+	}
+}
+
+// With the OSC 52 escape sequence, which most terminals (and tmux, and ssh sessions) put on the clipboard
+func copyToClipboard(w io.Writer, s string) {
+	fmt.Fprintf(w, "\033]52;c;%v\a", base64.StdEncoding.EncodeToString([]byte(s)))
+}
+
+func (b *Browser) print(w io.Writer) {
+	filter := "all keys"
+	if b.filter != "" {
+		filter = "keys matching " + b.filter
+	}
+	projects := b.projects()
+	switch {
+	case b.project < 0:
+		fmt.Fprintf(w, "Projects (%v):\n", filter)
+		for i, project := range projects {
+			name := project.Project
+			if name == "" {
+				name = "(unknown project)"
+			}
+			fmt.Fprintf(w, "  %d. %v: good %d, bad %d\n", i+1, name, project.Good, project.Bad)
+		}
+		b.rows = len(projects)
+	case b.serviceAccount < 0:
+		serviceAccounts := b.serviceAccounts(projects[b.project])
+		fmt.Fprintf(w, "Service accounts of %v (%v):\n", projects[b.project].Project, filter)
+		for i, sa := range serviceAccounts {
+			status := "good"
+			if sa.Bad {
+				status = colorize(COLOR_RED, "bad")
+			}
+			fmt.Fprintf(w, "  %d. %v: %v, %d keys\n", i+1, sa.ServiceAccount, status, len(b.keys(sa)))
+		}
+		b.rows = len(serviceAccounts)
+	case b.key < 0:
+		sa := b.serviceAccounts(projects[b.project])[b.serviceAccount]
+		keys := b.keys(sa)
+		fmt.Fprintf(w, "Keys of %v (%v):\n", sa.ServiceAccount, filter)
+		for _, warning := range sa.Warnings {
+			fmt.Fprintf(w, "  %v %v\n", colorize(COLOR_YELLOW, "Warning:"), warning)
+		}
+		for i, key := range keys {
+			status := "good"
+			if key.Bad {
+				status = "bad, " + colorize(severityColor(key.Severity), key.Severity)
+			}
+			age := humanDuration(time.Since(key.NotBefore)) + " old"
+			if time.Now().Before(key.NotBefore) {
+				age = "valid in " + humanDuration(time.Until(key.NotBefore))
+			}
+			fmt.Fprintf(w, "  %d. %v: %v (%v), %v, valid from %v until %v\n", i+1, key.KeyID, colorize(keyKindColor(key.KeyKind), key.KeyKind), status, age, key.NotBefore.Format(time.DateOnly), key.NotAfter.Format(time.DateOnly))
+		}
+		b.rows = len(keys)
+	default:
+		key := b.keys(b.serviceAccounts(projects[b.project])[b.serviceAccount])[b.key]
+		fmt.Fprintf(w, "Key %v of %v: %v (confidence %.2f)\n", key.KeyID, key.ServiceAccount, colorize(keyKindColor(key.KeyKind), key.KeyKind), key.Confidence)
+		if key.Bad {
+			fmt.Fprintf(w, "  Severity: %v\n", colorize(severityColor(key.Severity), key.Severity))
+		}
+		if key.Suppressed != nil {
+			fmt.Fprintf(w, "  Suppressed: %v\n", key.Suppressed.Reason)
+		}
+		for _, finding := range key.Findings {
+			kind := "Finding"
+			if finding.Warning {
+				kind = "Warning"
+			}
+			fmt.Fprintf(w, "  %v %v: %v\n", kind, finding.Category, finding.Explanation)
+		}
+		for _, signal := range key.Signals {
+			fmt.Fprintf(w, "  Signal for %v from %v: %v\n", signal.KeyKind, signal.Checker, signal.Explanation)
+		}
+		if commands := remediationCommands(key); commands != nil {
+			fmt.Fprintln(w, "  Remediation (c to copy):")
+			for _, command := range commands {
+				fmt.Fprintf(w, "    %v\n", command)
+			}
+		}
+		b.rows = 0
+	}
+}
+
+const browserHelp = `Commands:
+  N             open row N
+  b             go back up
+  f TEXT        only show keys whose kind contains TEXT (like user_provided), f bad for the bad keys, f to show all
+  c             copy the remediation commands of the open key to the clipboard
+  q             quit
+`
+
+// Runs until q or the end of the input
+func (b *Browser) run(in io.Reader, w io.Writer) {
+	fmt.Fprint(w, browserHelp)
+	b.print(w)
+	scanner := bufio.NewScanner(in)
+	for {
+		fmt.Fprint(w, "> ")
+		if !scanner.Scan() {
+			fmt.Fprintln(w)
+			return
+		}
+		command, arg, _ := strings.Cut(strings.TrimSpace(scanner.Text()), " ")
+		switch command {
+		case "":
+			continue
+		case "q":
+			return
+		case "?", "h", "help":
+			fmt.Fprint(w, browserHelp)
+			continue
+		case "b":
+			switch {
+			case b.key >= 0:
+				b.key = -1
+			case b.serviceAccount >= 0:
+				b.serviceAccount = -1
+			default:
+				b.project = -1
+			}
+		case "f":
+			// the rows change, so go back to the top
+			b.filter = strings.ToLower(strings.TrimSpace(arg))
+			b.project, b.serviceAccount, b.key = -1, -1, -1
+		case "c":
+			if b.key < 0 {
+				fmt.Fprintln(w, "Open a key first")
+				continue
+			}
+			key := b.keys(b.serviceAccounts(b.projects()[b.project])[b.serviceAccount])[b.key]
+			commands := remediationCommands(key)
+			if commands == nil {
+				fmt.Fprintln(w, "System managed keys can't be removed, they are managed by GCP")
+				continue
+			}
+			copyToClipboard(w, strings.Join(commands, "\n")+"\n")
+			fmt.Fprintln(w, "Copied the remediation commands to the clipboard")
+			continue
+		default:
+			n, err := strconv.Atoi(command)
+			if err != nil || n < 1 || n > b.rows {
+				fmt.Fprintf(w, "Unknown command %q, ? for help\n", command)
+				continue
+			}
+			switch {
+			case b.project < 0:
+				b.project = n - 1
+			case b.serviceAccount < 0:
+				b.serviceAccount = n - 1
+			default:
+				b.key = n - 1
+			}
+		}
+		b.print(w)
+	}
+}
+
+// Only makes sense when a person is typing the commands
+func stdinIsTerminal() bool {
+	info, err := os.Stdin.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+const DEFAULT_UNIVERSE_DOMAIN = "googleapis.com"
+const DEFAULT_SERVICE_ACCOUNT_DOMAIN = "iam.gserviceaccount.com"
+
+// The domain of the APIs and the x509 endpoint, other than googleapis.com for Trusted Partner Cloud and sovereign
+// deployments
+var universeDomain = DEFAULT_UNIVERSE_DOMAIN
+
+// The domain of the user managed service accounts, with the project before it
+var userManagedServiceAccountDomain = "." + DEFAULT_SERVICE_ACCOUNT_DOMAIN
+
+var hostname = regexp.MustCompile(`^[a-z0-9-]+(?:\.[a-z0-9-]+)+$`)
+
+func setupUniverse(domain string, serviceAccountDomain string) error {
+	domain = strings.ToLower(strings.TrimSpace(domain))
+	serviceAccountDomain = strings.ToLower(strings.TrimSpace(serviceAccountDomain))
+	if !hostname.MatchString(domain) {
+		return fmt.Errorf("invalid --universe-domain %q, must be a domain like %v", domain, DEFAULT_UNIVERSE_DOMAIN)
+	}
+	if !hostname.MatchString(serviceAccountDomain) {
+		return fmt.Errorf("invalid --service-account-domain %q, must be a domain like %v", serviceAccountDomain, DEFAULT_SERVICE_ACCOUNT_DOMAIN)
+	}
+	universeDomain = domain
+	userManagedServiceAccountDomain = "." + serviceAccountDomain
+	return nil
+}
+
+func isDefaultUniverse() bool {
+	return universeDomain == DEFAULT_UNIVERSE_DOMAIN
+}
+
+// The public endpoints with the certificates (x509) or keys (jwk) of a service account
+func metadataURL(format string, sa string) string {
+	return "https://www." + universeDomain + "/service_accounts/v1/metadata/" + format + "/" + url.PathEscape(sa)
+}
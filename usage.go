@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/api/option"
+	"google.golang.org/grpc"
+)
+
+const (
+	API_X509 = "x509 endpoint"
+	API_JWK  = "JWK endpoint"
+)
+
+// The requests made to each API during the run, with every retry and page counted, for --api-usage
+type APIUsage struct {
+	mu sync.Mutex
+	// API -> unix second -> requests made in it
+	perSecond map[string]map[int64]int
+}
+
+// nil unless --api-usage
+var apiUsage *APIUsage
+
+func NewAPIUsage() *APIUsage {
+	return &APIUsage{perSecond: map[string]map[int64]int{}}
+}
+
+// Does nothing without --api-usage
+func (u *APIUsage) record(api string) {
+	if u == nil {
+		return
+	}
+	now := time.Now().Unix()
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.perSecond[api] == nil {
+		u.perSecond[api] = map[int64]int{}
+	}
+	u.perSecond[api][now]++
+}
+
+// The APIs are told apart by their host, like iam.googleapis.com
+func apiFromHost(host string) string {
+	host = strings.TrimPrefix(host, "dns:///")
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		return h
+	}
+	return host
+}
+
+// Counts the requests of the REST API clients, below the credentials so that each retry is counted
+type countingTransport struct {
+	base http.RoundTripper
+}
+
+func (t *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	apiUsage.record(apiFromHost(req.URL.Host))
+	return t.base.RoundTrip(req)
+}
+
+// Counts the requests of the gRPC clients, which are all unary calls
+func countingGRPCOption() option.ClientOption {
+	return option.WithGRPCDialOption(grpc.WithChainUnaryInterceptor(func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		apiUsage.record(apiFromHost(cc.Target()))
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}))
+}
+
+type APIUsageReport struct {
+	API      string `json:"api"`
+	Requests int    `json:"requests"`
+	// the most requests made in any one second, and in any 60 seconds, which is what most quotas are per
+	PeakPerSecond int `json:"peakPerSecond"`
+	PeakPerMinute int `json:"peakPerMinute"`
+}
+
+func (u *APIUsage) report() []APIUsageReport {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	res := []APIUsageReport{}
+	for _, api := range slices.Sorted(maps.Keys(u.perSecond)) {
+		r := APIUsageReport{API: api}
+		seconds := slices.Sorted(maps.Keys(u.perSecond[api]))
+		// a sliding window over the seconds with requests in them
+		start, window := 0, 0
+		for _, second := range seconds {
+			n := u.perSecond[api][second]
+			r.Requests += n
+			r.PeakPerSecond = max(r.PeakPerSecond, n)
+			window += n
+			for seconds[start] <= second-60 {
+				window -= u.perSecond[api][seconds[start]]
+				start++
+			}
+			r.PeakPerMinute = max(r.PeakPerMinute, window)
+		}
+		res = append(res, r)
+	}
+	return res
+}
+
+func printAPIUsage(usage []APIUsageReport) {
+	fmt.Println("API usage:")
+	if len(usage) == 0 {
+		fmt.Println("  no requests")
+	}
+	for _, r := range usage {
+		fmt.Printf("  %v: %d requests, peak %d per second, %d per minute\n", r.API, r.Requests, r.PeakPerSecond, r.PeakPerMinute)
+	}
+}
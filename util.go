@@ -1,32 +1,146 @@
 package main
 
 import (
+	"context"
 	"errors"
+	"flag"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
 )
 
 // Why isn't this in the standard library...?
-func parllelMap[I any, O any](items []I, f func(I) (O, error)) ([]O, error) {
+// Runs f over the items with at most ParallelMapWorkers goroutines, so memory stays flat for large inputs
+// Once ctx is cancelled no more items are started, and the error includes ctx.Err(). The results of the items which
+// were done are returned along with the error, so an interrupted run can still report them
+func parllelMap[I any, O any](ctx context.Context, items []I, f func(context.Context, I) (O, error)) ([]O, error) {
 	res := make([]O, len(items))
 	errs := make([]error, len(items))
+
+	indices := make(chan int)
 	var wg sync.WaitGroup
-	wg.Add(len(items))
-	for i, item := range items {
-		i := i
-		item := item
+	for range min(ParallelMapWorkers, len(items)) {
+		wg.Add(1)
 		go func() {
 			defer wg.Done()
-			r, err := f(item)
-			res[i] = r
-			errs[i] = err
+			for i := range indices {
+				res[i], errs[i] = f(ctx, items[i])
+			}
 		}()
 	}
+
+feed:
+	for i := range items {
+		select {
+		case <-ctx.Done():
+			break feed
+		case indices <- i:
+		}
+	}
+	close(indices)
 	wg.Wait()
 
-	final_err := errors.Join(errs...)
-	if final_err != nil {
-		return nil, final_err
+	return res, errors.Join(append(errs, ctx.Err())...)
+}
+
+// Rounded to the largest unit which fits, like "3 hours", "12 days" or "2.5 years"
+// Negative durations are written the same as positive ones, so callers with a time which may be in the future (like
+// the NotBefore of a certificate) have to check which side of now it is on to say "ago" or "in"
+func humanDuration(d time.Duration) string {
+	d = d.Abs()
+	const day = 24 * time.Hour
+	plural := func(n int, unit string) string {
+		if n == 1 {
+			return "1 " + unit
+		}
+		return strconv.Itoa(n) + " " + unit + "s"
+	}
+	switch {
+	case d < time.Hour:
+		return plural(int(d/time.Minute), "minute")
+	case d < 2*day:
+		return plural(int(d/time.Hour), "hour")
+	case d < 365*day:
+		return plural(int(d/day), "day")
+	}
+	return strconv.FormatFloat(float64(d)/float64(365*day), 'f', 1, 64) + " years"
+}
+
+// Like time.ParseDuration, but also accepts a number of days like "90d"
+func parseDuration(s string) (time.Duration, error) {
+	if days, found := strings.CutSuffix(s, "d"); found {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, errors.New("invalid number of days: " + s)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(s)
+}
+
+type durationValue time.Duration
+
+func (d *durationValue) Set(s string) error {
+	v, err := parseDuration(s)
+	if err != nil {
+		return err
+	}
+	// none of the durations (ages, windows, TTLs) make sense in the past
+	if v < 0 {
+		return errors.New("duration can't be negative: " + s)
+	}
+	*d = durationValue(v)
+	return nil
+}
+
+func (d *durationValue) String() string {
+	return time.Duration(*d).String()
+}
+
+// Like flag.Duration, but also accepts a number of days like "90d"
+func durationFlag(name string, value time.Duration, usage string) *time.Duration {
+	p := &value
+	flag.Var((*durationValue)(p), name, usage)
+	return p
+}
+
+type stringSliceValue []string
+
+func (s *stringSliceValue) Set(v string) error {
+	*s = append(*s, v)
+	return nil
+}
+
+func (s *stringSliceValue) String() string {
+	return strings.Join(*s, ",")
+}
+
+// A flag which can be repeated, collecting all of the values given
+func stringSliceFlag(name string, usage string) *[]string {
+	var p []string
+	flag.Var((*stringSliceValue)(&p), name, usage)
+	return &p
+}
+
+type stringListValue []string
+
+func (s *stringListValue) Set(v string) error {
+	for _, part := range strings.Split(v, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			*s = append(*s, part)
+		}
 	}
+	return nil
+}
+
+func (s *stringListValue) String() string {
+	return strings.Join(*s, ",")
+}
 
-	return res, nil
+// A flag which can be repeated or given a comma separated list, collecting all of the values given
+func stringListFlag(name string, usage string) *[]string {
+	var p []string
+	flag.Var((*stringListValue)(&p), name, usage)
+	return &p
 }
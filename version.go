@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+)
+
+const TOOL_NAME = "gcp-sa-key-checker"
+
+// Set with -ldflags "-X main.version=v1.2.3" by release builds, otherwise the module version from the build info,
+// which go install sets
+var version = ""
+
+func toolVersion() string {
+	if version != "" {
+		return version
+	}
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+	if info.Main.Version != "" && info.Main.Version != "(devel)" {
+		return info.Main.Version
+	}
+	// a build from a checkout, which go build stamps with the commit
+	for _, setting := range info.Settings {
+		if setting.Key == "vcs.revision" && len(setting.Value) >= 12 {
+			return "devel-" + setting.Value[:12]
+		}
+	}
+	return "devel"
+}
+
+// Sent with every request, so the traffic can be told apart in the audit logs and quota dashboards
+func userAgent() string {
+	return TOOL_NAME + "/" + toolVersion()
+}
+
+func printVersion() {
+	fmt.Printf("%v %v (%v, %v/%v)\n", TOOL_NAME, toolVersion(), runtime.Version(), runtime.GOOS, runtime.GOARCH)
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range info.Settings {
+			if setting.Key == "vcs.revision" || setting.Key == "vcs.time" || setting.Key == "vcs.modified" {
+				fmt.Printf("  %v: %v\n", setting.Key, setting.Value)
+			}
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rsa"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"math/big"
+	"os"
+	"strings"
+	"sync"
+)
+
+// The moduli of keys generated by the vulnerable Infineon library (ROCA, CVE-2017-15361) are all of the form
+// k*M + (65537^a mod M), so modulo each of these small primes they are in the subgroup generated by 65537
+// https://crocs.fi.muni.cz/public/papers/rsa_ccs17
+var rocaPrimes = []int64{3, 5, 7, 11, 13, 17, 19, 23, 29, 31, 37, 41, 43, 47, 53, 59, 61, 67, 71, 73, 79, 83, 89, 97, 101, 103, 107, 109, 113, 127, 131, 137, 139, 149, 151, 157, 163, 167}
+
+// prime -> the residues in the subgroup generated by 65537
+var rocaSubgroups = func() map[int64]map[int64]bool {
+	res := map[int64]map[int64]bool{}
+	for _, p := range rocaPrimes {
+		subgroup := map[int64]bool{}
+		for r := int64(1); !subgroup[r]; r = r * 65537 % p {
+			subgroup[r] = true
+		}
+		res[p] = subgroup
+	}
+	return res
+}()
+
+// A random modulus has a negligible chance of matching every prime, so this has practically no false positives
+func isROCAVulnerable(publicKey *rsa.PublicKey) bool {
+	var r big.Int
+	for _, p := range rocaPrimes {
+		if !rocaSubgroups[p][r.Mod(publicKey.N, big.NewInt(p)).Int64()] {
+			return false
+		}
+	}
+	return true
+}
+
+// The primes below SmallFactorBound, in groups whose product fits in a uint64, so each group only needs one division
+// of the modulus
+type primeGroup struct {
+	product uint64
+	primes  []uint64
+}
+
+var smallPrimeGroups = sync.OnceValue(func() []primeGroup {
+	var res []primeGroup
+	composite := make([]bool, SmallFactorBound)
+	group := primeGroup{product: 1}
+	for i := 2; i < SmallFactorBound; i++ {
+		if composite[i] {
+			continue
+		}
+		for j := i * i; j < SmallFactorBound; j += i {
+			composite[j] = true
+		}
+		p := uint64(i)
+		if group.product > math.MaxUint64/p {
+			res = append(res, group)
+			group = primeGroup{product: 1}
+		}
+		group.product *= p
+		group.primes = append(group.primes, p)
+	}
+	return append(res, group)
+})
+
+// Returns a prime factor of the modulus below SmallFactorBound, or 0 if there is none. Properly generated moduli
+// are the product of two large primes, so this means the key was generated by broken or malicious tooling
+func smallFactor(publicKey *rsa.PublicKey) uint64 {
+	var r, q big.Int
+	for _, group := range smallPrimeGroups() {
+		rem := r.Mod(publicKey.N, q.SetUint64(group.product)).Uint64()
+		for _, p := range group.primes {
+			if rem%p == 0 {
+				return p
+			}
+		}
+	}
+	return 0
+}
+
+// Fingerprints of the keys generated by Debian's broken OpenSSL (CVE-2008-0166), from --weak-keys files, nil unless
+// any were given. Only the last 20 hex digits are kept, as that is all the openssl-blacklist files have
+var weakKeyBlocklist map[string]bool
+
+// Reads blocklists in the format of the openssl-blacklist package (like blacklist.RSA-2048), one SHA-1 of
+// "Modulus=<HEX>\n" per line, or its last 20 hex digits, with # comments
+func loadWeakKeyBlocklist(paths []string) error {
+	weakKeyBlocklist = map[string]bool{}
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			return fmt.Errorf("error reading weak key blocklist: %v", err)
+		}
+		scanner := bufio.NewScanner(f)
+		for n := 1; scanner.Scan(); n++ {
+			line := strings.ToLower(strings.TrimSpace(scanner.Text()))
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			if _, err := hex.DecodeString(line); err != nil || (len(line) != 20 && len(line) != 40) {
+				f.Close()
+				return fmt.Errorf("%v:%d: expected a SHA-1 fingerprint, or its last 20 hex digits", path, n)
+			}
+			weakKeyBlocklist[line[len(line)-20:]] = true
+		}
+		err = scanner.Err()
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("error reading weak key blocklist %v: %v", path, err)
+		}
+	}
+	return nil
+}
+
+// The fingerprint is of the modulus as printed by openssl rsa -modulus
+func isBlocklistedWeakKey(publicKey *rsa.PublicKey) bool {
+	sum := sha1.Sum([]byte("Modulus=" + strings.ToUpper(publicKey.N.Text(16)) + "\n"))
+	return weakKeyBlocklist[hex.EncodeToString(sum[:])[20:]]
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	asset "cloud.google.com/go/asset/apiv1"
+	"cloud.google.com/go/asset/apiv1/assetpb"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+)
+
+const (
+	INSTANCE_ASSET_TYPE          = "compute.googleapis.com/Instance"
+	CLOUD_RUN_SERVICE_ASSET_TYPE = "run.googleapis.com/Service"
+	NODE_POOL_ASSET_TYPE         = "container.googleapis.com/NodePool"
+)
+
+// A resource running as the service account. A key of a service account which workloads run as is more likely to be
+// in use, and to have been copied out of one of them
+type WorkloadReport struct {
+	AssetType string `json:"assetType"`
+	// the full resource name, like //compute.googleapis.com/projects/p/zones/z/instances/i
+	Name string `json:"name"`
+}
+
+// The fields of the versioned resources of the workloads with the service accounts they run as
+type workloadResource struct {
+	// instances
+	Status          string `json:"status"`
+	ServiceAccounts []struct {
+		Email string `json:"email"`
+	} `json:"serviceAccounts"`
+	// node pools
+	Config struct {
+		ServiceAccount string `json:"serviceAccount"`
+	} `json:"config"`
+	// Cloud Run services, in the Knative form
+	Spec struct {
+		Template struct {
+			Spec struct {
+				ServiceAccountName string `json:"serviceAccountName"`
+			} `json:"spec"`
+		} `json:"template"`
+	} `json:"spec"`
+}
+
+// The service accounts the workload runs as. Node pools and Cloud Run services without one run as the Compute Engine
+// default service account, which is left out as its email has the project number
+func (w *workloadResource) serviceAccounts(assetType string) []string {
+	var res []string
+	switch assetType {
+	case INSTANCE_ASSET_TYPE:
+		// stopped instances can't use their service account
+		if w.Status != "RUNNING" {
+			return nil
+		}
+		for _, sa := range w.ServiceAccounts {
+			res = append(res, sa.Email)
+		}
+	case NODE_POOL_ASSET_TYPE:
+		if w.Config.ServiceAccount != "" && w.Config.ServiceAccount != "default" {
+			res = append(res, w.Config.ServiceAccount)
+		}
+	case CLOUD_RUN_SERVICE_ASSET_TYPE:
+		if sa := w.Spec.Template.Spec.ServiceAccountName; strings.Contains(sa, "@") {
+			res = append(res, sa)
+		}
+	}
+	return res
+}
+
+// Finds the workloads under the scope with one asset search, returns a map of service account email to the workloads
+// running as it
+func getWorkloadsViaAssetInventory(ctx context.Context, c *asset.Client, scope string) (map[string][]WorkloadReport, error) {
+	res := map[string][]WorkloadReport{}
+	for r, err := range c.SearchAllResources(ctx, &assetpb.SearchAllResourcesRequest{
+		Scope:      scope,
+		AssetTypes: []string{INSTANCE_ASSET_TYPE, CLOUD_RUN_SERVICE_ASSET_TYPE, NODE_POOL_ASSET_TYPE},
+		ReadMask:   &fieldmaskpb.FieldMask{Paths: []string{"name", "asset_type", "versioned_resources"}},
+		PageSize:   500, // max,
+	}).All() {
+		if err != nil {
+			return nil, err
+		}
+		if len(r.VersionedResources) == 0 {
+			continue
+		}
+		data, err := json.Marshal(r.VersionedResources[0].Resource.AsMap())
+		if err != nil {
+			return nil, err
+		}
+		var w workloadResource
+		if err := json.Unmarshal(data, &w); err != nil {
+			return nil, fmt.Errorf("error unmarshaling asset %v: %v", r.Name, err)
+		}
+		for _, sa := range w.serviceAccounts(r.AssetType) {
+			sa = strings.ToLower(sa)
+			res[sa] = append(res[sa], WorkloadReport{AssetType: r.AssetType, Name: r.Name})
+		}
+	}
+	return res, nil
+}
+
+// Looks up the workloads running as the service accounts with one asset search per --scope, or per project of the
+// service accounts if there are no scopes, in which case only the workloads in their own project are found
+func (k *KeyCollection) FetchWorkloads(ctx context.Context) error {
+	c, err := asset.NewClient(ctx, grpcClientOptions()...)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	if k.workloads == nil {
+		k.workloads = map[string][]WorkloadReport{}
+	}
+	for _, scope := range k.assetScopes("workload lookup") {
+		workloads, err := getWorkloadsViaAssetInventory(ctx, c, scope)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		// the workloads are informational, so the keys are still reported without them
+		if err != nil {
+			slog.Warn("Error searching the workloads", "scope", scope, "error", err)
+			k.failedScopes["workload lookup/"+scope] = true
+			continue
+		}
+		for sa, w := range workloads {
+			k.workloads[sa] = append(k.workloads[sa], w...)
+		}
+	}
+	return nil
+}
+
+// searchFailed is whether a search which could have found workloads of the service account failed
+func printWorkloads(workloads []WorkloadReport, searchFailed bool) {
+	switch {
+	case len(workloads) == 0 && searchFailed:
+		fmt.Println("  Workloads: unknown (lookup failed)")
+		return
+	case len(workloads) == 0:
+		fmt.Println("  Workloads: none, no running instances, Cloud Run services or node pools run as the service account")
+		return
+	case searchFailed:
+		fmt.Printf("  Workloads: %d running as the service account (a lookup failed, so there may be more)\n", len(workloads))
+	default:
+		fmt.Printf("  Workloads: %d running as the service account\n", len(workloads))
+	}
+	for _, w := range workloads {
+		fmt.Printf("    %v %v\n", w.AssetType, w.Name)
+	}
+}